@@ -11,3 +11,9 @@ BUILD_DIR=$(dirname $0)
 docker build -t go-rainbow:latest  \
              -t go-rainbow:$BUILD_TAG \
              $BUILD_DIR
+
+# Build the static image(CGO_ENABLED=0) without librdkafka.
+docker build --target static \
+             -t go-rainbow:static \
+             -t go-rainbow:$BUILD_TAG-static \
+             $BUILD_DIR
@@ -0,0 +1,3 @@
+// Package alert evaluates alert rules on consumer lags polled from Burrow,
+// and posts firing and resolved alerts to webhooks.
+package alert
@@ -0,0 +1,387 @@
+package alert
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/harbinzhang/goRainbow/core/module"
+	"github.com/harbinzhang/goRainbow/core/protocol"
+)
+
+// State is state of an alert.
+type State string
+
+const (
+	// StatePending is an alert whose condition is true, but not for For yet.
+	StatePending State = "pending"
+	// StateFiring is an alert whose condition is true for For.
+	StateFiring State = "firing"
+	// StateResolved is a firing alert whose condition is false now, or whose consumer is gone.
+	StateResolved State = "resolved"
+)
+
+// Alert is a rule on a consumer group.
+type Alert struct {
+	Rule         string    `json:"rule"`
+	Condition    Condition `json:"condition"`
+	Cluster      string    `json:"cluster"`
+	Group        string    `json:"group"`
+	State        State     `json:"state"`
+	Value        float64   `json:"value"`
+	Threshold    float64   `json:"threshold"`
+	BurrowStatus string    `json:"burrowStatus"`
+	StartsAt     int64     `json:"startsAt"`
+	EndsAt       int64     `json:"endsAt,omitempty"`
+	UpdatedAt    int64     `json:"updatedAt"`
+}
+
+// Notification is what posted to webhooks, alerts are grouped by rule and cluster.
+type Notification struct {
+	GroupKey string  `json:"groupKey"`
+	Rule     string  `json:"rule"`
+	Cluster  string  `json:"cluster"`
+	Firing   int     `json:"firing"`
+	Resolved int     `json:"resolved"`
+	Alerts   []Alert `json:"alerts"`
+}
+
+type alertKey struct {
+	rule    string
+	cluster string
+	group   string
+}
+
+type sample struct {
+	timestamp int64
+	lag       int
+}
+
+type alertState struct {
+	alert        Alert
+	lastNotified int64
+	// samples of total lag in Window, for lagIncreasing.
+	samples []sample
+}
+
+// Engine evaluates Rules on every LagInfo of consumer groups.
+// A firing alert is notified once, then every RepeatInterval until it's resolved.
+// Notifications are collected for GroupInterval, deduplicated and grouped by rule and cluster,
+// then posted to all Webhooks.
+// Usage:
+// engine.Init()
+// go engine.Start()
+// engine.Observe(lagInfo)
+// engine.Stop()
+type Engine struct {
+	Rules          []*Rule
+	Webhooks       []*Webhook
+	RepeatInterval time.Duration
+	GroupInterval  time.Duration
+	OffsetHistory  *module.OffsetHistory
+	CountService   *module.CountService
+	Logger         *zap.Logger
+
+	mutex       sync.Mutex
+	states      map[alertKey]*alertState
+	notifyQueue chan Alert
+	stopChannel chan struct{}
+	doneChannel chan struct{}
+}
+
+// Init is a general init
+func (e *Engine) Init() {
+	if e.RepeatInterval == 0 {
+		e.RepeatInterval = time.Hour
+	}
+	if e.GroupInterval == 0 {
+		e.GroupInterval = 10 * time.Second
+	}
+	e.states = make(map[alertKey]*alertState)
+	e.notifyQueue = make(chan Alert, 1000)
+	e.stopChannel = make(chan struct{})
+	e.doneChannel = make(chan struct{})
+}
+
+// Start is a general start, it posts notifications every GroupInterval until Stop is called.
+func (e *Engine) Start() {
+	defer e.Logger.Sync()
+	defer close(e.doneChannel)
+
+	ticker := time.NewTicker(e.GroupInterval)
+	defer ticker.Stop()
+
+	var batch []Alert
+	for {
+		select {
+		case alert := <-e.notifyQueue:
+			batch = append(batch, alert)
+		case <-ticker.C:
+			e.flush(batch)
+			batch = nil
+		case <-e.stopChannel:
+			for len(e.notifyQueue) > 0 {
+				batch = append(batch, <-e.notifyQueue)
+			}
+			e.flush(batch)
+			return
+		}
+	}
+}
+
+// Stop posts notifications left and waits until engine exits, Observe should not be called after.
+func (e *Engine) Stop() error {
+	close(e.stopChannel)
+	<-e.doneChannel
+	return nil
+}
+
+// Observe evaluates all rules matched by a LagInfo, LagInfo of a group should be observed in order.
+func (e *Engine) Observe(lagInfo protocol.LagInfo) {
+	if e == nil {
+		return
+	}
+	status := lagInfo.Lag.Status
+	timestamp := lagInfo.Timestamp
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for _, rule := range e.Rules {
+		if !rule.matches(lagInfo.Lag) {
+			continue
+		}
+		key := alertKey{rule: rule.Name, cluster: status.Cluster, group: status.Group}
+		state, ok := e.states[key]
+		if !ok {
+			state = &alertState{}
+			e.states[key] = state
+		}
+
+		// a rule of topics is evaluated on their partitions, not the whole consumer group.
+		scoped := protocol.LagInfo{Lag: rule.scope(lagInfo.Lag), Timestamp: timestamp}
+		value, active, known := e.evaluate(rule, state, scoped)
+		if !known {
+			// e.g. lag in seconds without head offset history, alert keeps its state.
+			continue
+		}
+
+		alert := &state.alert
+		if !active {
+			if alert.State == StateFiring {
+				alert.State = StateResolved
+				alert.Value = value
+				alert.BurrowStatus = status.Status
+				alert.EndsAt = timestamp
+				alert.UpdatedAt = timestamp
+				e.notify(*alert)
+			}
+			state.alert = Alert{}
+			if len(state.samples) == 0 {
+				delete(e.states, key)
+			}
+			continue
+		}
+
+		if alert.State == "" {
+			*alert = Alert{
+				Rule:      rule.Name,
+				Condition: rule.Condition,
+				Cluster:   status.Cluster,
+				Group:     status.Group,
+				State:     StatePending,
+				Threshold: rule.Threshold,
+				StartsAt:  timestamp,
+			}
+		}
+		alert.Value = value
+		alert.BurrowStatus = status.Status
+		alert.UpdatedAt = timestamp
+
+		switch {
+		case alert.State == StatePending && timestamp-alert.StartsAt >= int64(rule.For/time.Second):
+			alert.State = StateFiring
+			state.lastNotified = timestamp
+			e.notify(*alert)
+		case alert.State == StateFiring && timestamp-state.lastNotified >= int64(e.RepeatInterval/time.Second):
+			state.lastNotified = timestamp
+			e.notify(*alert)
+		}
+	}
+}
+
+// evaluate returns value of rule condition, whether it's active, and whether it's known.
+// lagInfo should be scoped by the rule.
+func (e *Engine) evaluate(rule *Rule, state *alertState, lagInfo protocol.LagInfo) (float64, bool, bool) {
+	status := lagInfo.Lag.Status
+	switch rule.Condition {
+	case ConditionTotalLag:
+		value := float64(status.Totallag)
+		return value, value > rule.Threshold, true
+	case ConditionTimeLag:
+		value, known := e.maxTimeLag(lagInfo)
+		return value, value > rule.Threshold, known
+	case ConditionLagIncreasing:
+		state.samples = append(state.samples, sample{timestamp: lagInfo.Timestamp, lag: status.Totallag})
+		// keep one sample before window start, so samples cover the whole window.
+		windowStart := lagInfo.Timestamp - int64(rule.Window/time.Second)
+		for len(state.samples) > 1 && state.samples[1].timestamp <= windowStart {
+			state.samples = state.samples[1:]
+		}
+		increasing := state.samples[0].timestamp <= windowStart
+		for i := 1; i < len(state.samples); i++ {
+			if state.samples[i].lag <= state.samples[i-1].lag {
+				increasing = false
+			}
+		}
+		return float64(status.Totallag), increasing, true
+	case ConditionStatus:
+		return float64(status.Totallag), status.Status != "OK", true
+	}
+	return 0, false, false
+}
+
+// maxTimeLag is max seconds behind head of partitions, it's unknown if no lagging partition has history.
+// Partitions without committed offset are lagging with unknown seconds.
+func (e *Engine) maxTimeLag(lagInfo protocol.LagInfo) (float64, bool) {
+	status := lagInfo.Lag.Status
+	var maxTimeLag int64
+	lagging, known := false, false
+	for _, partition := range status.Partitions {
+		if partition.CurrentLag <= 0 {
+			continue
+		}
+		lagging = true
+		if !partition.HasCommittedOffset() {
+			continue
+		}
+		seconds, ok := e.OffsetHistory.SecondsBehind(status.Cluster, partition.Topic, partition.Partition,
+			partition.End.Offset, lagInfo.Timestamp)
+		if !ok {
+			continue
+		}
+		known = true
+		if seconds > maxTimeLag {
+			maxTimeLag = seconds
+		}
+	}
+	return float64(maxTimeLag), known || !lagging
+}
+
+// Forget resolves firing alerts of a consumer group gone from Burrow, and frees its states.
+func (e *Engine) Forget(cluster string, group string, timestamp int64) {
+	if e == nil {
+		return
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for key, state := range e.states {
+		if key.cluster != cluster || key.group != group {
+			continue
+		}
+		if state.alert.State == StateFiring {
+			state.alert.State = StateResolved
+			state.alert.EndsAt = timestamp
+			state.alert.UpdatedAt = timestamp
+			e.notify(state.alert)
+		}
+		delete(e.states, key)
+	}
+}
+
+// Alerts returns pending and firing alerts sorted by rule, cluster and group.
+func (e *Engine) Alerts() []Alert {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	res := []Alert{}
+	for _, state := range e.states {
+		if state.alert.State != "" {
+			res = append(res, state.alert)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Rule != res[j].Rule {
+			return res[i].Rule < res[j].Rule
+		}
+		if res[i].Cluster != res[j].Cluster {
+			return res[i].Cluster < res[j].Cluster
+		}
+		return res[i].Group < res[j].Group
+	})
+	return res
+}
+
+// Handler is for GET /api/v1/alerts, alerts can be filtered by ?state=pending|firing.
+func (e *Engine) Handler() func(http.ResponseWriter, *http.Request) {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		alerts := e.Alerts()
+		if state := State(r.URL.Query().Get("state")); state != "" {
+			filtered := []Alert{}
+			for _, alert := range alerts {
+				if alert.State == state {
+					filtered = append(filtered, alert)
+				}
+			}
+			alerts = filtered
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"alerts": alerts})
+	}
+}
+
+// notify never blocks, it should be called with mutex held.
+func (e *Engine) notify(alert Alert) {
+	if alert.State == StateFiring {
+		e.CountService.Increase("alertFiring", alert.Cluster)
+	}
+	select {
+	case e.notifyQueue <- alert:
+	default:
+		e.CountService.Increase("exception.alertDropped", alert.Cluster)
+	}
+}
+
+// flush posts alerts to webhooks, only the latest notification of an alert is kept.
+func (e *Engine) flush(batch []Alert) {
+	if len(batch) == 0 {
+		return
+	}
+	latest := make(map[alertKey]Alert)
+	for _, alert := range batch {
+		latest[alertKey{rule: alert.Rule, cluster: alert.Cluster, group: alert.Group}] = alert
+	}
+
+	groups := make(map[string]*Notification)
+	for _, alert := range latest {
+		groupKey := alert.Rule + "/" + alert.Cluster
+		notification, ok := groups[groupKey]
+		if !ok {
+			notification = &Notification{GroupKey: groupKey, Rule: alert.Rule, Cluster: alert.Cluster}
+			groups[groupKey] = notification
+		}
+		if alert.State == StateFiring {
+			notification.Firing++
+		} else {
+			notification.Resolved++
+		}
+		notification.Alerts = append(notification.Alerts, alert)
+	}
+
+	for _, notification := range groups {
+		sort.Slice(notification.Alerts, func(i, j int) bool { return notification.Alerts[i].Group < notification.Alerts[j].Group })
+		for _, webhook := range e.Webhooks {
+			if err := webhook.Send(*notification); err != nil {
+				e.CountService.Increase("exception.alertWebhook", notification.Cluster)
+				e.Logger.Warn("post alerts to webhook failed",
+					zap.String("groupKey", notification.GroupKey),
+					zap.String("error", err.Error()),
+					zap.Int64("timestamp", time.Now().Unix()),
+				)
+			}
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package alert
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/harbinzhang/goRainbow/core/module"
+	"github.com/harbinzhang/goRainbow/core/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func prepareEngine(t *testing.T, configs []protocol.AlertRuleConfig, webhooks ...*Webhook) *Engine {
+	rules, err := NewRules(configs)
+	assert.Nil(t, err)
+	countService := &module.CountService{ProduceQueue: make(chan protocol.Metric, 100)}
+	countService.Start()
+	engine := &Engine{
+		Rules:          rules,
+		Webhooks:       webhooks,
+		RepeatInterval: 10 * time.Minute,
+		GroupInterval:  10 * time.Millisecond,
+		CountService:   countService,
+		Logger:         zap.NewNop(),
+	}
+	engine.Init()
+	return engine
+}
+
+func lagInfo(group string, status string, totalLag int, timestamp int64) protocol.LagInfo {
+	info := protocol.LagInfo{Timestamp: timestamp}
+	info.Lag.Status.Cluster = "test"
+	info.Lag.Status.Group = group
+	info.Lag.Status.Status = status
+	info.Lag.Status.Totallag = totalLag
+	info.Lag.Status.Partitions = []protocol.Partition{{Topic: "topic", Partition: 0, CurrentLag: totalLag}}
+	return info
+}
+
+func drain(engine *Engine) []Alert {
+	var alerts []Alert
+	for len(engine.notifyQueue) > 0 {
+		alerts = append(alerts, <-engine.notifyQueue)
+	}
+	return alerts
+}
+
+func TestNewRules(t *testing.T) {
+	_, err := NewRules([]protocol.AlertRuleConfig{{Name: "a", Condition: "unknown"}})
+	assert.NotNil(t, err)
+	_, err = NewRules([]protocol.AlertRuleConfig{{Name: "a", Condition: "lagIncreasing"}})
+	assert.NotNil(t, err, "lagIncreasing needs a window")
+	_, err = NewRules([]protocol.AlertRuleConfig{{Name: "a", Condition: "status"}, {Name: "a", Condition: "status"}})
+	assert.NotNil(t, err, "duplicated rule name")
+
+	rules, err := NewRules([]protocol.AlertRuleConfig{{Name: "a", Condition: "status", Group: "^app-", Topic: "^topic$"}})
+	assert.Nil(t, err)
+	assert.True(t, rules[0].matches(lagInfo("app-1", "OK", 0, 0).Lag))
+	assert.False(t, rules[0].matches(lagInfo("other", "OK", 0, 0).Lag))
+	info := lagInfo("app-1", "OK", 0, 0)
+	info.Lag.Status.Partitions[0].Topic = "payments"
+	assert.False(t, rules[0].matches(info.Lag), "no partition of topic")
+}
+
+func TestTotalLagAlert(t *testing.T) {
+	engine := prepareEngine(t, []protocol.AlertRuleConfig{{Name: "lag", Condition: "totalLag", Threshold: 100, ForSeconds: 60}})
+
+	engine.Observe(lagInfo("group", "WARN", 200, 1000))
+	assert.Equal(t, StatePending, engine.Alerts()[0].State)
+	assert.Equal(t, 0, len(drain(engine)), "pending alert is not notified")
+
+	engine.Observe(lagInfo("group", "WARN", 300, 1060))
+	alerts := drain(engine)
+	assert.Equal(t, 1, len(alerts))
+	assert.Equal(t, StateFiring, alerts[0].State)
+	assert.Equal(t, int64(1000), alerts[0].StartsAt)
+	assert.Equal(t, float64(300), alerts[0].Value)
+
+	engine.Observe(lagInfo("group", "WARN", 300, 1090))
+	assert.Equal(t, 0, len(drain(engine)), "firing alert is deduplicated")
+	engine.Observe(lagInfo("group", "WARN", 300, 1060+600))
+	assert.Equal(t, 1, len(drain(engine)), "firing alert is repeated")
+
+	engine.Observe(lagInfo("group", "OK", 0, 1700))
+	alerts = drain(engine)
+	assert.Equal(t, StateResolved, alerts[0].State)
+	assert.Equal(t, int64(1700), alerts[0].EndsAt)
+	assert.Equal(t, 0, len(engine.Alerts()))
+}
+
+func TestLagIncreasingAlert(t *testing.T) {
+	engine := prepareEngine(t, []protocol.AlertRuleConfig{{Name: "increasing", Condition: "lagIncreasing", WindowSeconds: 60}})
+
+	engine.Observe(lagInfo("group", "OK", 10, 1000))
+	engine.Observe(lagInfo("group", "OK", 20, 1030))
+	assert.Equal(t, 0, len(engine.Alerts()), "samples don't cover the window")
+	engine.Observe(lagInfo("group", "OK", 30, 1060))
+	assert.Equal(t, StateFiring, drain(engine)[0].State)
+
+	engine.Observe(lagInfo("group", "OK", 30, 1090))
+	assert.Equal(t, StateResolved, drain(engine)[0].State, "lag stops increasing")
+}
+
+func TestTopicScopedAlert(t *testing.T) {
+	engine := prepareEngine(t, []protocol.AlertRuleConfig{
+		{Name: "ordersLag", Condition: "totalLag", Threshold: 100, Topic: "^orders$"},
+		{Name: "ordersStatus", Condition: "status", Topic: "^orders$"},
+	})
+
+	info := lagInfo("group", "ERR", 510, 1000)
+	info.Lag.Status.Partitions = []protocol.Partition{
+		{Topic: "orders", Partition: 0, CurrentLag: 10, Status: "OK"},
+		{Topic: "payments", Partition: 0, CurrentLag: 500, Status: "STOP"},
+	}
+	engine.Observe(info)
+	assert.Equal(t, 0, len(engine.Alerts()), "lag and status of other topics are not evaluated")
+
+	info.Lag.Status.Partitions[0].CurrentLag = 200
+	info.Lag.Status.Partitions[0].Status = "WARN"
+	engine.Observe(info)
+	alerts := drain(engine)
+	assert.Equal(t, 2, len(alerts))
+	for _, alert := range alerts {
+		assert.Equal(t, StateFiring, alert.State)
+		assert.Equal(t, float64(200), alert.Value, "value is lag of orders")
+		assert.Equal(t, "ERR", alert.BurrowStatus, "Burrow status is of the consumer group")
+	}
+}
+
+func TestStatusAlertForget(t *testing.T) {
+	engine := prepareEngine(t, []protocol.AlertRuleConfig{{Name: "status", Condition: "status"}})
+
+	engine.Observe(lagInfo("group", "STALL", 0, 1000))
+	assert.Equal(t, StateFiring, drain(engine)[0].State, "fires at once without forSeconds")
+
+	engine.Forget("test", "group", 1010)
+	alerts := drain(engine)
+	assert.Equal(t, StateResolved, alerts[0].State)
+	assert.Equal(t, 0, len(engine.Alerts()))
+}
+
+func TestTimeLagAlert(t *testing.T) {
+	engine := prepareEngine(t, []protocol.AlertRuleConfig{{Name: "timeLag", Condition: "timeLag", Threshold: 60}})
+	engine.Observe(lagInfo("group", "WARN", 100, 1000))
+	assert.Equal(t, 0, len(engine.Alerts()), "unknown without offset history")
+
+	engine.OffsetHistory = &module.OffsetHistory{}
+	engine.OffsetHistory.Init()
+	engine.OffsetHistory.Record("test", "topic", 0, 0, 800)
+	engine.OffsetHistory.Record("test", "topic", 0, 200, 1000)
+	info := lagInfo("group", "WARN", 100, 1000)
+	info.Lag.Status.Partitions[0].End.Offset = 99
+	engine.Observe(info)
+	alerts := drain(engine)
+	assert.Equal(t, float64(100), alerts[0].Value, "message 99 is produced at 900")
+
+	info = lagInfo("pushed", "WARN", 100, 1000)
+	info.Lag.Status.Partitions[0].OffsetsUnknown = true
+	engine.Observe(info)
+	for _, alert := range engine.Alerts() {
+		assert.NotEqual(t, "pushed", alert.Group, "lag in seconds is unknown without committed offset")
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var mutex sync.Mutex
+	var notifications []Notification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var notification Notification
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&notification))
+		assert.Equal(t, "token", r.Header.Get("Authorization"))
+		mutex.Lock()
+		notifications = append(notifications, notification)
+		mutex.Unlock()
+	}))
+	defer server.Close()
+
+	webhooks := NewWebhooks([]protocol.WebhookConfig{{URL: server.URL, Headers: map[string]string{"Authorization": "token"}}})
+	engine := prepareEngine(t, []protocol.AlertRuleConfig{{Name: "status", Condition: "status"}}, webhooks...)
+	go engine.Start()
+
+	engine.Observe(lagInfo("a", "ERR", 0, 1000))
+	engine.Observe(lagInfo("b", "ERR", 0, 1000))
+	engine.Observe(lagInfo("b", "OK", 0, 1001))
+	engine.Stop()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, 1, len(notifications), "alerts are grouped by rule and cluster")
+	assert.Equal(t, "status/test", notifications[0].GroupKey)
+	assert.Equal(t, 1, notifications[0].Firing)
+	assert.Equal(t, 1, notifications[0].Resolved, "only the latest notification of b is sent")
+	assert.Equal(t, 2, len(notifications[0].Alerts))
+}
+
+func TestHandler(t *testing.T) {
+	engine := prepareEngine(t, []protocol.AlertRuleConfig{{Name: "lag", Condition: "totalLag", Threshold: 1, ForSeconds: 60}})
+	engine.Observe(lagInfo("a", "WARN", 10, 1000))
+
+	recorder := httptest.NewRecorder()
+	engine.Handler()(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/alerts?state=pending", nil))
+	var body map[string][]Alert
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+	assert.Equal(t, "a", body["alerts"][0].Group)
+
+	recorder = httptest.NewRecorder()
+	engine.Handler()(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/alerts?state=firing", nil))
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+	assert.Equal(t, 0, len(body["alerts"]))
+}
@@ -0,0 +1,135 @@
+package alert
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/harbinzhang/goRainbow/core/protocol"
+)
+
+// Condition is what a rule checks on every poll of a consumer group.
+type Condition string
+
+const (
+	// ConditionTotalLag is total lag above Threshold for For.
+	ConditionTotalLag Condition = "totalLag"
+	// ConditionTimeLag is max lag in seconds of partitions above Threshold for For.
+	ConditionTimeLag Condition = "timeLag"
+	// ConditionLagIncreasing is total lag strictly increasing over Window.
+	ConditionLagIncreasing Condition = "lagIncreasing"
+	// ConditionStatus is Burrow status of consumer group not OK for For.
+	ConditionStatus Condition = "status"
+)
+
+// Rule is an alert rule for consumer groups matched by cluster, group and topic regexps.
+// With a topic regexp, the condition is evaluated on partitions of matched topics only.
+type Rule struct {
+	Name      string
+	Condition Condition
+	Threshold float64
+	For       time.Duration
+	Window    time.Duration
+
+	cluster *regexp.Regexp
+	group   *regexp.Regexp
+	topic   *regexp.Regexp
+}
+
+// NewRules creates rules from config "alerts.rules".
+func NewRules(configs []protocol.AlertRuleConfig) ([]*Rule, error) {
+	var rules []*Rule
+	names := make(map[string]bool)
+	for _, config := range configs {
+		if config.Name == "" {
+			return nil, fmt.Errorf("alert rule name is required")
+		}
+		if names[config.Name] {
+			return nil, fmt.Errorf("alert rule %q is duplicated", config.Name)
+		}
+		names[config.Name] = true
+
+		rule := &Rule{
+			Name:      config.Name,
+			Condition: Condition(config.Condition),
+			Threshold: config.Threshold,
+			For:       time.Duration(config.ForSeconds) * time.Second,
+			Window:    time.Duration(config.WindowSeconds) * time.Second,
+		}
+		switch rule.Condition {
+		case ConditionTotalLag, ConditionTimeLag, ConditionStatus:
+		case ConditionLagIncreasing:
+			if rule.Window <= 0 {
+				return nil, fmt.Errorf("alert rule %q: windowSeconds is required by lagIncreasing", config.Name)
+			}
+		default:
+			return nil, fmt.Errorf("alert rule %q: condition %q should be totalLag, timeLag, lagIncreasing or status",
+				config.Name, config.Condition)
+		}
+
+		var err error
+		if rule.cluster, err = compile(config.Cluster); err != nil {
+			return nil, fmt.Errorf("alert rule %q: cluster: %v", config.Name, err)
+		}
+		if rule.group, err = compile(config.Group); err != nil {
+			return nil, fmt.Errorf("alert rule %q: group: %v", config.Name, err)
+		}
+		if rule.topic, err = compile(config.Topic); err != nil {
+			return nil, fmt.Errorf("alert rule %q: topic: %v", config.Name, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// compile returns nil for an empty regexp, which matches all.
+func compile(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile(expr)
+}
+
+// matches checks cluster and group of lag, and topic of any partition.
+func (r *Rule) matches(lag protocol.LagStatus) bool {
+	if r.cluster != nil && !r.cluster.MatchString(lag.Status.Cluster) {
+		return false
+	}
+	if r.group != nil && !r.group.MatchString(lag.Status.Group) {
+		return false
+	}
+	if r.topic == nil {
+		return true
+	}
+	for _, partition := range lag.Status.Partitions {
+		if r.topic.MatchString(partition.Topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// scope returns lag of partitions of matched topics, lag is returned as it is if the rule has no topic.
+// Total lag is the sum of those partitions, and status is the first one of them not OK,
+// a partition without status is taken as OK.
+func (r *Rule) scope(lag protocol.LagStatus) protocol.LagStatus {
+	if r.topic == nil {
+		return lag
+	}
+	scoped := lag
+	scoped.Status.Partitions = nil
+	scoped.Status.Totallag = 0
+	scoped.Status.Status = "OK"
+	for _, partition := range lag.Status.Partitions {
+		if !r.topic.MatchString(partition.Topic) {
+			continue
+		}
+		scoped.Status.Partitions = append(scoped.Status.Partitions, partition)
+		scoped.Status.Totallag += partition.CurrentLag
+		if scoped.Status.Status == "OK" && partition.Status != "" && partition.Status != "OK" {
+			scoped.Status.Status = partition.Status
+		}
+	}
+	scoped.Status.PartitionCount = len(scoped.Status.Partitions)
+	return scoped
+}
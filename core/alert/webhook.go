@@ -0,0 +1,73 @@
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/harbinzhang/goRainbow/core/protocol"
+)
+
+// Webhook posts notifications as JSON to an HTTP endpoint.
+type Webhook struct {
+	URL     string
+	Headers map[string]string
+	Timeout time.Duration
+
+	client *http.Client
+}
+
+// NewWebhooks creates webhooks from config "alerts.webhooks".
+func NewWebhooks(configs []protocol.WebhookConfig) []*Webhook {
+	var webhooks []*Webhook
+	for _, config := range configs {
+		webhook := &Webhook{
+			URL:     config.URL,
+			Headers: config.Headers,
+			Timeout: time.Duration(config.TimeoutSeconds) * time.Second,
+		}
+		webhook.Init()
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks
+}
+
+// Init is a general init
+func (wh *Webhook) Init() {
+	if wh.Timeout == 0 {
+		wh.Timeout = 10 * time.Second
+	}
+	wh.client = &http.Client{Timeout: wh.Timeout}
+}
+
+// Send posts a notification, it's not retried.
+func (wh *Webhook) Send(notification Notification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, wh.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range wh.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook got status %d from %s", resp.StatusCode, wh.URL)
+	}
+	return nil
+}
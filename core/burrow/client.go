@@ -0,0 +1,175 @@
+package burrow
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/harbinzhang/goRainbow/core/protocol"
+)
+
+// Client is a typed client of Burrow v3 HTTP API.
+// Usage:
+// client := &Client{BaseURL: "http://127.0.0.1:8000/v3/kafka"}
+// client.Init()
+// clusters, err := client.Clusters(ctx)
+type Client struct {
+	// BaseURL is Burrow v3 kafka endpoint, e.g. http://127.0.0.1:8000/v3/kafka
+	BaseURL string
+	// Timeout is for each HTTP request, 10s by default.
+	Timeout time.Duration
+	// Retries is how many times an Unavailable request is retried.
+	Retries int
+	// Backoff is the first retry delay, it's doubled for every retry up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	httpClient *http.Client
+}
+
+// Init is a general init
+func (c *Client) Init() {
+	c.BaseURL = strings.TrimSuffix(c.BaseURL, "/")
+	if c.Timeout == 0 {
+		c.Timeout = 10 * time.Second
+	}
+	if c.Backoff == 0 {
+		c.Backoff = 500 * time.Millisecond
+	}
+	if c.MaxBackoff == 0 {
+		c.MaxBackoff = 10 * time.Second
+	}
+	c.httpClient = &http.Client{Timeout: c.Timeout}
+}
+
+// Clusters lists clusters, GET /v3/kafka
+func (c *Client) Clusters(ctx context.Context) ([]string, error) {
+	var res struct {
+		Clusters []string `json:"clusters"`
+	}
+	err := c.get(ctx, c.BaseURL, &res)
+	return res.Clusters, err
+}
+
+// Consumers lists consumer groups of cluster, GET /v3/kafka/{cluster}/consumer
+func (c *Client) Consumers(ctx context.Context, cluster string) ([]string, error) {
+	var res struct {
+		Consumers []string `json:"consumers"`
+	}
+	err := c.get(ctx, c.link(cluster, "consumer"), &res)
+	return res.Consumers, err
+}
+
+// ConsumerLag gets lag status of a consumer group, GET /v3/kafka/{cluster}/consumer/{group}/lag
+func (c *Client) ConsumerLag(ctx context.Context, cluster string, group string) (protocol.LagStatus, error) {
+	var res protocol.LagStatus
+	err := c.get(ctx, c.link(cluster, "consumer", group, "lag"), &res)
+	return res, err
+}
+
+// Topics lists topics of cluster, GET /v3/kafka/{cluster}/topic
+func (c *Client) Topics(ctx context.Context, cluster string) ([]string, error) {
+	var res struct {
+		Topics []string `json:"topics"`
+	}
+	err := c.get(ctx, c.link(cluster, "topic"), &res)
+	return res.Topics, err
+}
+
+// TopicOffsets gets head offsets of each partition, GET /v3/kafka/{cluster}/topic/{topic}
+func (c *Client) TopicOffsets(ctx context.Context, cluster string, topic string) (protocol.TopicOffset, error) {
+	var res protocol.TopicOffset
+	err := c.get(ctx, c.link(cluster, "topic", topic), &res)
+	return res, err
+}
+
+func (c *Client) link(parts ...string) string {
+	escaped := make([]string, 0, len(parts)+1)
+	escaped = append(escaped, c.BaseURL)
+	for _, part := range parts {
+		escaped = append(escaped, url.PathEscape(part))
+	}
+	return strings.Join(escaped, "/")
+}
+
+// get retries Unavailable and BadResponse errors with exponential backoff and jitter.
+func (c *Client) get(ctx context.Context, link string, target interface{}) error {
+	var err *Error
+	for attempt := 0; ; attempt++ {
+		err = c.getOnce(ctx, link, target)
+		if err == nil {
+			return nil
+		}
+		if err.Kind == NotFound || attempt >= c.Retries {
+			return err
+		}
+
+		timer := time.NewTimer(c.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return &Error{Kind: Unavailable, URL: link, Message: ctx.Err().Error()}
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff is a random delay in [d/2, d], d = min(Backoff * 2^attempt, MaxBackoff)
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.Backoff << uint(attempt)
+	if d > c.MaxBackoff || d <= 0 {
+		d = c.MaxBackoff
+	}
+	half := int64(d / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+func (c *Client) getOnce(ctx context.Context, link string, target interface{}) *Error {
+	req, err := http.NewRequest(http.MethodGet, link, nil)
+	if err != nil {
+		return &Error{Kind: BadResponse, URL: link, Message: err.Error()}
+	}
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return &Error{Kind: Unavailable, URL: link, Message: err.Error()}
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	if err != nil {
+		return &Error{Kind: Unavailable, URL: link, StatusCode: resp.StatusCode, Message: err.Error()}
+	}
+
+	// Burrow always answers {"error": bool, "message": string, ...}
+	var status struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+	json.Unmarshal(body, &status)
+
+	// Only Burrow itself saying so means the cluster or consumer is gone,
+	// a bare 404 may come from a proxy or a wrong BaseURL.
+	notFound := status.Error && strings.Contains(strings.ToLower(status.Message), "not found")
+
+	switch {
+	case notFound:
+		return &Error{Kind: NotFound, URL: link, StatusCode: resp.StatusCode, Message: status.Message}
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return &Error{Kind: Unavailable, URL: link, StatusCode: resp.StatusCode, Message: status.Message}
+	case resp.StatusCode >= 300:
+		return &Error{Kind: BadResponse, URL: link, StatusCode: resp.StatusCode, Message: status.Message}
+	case status.Error:
+		return &Error{Kind: BadResponse, URL: link, StatusCode: resp.StatusCode, Message: status.Message}
+	}
+
+	if err := json.Unmarshal(body, target); err != nil {
+		return &Error{Kind: BadResponse, URL: link, StatusCode: resp.StatusCode, Message: "decode: " + err.Error()}
+	}
+	return nil
+}
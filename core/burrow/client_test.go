@@ -0,0 +1,150 @@
+package burrow
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestClient(handler http.HandlerFunc) (*Client, *httptest.Server) {
+	server := httptest.NewServer(handler)
+	client := &Client{
+		BaseURL:    server.URL + "/v3/kafka/",
+		Retries:    2,
+		Backoff:    time.Millisecond,
+		MaxBackoff: 5 * time.Millisecond,
+	}
+	client.Init()
+	return client, server
+}
+
+func TestClusters(t *testing.T) {
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v3/kafka", r.URL.Path)
+		w.Write([]byte(`{"error":false,"message":"cluster list returned","clusters":["local","remote"]}`))
+	})
+	defer server.Close()
+
+	clusters, err := client.Clusters(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"local", "remote"}, clusters)
+}
+
+func TestConsumerLag(t *testing.T) {
+	lag, _ := ioutil.ReadFile("../../config/pull_content.json")
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v3/kafka/local/consumer/console-consumer-0/lag", r.URL.Path)
+		w.Write(lag)
+	})
+	defer server.Close()
+
+	status, err := client.ConsumerLag(context.Background(), "local", "console-consumer-0")
+	assert.Nil(t, err)
+	assert.NotEqual(t, 0, len(status.Status.Partitions))
+}
+
+func TestNotFoundIsNotRetried(t *testing.T) {
+	var calls int32
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":true,"message":"cluster or consumer not found"}`))
+	})
+	defer server.Close()
+
+	_, err := client.ConsumerLag(context.Background(), "local", "gone")
+	assert.True(t, IsNotFound(err), "404 means consumer is gone")
+	assert.False(t, IsUnavailable(err))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestBare404IsNotNotFound(t *testing.T) {
+	var calls int32
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 page not found"))
+	})
+	defer server.Close()
+
+	_, err := client.ConsumerLag(context.Background(), "local", "group")
+	assert.NotNil(t, err)
+	assert.False(t, IsNotFound(err), "404 from a proxy or a wrong BaseURL doesn't mean consumer is gone")
+	assert.Equal(t, int32(client.Retries+1), atomic.LoadInt32(&calls))
+}
+
+func TestUnavailableIsRetried(t *testing.T) {
+	var calls int32
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"topics":["a","b"]}`))
+	})
+	defer server.Close()
+
+	topics, err := client.Topics(context.Background(), "local")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b"}, topics)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	atomic.StoreInt32(&calls, -10)
+	_, err = client.Topics(context.Background(), "local")
+	assert.True(t, IsUnavailable(err), "Burrow is still down after retries")
+}
+
+func TestBurrowDown(t *testing.T) {
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {})
+	server.Close()
+
+	_, err := client.Clusters(context.Background())
+	assert.True(t, IsUnavailable(err), "connection refused means Burrow is down")
+}
+
+func TestBadResponse(t *testing.T) {
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"offsets":"not a slice"}`))
+	})
+	defer server.Close()
+
+	_, err := client.TopicOffsets(context.Background(), "local", "topic")
+	assert.NotNil(t, err)
+	assert.False(t, IsNotFound(err))
+	assert.False(t, IsUnavailable(err))
+}
+
+func TestTimeoutAndCancel(t *testing.T) {
+	block := make(chan struct{})
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	})
+	defer server.Close()
+	defer close(block)
+	client.Timeout = 20 * time.Millisecond
+	client.Init()
+
+	_, err := client.Clusters(context.Background())
+	assert.True(t, IsUnavailable(err), "timeout means Burrow is down")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = client.Clusters(ctx)
+	assert.True(t, IsUnavailable(err))
+}
+
+func TestBackoff(t *testing.T) {
+	client := &Client{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	client.Init()
+	for attempt := 0; attempt < 70; attempt++ {
+		d := client.backoff(attempt)
+		assert.True(t, d <= time.Second, "backoff is capped")
+		assert.True(t, d >= 50*time.Millisecond, "backoff is at least Backoff/2")
+	}
+}
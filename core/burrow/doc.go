@@ -0,0 +1,2 @@
+// Package burrow provides a typed client of Burrow v3 HTTP API.
+package burrow
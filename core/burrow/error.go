@@ -0,0 +1,56 @@
+package burrow
+
+import (
+	"fmt"
+)
+
+// ErrorKind tells why a Burrow request failed.
+type ErrorKind int
+
+const (
+	// Unavailable means Burrow is down or overloaded, e.g. connection refused, timeout, 5xx.
+	// It's retried, and the caller should try again later.
+	Unavailable ErrorKind = iota
+	// NotFound means Burrow answered the cluster, consumer or topic is gone.
+	NotFound
+	// BadResponse means Burrow answered something the client doesn't understand.
+	BadResponse
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case Unavailable:
+		return "unavailable"
+	case NotFound:
+		return "not found"
+	default:
+		return "bad response"
+	}
+}
+
+// Error is returned by Client for every failed request.
+type Error struct {
+	Kind       ErrorKind
+	URL        string
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("burrow %s: %s (status %d): %s", e.Kind, e.URL, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("burrow %s: %s: %s", e.Kind, e.URL, e.Message)
+}
+
+// IsNotFound checks if err means the requested cluster, consumer or topic is gone.
+func IsNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.Kind == NotFound
+}
+
+// IsUnavailable checks if err means Burrow is down.
+func IsUnavailable(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.Kind == Unavailable
+}
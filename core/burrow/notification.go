@@ -0,0 +1,146 @@
+package burrow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/harbinzhang/goRainbow/core/protocol"
+)
+
+// ParseNotification parses a post of Burrow HTTP notifier, it accepts:
+// 1. Slack attachments(protocol.LagMessage), one consumer group per attachment.
+// 2. A template carrying the full LagStatus, e.g. {"status": {{.Result | jsonencoder}}},
+// or the bare group status {{.Result | jsonencoder}}.
+func ParseNotification(body []byte) ([]protocol.LagStatus, error) {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return nil, fmt.Errorf("notification is not a JSON object: %v", err)
+	}
+
+	switch {
+	case probe["attachments"] != nil:
+		var message protocol.LagMessage
+		if err := json.Unmarshal(body, &message); err != nil {
+			return nil, fmt.Errorf("invalid slack attachments: %v", err)
+		}
+		return parseLagMessage(message)
+	case probe["status"] != nil && probe["status"][0] == '{':
+		var lag protocol.LagStatus
+		if err := json.Unmarshal(body, &lag); err != nil {
+			return nil, fmt.Errorf("invalid lag status: %v", err)
+		}
+		return checkLagStatus(lag)
+	case probe["group"] != nil:
+		var lag protocol.LagStatus
+		if err := json.Unmarshal(body, &lag.Status); err != nil {
+			return nil, fmt.Errorf("invalid group status: %v", err)
+		}
+		return checkLagStatus(lag)
+	}
+	return nil, errors.New("notification should be slack attachments or a lag status")
+}
+
+func checkLagStatus(lag protocol.LagStatus) ([]protocol.LagStatus, error) {
+	if lag.Status.Cluster == "" || lag.Status.Group == "" {
+		return nil, errors.New("cluster and group are required in lag status")
+	}
+	return []protocol.LagStatus{lag}, nil
+}
+
+// parseLagMessage recognizes fields by title, case and spaces are ignored:
+// "Cluster", "Group"(or "Consumer"), "Status", "Total Lag", "Complete",
+// and "{topic}:{partition}" with partition lag as value.
+// Status is taken from color(good, warning or danger) if there is no "Status" field.
+// Slack attachments carry no owners or offsets, partitions and max lag are marked OffsetsUnknown.
+func parseLagMessage(message protocol.LagMessage) ([]protocol.LagStatus, error) {
+	var res []protocol.LagStatus
+	for _, attachment := range message.Attachments {
+		var lag protocol.LagStatus
+		totalLag := -1
+		for _, field := range attachment.Fields {
+			value := strings.TrimSpace(field.Value)
+			switch strings.ToLower(strings.Replace(field.Title, " ", "", -1)) {
+			case "cluster":
+				lag.Status.Cluster = value
+			case "group", "consumer", "consumergroup":
+				lag.Status.Group = value
+			case "status":
+				lag.Status.Status = strings.ToUpper(value)
+			case "totallag":
+				i, err := strconv.Atoi(value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid total lag %q", field.Value)
+				}
+				totalLag = i
+			case "complete":
+				lag.Status.Complete, _ = strconv.ParseFloat(value, 64)
+			default:
+				partition, ok := parsePartitionField(field)
+				if ok {
+					lag.Status.Partitions = append(lag.Status.Partitions, partition)
+				}
+			}
+		}
+		if lag.Status.Cluster == "" || lag.Status.Group == "" {
+			return nil, fmt.Errorf("cluster and group are required in attachment %q", attachment.Title)
+		}
+		if lag.Status.Status == "" {
+			lag.Status.Status = colorStatus(attachment.Color)
+		}
+
+		sum := 0
+		for _, partition := range lag.Status.Partitions {
+			sum += partition.CurrentLag
+			if partition.CurrentLag > lag.Status.Maxlag.CurrentLag {
+				lag.Status.Maxlag.Topic = partition.Topic
+				lag.Status.Maxlag.Partition = partition.Partition
+				lag.Status.Maxlag.CurrentLag = partition.CurrentLag
+			}
+		}
+		if totalLag < 0 {
+			totalLag = sum
+		}
+		lag.Status.Totallag = totalLag
+		lag.Status.Maxlag.OffsetsUnknown = true
+		lag.Status.PartitionCount = len(lag.Status.Partitions)
+		res = append(res, lag)
+	}
+	return res, nil
+}
+
+// parsePartitionField parses a "{topic}:{partition}" field, topic may contain ':'.
+func parsePartitionField(field protocol.Field) (protocol.Partition, bool) {
+	var partition protocol.Partition
+	i := strings.LastIndex(field.Title, ":")
+	if i <= 0 {
+		return partition, false
+	}
+	id, err := strconv.Atoi(strings.TrimSpace(field.Title[i+1:]))
+	if err != nil {
+		return partition, false
+	}
+	lag, err := strconv.Atoi(strings.TrimSpace(field.Value))
+	if err != nil {
+		return partition, false
+	}
+	partition.Topic = strings.TrimSpace(field.Title[:i])
+	partition.Partition = id
+	partition.CurrentLag = lag
+	partition.End.Lag = lag
+	partition.OffsetsUnknown = true
+	return partition, true
+}
+
+func colorStatus(color string) string {
+	switch color {
+	case "good":
+		return "OK"
+	case "danger":
+		return "ERR"
+	default:
+		return "WARN"
+	}
+}
@@ -0,0 +1,51 @@
+package burrow
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseNotificationLagStatus(t *testing.T) {
+	body, _ := ioutil.ReadFile("../../config/pull_content.json")
+	lags, err := ParseNotification(body)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(lags))
+	assert.Equal(t, "console-consumer-0", lags[0].Status.Group)
+	assert.NotEqual(t, 0, len(lags[0].Status.Partitions))
+
+	lags, err = ParseNotification([]byte(`{"cluster": "test", "group": "bare", "status": "WARN", "totallag": 5}`))
+	assert.Nil(t, err, "bare group status of {{.Result | jsonencoder}}")
+	assert.Equal(t, "bare", lags[0].Status.Group)
+	assert.Equal(t, 5, lags[0].Status.Totallag)
+
+	_, err = ParseNotification([]byte(`{"status": {"cluster": "test"}}`))
+	assert.NotNil(t, err, "group is required")
+	_, err = ParseNotification([]byte(`[]`))
+	assert.NotNil(t, err)
+}
+
+func TestParseNotificationSlack(t *testing.T) {
+	body := []byte(`{"attachments": [{"color": "danger", "title": "lag", "fields": [
+		{"title": "Cluster", "value": "test"},
+		{"title": "Group", "value": "slack"},
+		{"title": "orders:0", "value": "10"},
+		{"title": "orders:1", "value": "30"},
+		{"title": "Owner", "value": "ignored"}
+	]}]}`)
+	lags, err := ParseNotification(body)
+	assert.Nil(t, err)
+	status := lags[0].Status
+	assert.Equal(t, "ERR", status.Status, "status is from color")
+	assert.Equal(t, 40, status.Totallag, "total lag is sum of partitions")
+	assert.Equal(t, 2, len(status.Partitions))
+	assert.Equal(t, 1, status.Maxlag.Partition)
+	assert.Equal(t, 30, status.Maxlag.CurrentLag)
+	assert.False(t, status.Partitions[0].HasCommittedOffset(), "slack attachments carry no offsets")
+	assert.True(t, status.Maxlag.OffsetsUnknown)
+
+	body = []byte(`{"attachments": [{"fields": [{"title": "Cluster", "value": "test"}, {"title": "Total Lag", "value": "x"}]}]}`)
+	_, err = ParseNotification(body)
+	assert.NotNil(t, err)
+}
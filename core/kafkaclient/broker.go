@@ -0,0 +1,206 @@
+package kafkaclient
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// broker is a connection to one Kafka broker, dialed on the first request.
+// It's not goroutine safe, requests are sent one by one.
+type broker struct {
+	addr     string
+	clientID string
+	timeout  time.Duration
+
+	conn          net.Conn
+	correlationID int32
+}
+
+// metadata is the part of Metadata response a producer needs for one topic.
+type metadata struct {
+	// brokers are addresses by node id.
+	brokers map[int32]string
+	// leaders are leader node ids by partition, partitions without leader are left out.
+	leaders map[int32]int32
+	// partitions are the partitions having leader, in order.
+	partitions []int32
+}
+
+// partitionResult is the result of one partition in Produce response.
+type partitionResult struct {
+	baseOffset int64
+	err        error
+}
+
+func (b *broker) close() {
+	if b.conn != nil {
+		b.conn.Close()
+		b.conn = nil
+	}
+}
+
+// request sends one request and reads its response, the connection is closed on any failure.
+// No response is read if expectResponse is false, e.g. Produce with acks 0.
+func (b *broker) request(apiKey int16, version int16, body []byte, expectResponse bool) ([]byte, error) {
+	res, err := b.roundTrip(apiKey, version, body, expectResponse)
+	if err != nil {
+		b.close()
+		return nil, fmt.Errorf("kafka broker %s: %v", b.addr, err)
+	}
+	return res, nil
+}
+
+func (b *broker) roundTrip(apiKey int16, version int16, body []byte, expectResponse bool) ([]byte, error) {
+	if b.conn == nil {
+		conn, err := net.DialTimeout("tcp", b.addr, b.timeout)
+		if err != nil {
+			return nil, err
+		}
+		b.conn = conn
+	}
+	b.correlationID++
+
+	var req encoder
+	req.int32(0) // size, set below
+	req.int16(apiKey)
+	req.int16(version)
+	req.int32(b.correlationID)
+	req.string(b.clientID)
+	req.Write(body)
+	frame := req.Bytes()
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-4))
+
+	b.conn.SetDeadline(time.Now().Add(b.timeout))
+	if _, err := b.conn.Write(frame); err != nil {
+		return nil, err
+	}
+	if !expectResponse {
+		return nil, nil
+	}
+
+	var size [4]byte
+	if _, err := io.ReadFull(b.conn, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxResponseSize {
+		return nil, fmt.Errorf("response size %d is over %d", n, maxResponseSize)
+	}
+	res := make([]byte, n)
+	if _, err := io.ReadFull(b.conn, res); err != nil {
+		return nil, err
+	}
+	d := &decoder{buf: res}
+	if correlationID := d.int32(); d.err != nil || correlationID != b.correlationID {
+		return nil, fmt.Errorf("unexpected correlation id %d, want %d", correlationID, b.correlationID)
+	}
+	return res[d.off:], nil
+}
+
+// metadata gets brokers and partition leaders of topic, Metadata v1.
+func (b *broker) metadata(topic string) (*metadata, error) {
+	var body encoder
+	body.int32(1)
+	body.string(topic)
+	res, err := b.request(apiKeyMetadata, metadataVersion, body.Bytes(), true)
+	if err != nil {
+		return nil, err
+	}
+
+	md := &metadata{
+		brokers: make(map[int32]string),
+		leaders: make(map[int32]int32),
+	}
+	d := &decoder{buf: res}
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		nodeID := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.string() // rack
+		md.brokers[nodeID] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.int32() // controller id
+
+	var topicErr error
+	found := false
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		code := d.int16()
+		name := d.string()
+		d.int8() // is internal
+		for j, m := 0, d.arrayLen(); j < m; j++ {
+			d.int16() // error code, a partition without leader has leader -1
+			partition := d.int32()
+			leader := d.int32()
+			for k, l := 0, d.arrayLen(); k < l; k++ {
+				d.int32() // replicas
+			}
+			for k, l := 0, d.arrayLen(); k < l; k++ {
+				d.int32() // isr
+			}
+			if name == topic && leader >= 0 {
+				md.leaders[partition] = leader
+				md.partitions = append(md.partitions, partition)
+			}
+		}
+		if name == topic {
+			found = true
+			topicErr = errorOf(code)
+		}
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("kafka broker %s metadata: %v", b.addr, d.err)
+	}
+	if !found {
+		return nil, Error{Code: 3}
+	}
+	if topicErr != nil {
+		return nil, topicErr
+	}
+	sort.Slice(md.partitions, func(i, j int) bool { return md.partitions[i] < md.partitions[j] })
+	return md, nil
+}
+
+// produce sends record batches of partitions to their leader, Produce v3.
+// The results are empty if acks is 0, as broker doesn't respond.
+func (b *broker) produce(topic string, acks int16, timeout time.Duration, batches map[int32][]byte) (map[int32]partitionResult, error) {
+	var body encoder
+	body.nullString() // transactional id
+	body.int16(acks)
+	body.int32(int32(timeout / time.Millisecond))
+	body.int32(1)
+	body.string(topic)
+	body.int32(int32(len(batches)))
+	for partition, batch := range batches {
+		body.int32(partition)
+		body.bytes(batch)
+	}
+
+	res, err := b.request(apiKeyProduce, produceVersion, body.Bytes(), acks != 0)
+	if err != nil || acks == 0 {
+		return nil, err
+	}
+
+	results := make(map[int32]partitionResult)
+	d := &decoder{buf: res}
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		d.string() // topic
+		for j, m := 0, d.arrayLen(); j < m; j++ {
+			partition := d.int32()
+			code := d.int16()
+			baseOffset := d.int64()
+			d.int64() // log append time
+			results[partition] = partitionResult{baseOffset: baseOffset, err: errorOf(code)}
+		}
+	}
+	d.int32() // throttle time
+	if d.err != nil {
+		b.close()
+		return nil, fmt.Errorf("kafka broker %s produce: %v", b.addr, d.err)
+	}
+	return results, nil
+}
@@ -0,0 +1,4 @@
+// Package kafkaclient is a pure Go Kafka producer, it speaks Kafka protocol directly
+// so goRainbow can be built without cgo and librdkafka.
+// It produces record batches(Produce v3, Kafka 0.11+) to plaintext brokers, with gzip compression.
+package kafkaclient
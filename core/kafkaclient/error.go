@@ -0,0 +1,72 @@
+package kafkaclient
+
+import (
+	"fmt"
+)
+
+// Error is an error code returned by broker.
+type Error struct {
+	Code int16
+}
+
+// errorNames are the error codes a producer may get.
+var errorNames = map[int16]string{
+	-1: "UNKNOWN_SERVER_ERROR",
+	2:  "CORRUPT_MESSAGE",
+	3:  "UNKNOWN_TOPIC_OR_PARTITION",
+	5:  "LEADER_NOT_AVAILABLE",
+	6:  "NOT_LEADER_FOR_PARTITION",
+	7:  "REQUEST_TIMED_OUT",
+	10: "MESSAGE_TOO_LARGE",
+	13: "NETWORK_EXCEPTION",
+	17: "INVALID_TOPIC_EXCEPTION",
+	18: "RECORD_LIST_TOO_LARGE",
+	19: "NOT_ENOUGH_REPLICAS",
+	20: "NOT_ENOUGH_REPLICAS_AFTER_APPEND",
+	21: "INVALID_REQUIRED_ACKS",
+	29: "TOPIC_AUTHORIZATION_FAILED",
+	35: "UNSUPPORTED_VERSION",
+	56: "KAFKA_STORAGE_ERROR",
+	76: "UNSUPPORTED_COMPRESSION_TYPE",
+}
+
+// retriableCodes are errors which may be gone after metadata is refreshed or later.
+var retriableCodes = map[int16]bool{
+	2:  true,
+	3:  true,
+	5:  true,
+	6:  true,
+	7:  true,
+	13: true,
+	19: true,
+	20: true,
+	56: true,
+}
+
+func (e Error) Error() string {
+	if name, ok := errorNames[e.Code]; ok {
+		return fmt.Sprintf("kafka error %d %s", e.Code, name)
+	}
+	return fmt.Sprintf("kafka error %d", e.Code)
+}
+
+// Retriable tells whether the request may succeed if it's retried.
+func (e Error) Retriable() bool {
+	return retriableCodes[e.Code]
+}
+
+// errorOf returns nil for code 0.
+func errorOf(code int16) error {
+	if code == 0 {
+		return nil
+	}
+	return Error{Code: code}
+}
+
+// isRetriable tells whether a failed produce should be retried, network errors always are.
+func isRetriable(err error) bool {
+	if kafkaErr, ok := err.(Error); ok {
+		return kafkaErr.Retriable()
+	}
+	return err != nil
+}
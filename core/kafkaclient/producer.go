@@ -0,0 +1,360 @@
+package kafkaclient
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrQueueFull is returned by Produce if QueueSize messages are waiting.
+	ErrQueueFull = errors.New("kafka producer queue is full")
+	// ErrClosed is returned by Produce after Close.
+	ErrClosed = errors.New("kafka producer is closed")
+)
+
+// DeliveryReport is the result of a batch of messages.
+type DeliveryReport struct {
+	Topic     string
+	Partition int32
+	Messages  int
+	// BaseOffset is the offset of the first message, -1 if it's unknown, e.g. acks 0 or failed.
+	BaseOffset int64
+	Err        error
+}
+
+// Producer produces messages without key to a topic, spreading batches over partitions in turn.
+// Messages are queued and sent by one routine, a batch is sent when it has BatchSize messages
+// or BatchBytes bytes, or after Linger. A failed batch is retried after metadata is refreshed.
+// Usage:
+// producer := &Producer{Brokers: "127.0.0.1:9092", Topic: "metrics", OnDelivery: report}
+// producer.Init()
+// producer.Produce(value)
+// remaining := producer.Flush(15 * time.Second)
+// producer.Close()
+type Producer struct {
+	// Brokers are comma separated bootstrap servers.
+	Brokers  string
+	Topic    string
+	ClientID string
+	// Acks is "all"(default, or "-1") to wait for all in-sync replicas, "1" for the leader only,
+	// or "0" not to wait, then broker doesn't respond and a batch is delivered once it's sent.
+	Acks string
+	// Compression is "none"(default) or "gzip".
+	Compression string
+	// BatchSize is messages per batch, 2000 by default.
+	BatchSize int
+	// BatchBytes is the size of values per batch, 1MB by default.
+	BatchBytes int
+	// Linger is how long a batch waits for more messages, 10ms by default.
+	Linger time.Duration
+	// Retries is how many times a batch is retried, RetryBackoff is doubled for every retry.
+	Retries      int
+	RetryBackoff time.Duration
+	// Timeout is for dialing and each request, 30s by default. Broker waits for acks up to half of it.
+	Timeout time.Duration
+	// QueueSize is how many messages wait to be batched, 100000 by default.
+	QueueSize int
+	// MetadataMaxAge is how often partition leaders are refreshed, 5m by default.
+	MetadataMaxAge time.Duration
+	// OnDelivery is called with the result of every batch, from the producing routine.
+	OnDelivery func(DeliveryReport)
+
+	acks         int16
+	codec        int16
+	bootstrap    []*broker
+	brokers      map[int32]*broker
+	md           *metadata
+	mdTime       time.Time
+	nextIndex    int
+	pending      [][]byte
+	pendingBytes int
+	outstanding  int64
+
+	queue        chan []byte
+	flushChannel chan chan struct{}
+	quitChannel  chan struct{}
+	doneChannel  chan struct{}
+}
+
+// Init is a general init, brokers are not connected until the first batch.
+func (p *Producer) Init() error {
+	if p.Brokers == "" || p.Topic == "" {
+		return errors.New("kafka brokers and topic are required")
+	}
+	codec, err := compressionCodec(p.Compression)
+	if err != nil {
+		return err
+	}
+	p.codec = codec
+	switch p.Acks {
+	case "", "all", "-1":
+		p.acks = -1
+	case "1":
+		p.acks = 1
+	case "0":
+		p.acks = 0
+	default:
+		return errors.New("kafka acks should be all, -1, 1 or 0")
+	}
+	if p.ClientID == "" {
+		p.ClientID = "goRainbow"
+	}
+	if p.BatchSize <= 0 {
+		p.BatchSize = 2000
+	}
+	if p.BatchBytes <= 0 {
+		p.BatchBytes = 1024 * 1024
+	}
+	if p.Linger <= 0 {
+		p.Linger = 10 * time.Millisecond
+	}
+	if p.RetryBackoff <= 0 {
+		p.RetryBackoff = 100 * time.Millisecond
+	}
+	if p.Timeout <= 0 {
+		p.Timeout = 30 * time.Second
+	}
+	if p.QueueSize <= 0 {
+		p.QueueSize = 100000
+	}
+	if p.MetadataMaxAge <= 0 {
+		p.MetadataMaxAge = 5 * time.Minute
+	}
+
+	for _, addr := range strings.Split(p.Brokers, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			p.bootstrap = append(p.bootstrap, p.newBroker(addr))
+		}
+	}
+	p.brokers = make(map[int32]*broker)
+
+	p.queue = make(chan []byte, p.QueueSize)
+	p.flushChannel = make(chan chan struct{})
+	p.quitChannel = make(chan struct{})
+	p.doneChannel = make(chan struct{})
+	go p.run()
+	return nil
+}
+
+// Produce queues value without blocking.
+func (p *Producer) Produce(value []byte) error {
+	select {
+	case <-p.quitChannel:
+		return ErrClosed
+	default:
+	}
+
+	atomic.AddInt64(&p.outstanding, 1)
+	select {
+	case p.queue <- value:
+		return nil
+	default:
+		atomic.AddInt64(&p.outstanding, -1)
+		return ErrQueueFull
+	}
+}
+
+// Len returns how many messages are queued or being sent.
+func (p *Producer) Len() int {
+	return int(atomic.LoadInt64(&p.outstanding))
+}
+
+// Flush sends all queued messages and waits for their delivery up to timeout.
+// It returns how many messages are still not delivered.
+func (p *Producer) Flush(timeout time.Duration) int {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	done := make(chan struct{})
+	select {
+	case p.flushChannel <- done:
+	case <-p.doneChannel:
+		return p.Len()
+	case <-timer.C:
+		return p.Len()
+	}
+	select {
+	case <-done:
+	case <-timer.C:
+	}
+	return p.Len()
+}
+
+// Close sends the queued messages and closes connections, call Flush before to bound the wait.
+func (p *Producer) Close() {
+	close(p.quitChannel)
+	<-p.doneChannel
+}
+
+func (p *Producer) run() {
+	defer close(p.doneChannel)
+
+	ticker := time.NewTicker(p.Linger)
+	defer ticker.Stop()
+	for {
+		select {
+		case value := <-p.queue:
+			p.add(value)
+		case <-ticker.C:
+			p.sendPending()
+		case done := <-p.flushChannel:
+			p.drainQueue()
+			p.sendPending()
+			close(done)
+		case <-p.quitChannel:
+			p.drainQueue()
+			p.sendPending()
+			for _, b := range p.bootstrap {
+				b.close()
+			}
+			for _, b := range p.brokers {
+				b.close()
+			}
+			return
+		}
+	}
+}
+
+// drainQueue batches all queued messages, full batches are sent.
+func (p *Producer) drainQueue() {
+	for {
+		select {
+		case value := <-p.queue:
+			p.add(value)
+		default:
+			return
+		}
+	}
+}
+
+// add puts value into the pending batch, and sends the batch if it's full.
+func (p *Producer) add(value []byte) {
+	p.pending = append(p.pending, value)
+	p.pendingBytes += len(value)
+	if len(p.pending) >= p.BatchSize || p.pendingBytes >= p.BatchBytes {
+		p.sendPending()
+	}
+}
+
+func (p *Producer) sendPending() {
+	if len(p.pending) == 0 {
+		return
+	}
+	values := p.pending
+	p.pending = nil
+	p.pendingBytes = 0
+	p.send(values)
+}
+
+// send produces a batch to the next partition, it's retried on another partition
+// with refreshed metadata if the failure is retriable.
+func (p *Producer) send(values [][]byte) {
+	report := DeliveryReport{Topic: p.Topic, Partition: -1, Messages: len(values), BaseOffset: -1}
+	defer func() {
+		atomic.AddInt64(&p.outstanding, -int64(len(values)))
+		if p.OnDelivery != nil {
+			p.OnDelivery(report)
+		}
+	}()
+
+	batch, err := encodeRecordBatch(values, time.Now().UnixNano()/int64(time.Millisecond), p.codec)
+	if err != nil {
+		report.Err = err
+		return
+	}
+
+	backoff := p.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		report.Partition, report.BaseOffset, report.Err = p.produce(batch)
+		if report.Err == nil || !isRetriable(report.Err) || attempt >= p.Retries {
+			return
+		}
+		// leaders may have moved.
+		p.md = nil
+		select {
+		case <-time.After(backoff):
+		case <-p.quitChannel:
+			// the last retry is sent at once on close.
+			attempt = p.Retries - 1
+		}
+		backoff *= 2
+	}
+}
+
+// produce sends batch to the leader of the next partition.
+func (p *Producer) produce(batch []byte) (int32, int64, error) {
+	if p.md == nil || time.Since(p.mdTime) > p.MetadataMaxAge {
+		if err := p.refreshMetadata(); err != nil {
+			return -1, -1, err
+		}
+	}
+	if len(p.md.partitions) == 0 {
+		return -1, -1, Error{Code: 5}
+	}
+	partition := p.md.partitions[p.nextIndex%len(p.md.partitions)]
+	p.nextIndex++
+
+	leader := p.broker(p.md.leaders[partition])
+	if leader == nil {
+		return partition, -1, Error{Code: 5}
+	}
+	results, err := leader.produce(p.Topic, p.acks, p.Timeout/2, map[int32][]byte{partition: batch})
+	if err != nil || p.acks == 0 {
+		return partition, -1, err
+	}
+	result, ok := results[partition]
+	if !ok {
+		return partition, -1, errShortBuffer
+	}
+	if result.err != nil {
+		return partition, -1, result.err
+	}
+	return partition, result.baseOffset, nil
+}
+
+// refreshMetadata asks known brokers and bootstrap servers in turn, until one answers.
+func (p *Producer) refreshMetadata() error {
+	candidates := make([]*broker, 0, len(p.brokers)+len(p.bootstrap))
+	for _, b := range p.brokers {
+		candidates = append(candidates, b)
+	}
+	candidates = append(candidates, p.bootstrap...)
+
+	var err error
+	for _, b := range candidates {
+		var md *metadata
+		if md, err = b.metadata(p.Topic); err == nil {
+			p.md = md
+			p.mdTime = time.Now()
+			return nil
+		}
+		if _, ok := err.(Error); ok {
+			// the topic itself is not available, other brokers would answer the same.
+			return err
+		}
+	}
+	return err
+}
+
+// broker returns the connection of a node, it's redialed if the node moved to another address.
+func (p *Producer) broker(nodeID int32) *broker {
+	addr, ok := p.md.brokers[nodeID]
+	if !ok {
+		return nil
+	}
+	if b, ok := p.brokers[nodeID]; ok {
+		if b.addr == addr {
+			return b
+		}
+		b.close()
+	}
+	b := p.newBroker(addr)
+	p.brokers[nodeID] = b
+	return b
+}
+
+func (p *Producer) newBroker(addr string) *broker {
+	return &broker{addr: addr, clientID: p.ClientID, timeout: p.Timeout}
+}
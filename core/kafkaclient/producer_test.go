@@ -0,0 +1,340 @@
+package kafkaclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeBroker is a single node cluster serving Metadata v1 and Produce v3 of one topic.
+type fakeBroker struct {
+	sync.Mutex
+
+	listener   net.Listener
+	topic      string
+	partitions int32
+	// produceErrors are error codes answered to produce requests in turn, then 0.
+	produceErrors []int16
+
+	metadataRequests int
+	produced         map[int32][]string
+}
+
+func newFakeBroker(t *testing.T, topic string, partitions int32) *fakeBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	fb := &fakeBroker{
+		listener:   listener,
+		topic:      topic,
+		partitions: partitions,
+		produced:   make(map[int32][]string),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go fb.serve(conn)
+		}
+	}()
+	return fb
+}
+
+func (fb *fakeBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+		d := &decoder{buf: req}
+		apiKey := d.int16()
+		d.int16() // version
+		correlationID := d.int32()
+		d.string() // client id
+
+		var res encoder
+		res.int32(correlationID)
+		switch apiKey {
+		case apiKeyMetadata:
+			fb.metadata(&res)
+		case apiKeyProduce:
+			if acks := fb.produce(d, &res); acks == 0 {
+				continue
+			}
+		}
+		frame := res.Bytes()
+		var resSize [4]byte
+		binary.BigEndian.PutUint32(resSize[:], uint32(len(frame)))
+		conn.Write(append(resSize[:], frame...))
+	}
+}
+
+func (fb *fakeBroker) metadata(res *encoder) {
+	fb.Lock()
+	fb.metadataRequests++
+	fb.Unlock()
+
+	host, port, _ := net.SplitHostPort(fb.listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	res.int32(1) // brokers
+	res.int32(1)
+	res.string(host)
+	res.int32(int32(portNumber))
+	res.nullString()
+	res.int32(1) // controller
+	res.int32(1) // topics
+	res.int16(0)
+	res.string(fb.topic)
+	res.int8(0)
+	res.int32(fb.partitions)
+	for p := int32(0); p < fb.partitions; p++ {
+		res.int16(0)
+		res.int32(p)
+		res.int32(1) // leader
+		res.int32(1) // replicas
+		res.int32(1)
+		res.int32(1) // isr
+		res.int32(1)
+	}
+}
+
+func (fb *fakeBroker) produce(d *decoder, res *encoder) int16 {
+	d.string() // transactional id
+	acks := d.int16()
+	d.int32() // timeout
+
+	fb.Lock()
+	defer fb.Unlock()
+	code := int16(0)
+	if len(fb.produceErrors) > 0 {
+		code = fb.produceErrors[0]
+		fb.produceErrors = fb.produceErrors[1:]
+	}
+
+	res.int32(1)
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		topic := d.string()
+		res.string(topic)
+		m := d.arrayLen()
+		res.int32(int32(m))
+		for j := 0; j < m; j++ {
+			partition := d.int32()
+			batch := d.next(int(d.int32()))
+			values, err := decodeRecordBatch(batch)
+			if err != nil {
+				code = 2
+			}
+			offset := int64(len(fb.produced[partition]))
+			if code == 0 {
+				fb.produced[partition] = append(fb.produced[partition], values...)
+			}
+			res.int32(partition)
+			res.int16(code)
+			res.int64(offset)
+			res.int64(-1)
+		}
+	}
+	res.int32(0) // throttle time
+	return acks
+}
+
+func (fb *fakeBroker) values() []string {
+	fb.Lock()
+	defer fb.Unlock()
+	var values []string
+	for p := int32(0); p < fb.partitions; p++ {
+		values = append(values, fb.produced[p]...)
+	}
+	return values
+}
+
+// decodeRecordBatch checks crc and returns values of a record batch.
+func decodeRecordBatch(batch []byte) ([]string, error) {
+	d := &decoder{buf: batch}
+	d.int64() // base offset
+	if length := d.int32(); int(length) != len(batch)-12 {
+		return nil, errors.New("wrong batch length")
+	}
+	d.int32() // partition leader epoch
+	if magic := d.int8(); magic != 2 {
+		return nil, errors.New("wrong magic")
+	}
+	crc := uint32(d.int32())
+	if crc32.Checksum(batch[d.off:], crc32c) != crc {
+		return nil, errors.New("wrong crc")
+	}
+	attributes := d.int16()
+	d.next(4 + 8 + 8 + 8 + 2 + 4)
+	count := int(d.int32())
+	records := batch[d.off:]
+	if attributes&7 == compressionGzip {
+		gz, err := gzip.NewReader(bytes.NewReader(records))
+		if err != nil {
+			return nil, err
+		}
+		if records, err = ioutil.ReadAll(gz); err != nil {
+			return nil, err
+		}
+	}
+
+	values := make([]string, 0, count)
+	rd := &decoder{buf: records}
+	for i := 0; i < count; i++ {
+		rd.varint() // length
+		rd.int8()   // attributes
+		rd.varint() // timestamp delta
+		rd.varint() // offset delta
+		if keyLength := rd.varint(); keyLength >= 0 {
+			rd.next(int(keyLength))
+		}
+		values = append(values, string(rd.next(int(rd.varint()))))
+		rd.varint() // headers
+	}
+	return values, rd.err
+}
+
+func TestProducer(t *testing.T) {
+	fb := newFakeBroker(t, "metrics", 2)
+	defer fb.listener.Close()
+	fb.produceErrors = []int16{6}
+
+	var reports []DeliveryReport
+	producer := &Producer{
+		Brokers:      fb.listener.Addr().String(),
+		Topic:        "metrics",
+		Compression:  "gzip",
+		BatchSize:    2,
+		RetryBackoff: time.Millisecond,
+		Retries:      2,
+		Linger:       time.Hour,
+		OnDelivery:   func(report DeliveryReport) { reports = append(reports, report) },
+	}
+	assert.Nil(t, producer.Init())
+	for _, value := range []string{"a", "b", "c"} {
+		assert.Nil(t, producer.Produce([]byte(value)))
+	}
+	assert.Equal(t, 0, producer.Flush(5*time.Second), "all messages should be delivered")
+	producer.Close()
+
+	assert.Equal(t, 2, len(reports), "messages should be sent in batches of BatchSize")
+	for _, report := range reports {
+		assert.Nil(t, report.Err)
+	}
+	assert.Equal(t, 2, reports[0].Messages)
+	fb.Lock()
+	assert.Equal(t, 2, fb.metadataRequests, "metadata should be refreshed after NOT_LEADER_FOR_PARTITION")
+	fb.Unlock()
+	assert.NotEqual(t, reports[0].Partition, reports[1].Partition, "batches should be spread over partitions")
+	assert.ElementsMatch(t, []string{"a", "b", "c"}, fb.values())
+	assert.Equal(t, ErrClosed, producer.Produce([]byte("d")))
+}
+
+func TestProducerFailures(t *testing.T) {
+	fb := newFakeBroker(t, "metrics", 1)
+	defer fb.listener.Close()
+	fb.produceErrors = []int16{10}
+
+	var reports []DeliveryReport
+	producer := &Producer{
+		Brokers:    fb.listener.Addr().String(),
+		Topic:      "metrics",
+		Acks:       "1",
+		Retries:    3,
+		OnDelivery: func(report DeliveryReport) { reports = append(reports, report) },
+	}
+	assert.Nil(t, producer.Init())
+	assert.Nil(t, producer.Produce([]byte("a")))
+	producer.Flush(5 * time.Second)
+	producer.Close()
+	assert.Equal(t, 1, len(reports))
+	assert.Equal(t, Error{Code: 10}, reports[0].Err, "MESSAGE_TOO_LARGE should not be retried")
+	assert.Equal(t, 0, len(fb.values()))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	listener.Close()
+	reports = nil
+	producer = &Producer{
+		Brokers:      listener.Addr().String(),
+		Topic:        "metrics",
+		Retries:      1,
+		RetryBackoff: time.Millisecond,
+		OnDelivery:   func(report DeliveryReport) { reports = append(reports, report) },
+	}
+	assert.Nil(t, producer.Init())
+	assert.Nil(t, producer.Produce([]byte("a")))
+	assert.Equal(t, 0, producer.Flush(5*time.Second), "a failed message is not outstanding")
+	producer.Close()
+	assert.Equal(t, 1, len(reports))
+	assert.NotNil(t, reports[0].Err, "unreachable broker should fail after retries")
+
+	assert.NotNil(t, (&Producer{Brokers: "127.0.0.1:9092", Topic: "metrics", Compression: "snappy"}).Init())
+	assert.NotNil(t, (&Producer{Brokers: "127.0.0.1:9092", Topic: "metrics", Acks: "2"}).Init())
+}
+
+func TestProducerAcksNone(t *testing.T) {
+	fb := newFakeBroker(t, "metrics", 1)
+	defer fb.listener.Close()
+
+	var reports []DeliveryReport
+	producer := &Producer{
+		Brokers:    fb.listener.Addr().String(),
+		Topic:      "metrics",
+		Acks:       "0",
+		OnDelivery: func(report DeliveryReport) { reports = append(reports, report) },
+	}
+	assert.Nil(t, producer.Init())
+	assert.Nil(t, producer.Produce([]byte("a")))
+	assert.Equal(t, 0, producer.Flush(5*time.Second))
+	producer.Close()
+	assert.Equal(t, 1, len(reports))
+	assert.Nil(t, reports[0].Err)
+	assert.Equal(t, int64(-1), reports[0].BaseOffset, "offset is unknown without acks")
+
+	// the broker may read the request after the producer exits.
+	for i := 0; i < 100 && len(fb.values()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, []string{"a"}, fb.values())
+}
+
+func TestProducerResponseTooLarge(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var size [4]byte
+		io.ReadFull(conn, size[:])
+		io.CopyN(ioutil.Discard, conn, int64(binary.BigEndian.Uint32(size[:])))
+		// a bad broker asks for 4GiB.
+		conn.Write([]byte{0xff, 0xff, 0xff, 0xff})
+	}()
+
+	b := &broker{addr: listener.Addr().String(), clientID: "test", timeout: time.Second}
+	_, err = b.metadata("metrics")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "response size 4294967295 is over")
+	assert.Nil(t, b.conn, "connection should be closed")
+}
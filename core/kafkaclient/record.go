@@ -0,0 +1,85 @@
+package kafkaclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// Compression codecs of record batch attributes.
+const (
+	compressionNone int16 = 0
+	compressionGzip int16 = 1
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// compressionCodec maps "compression.type" of config to its codec.
+func compressionCodec(compression string) (int16, error) {
+	switch compression {
+	case "", "none":
+		return compressionNone, nil
+	case "gzip":
+		return compressionGzip, nil
+	default:
+		return 0, errors.New("unsupported kafka compression: " + compression + ", only none and gzip are supported")
+	}
+}
+
+// encodeRecordBatch encodes values into a record batch of magic v2, all at timestamp(ms),
+// without keys and headers.
+func encodeRecordBatch(values [][]byte, timestamp int64, codec int16) ([]byte, error) {
+	var records encoder
+	for i, value := range values {
+		var record encoder
+		record.int8(0)          // attributes
+		record.varint(0)        // timestamp delta
+		record.varint(int64(i)) // offset delta
+		record.varint(-1)       // null key
+		record.varint(int64(len(value)))
+		record.Write(value)
+		record.varint(0) // no headers
+
+		records.varint(int64(record.Len()))
+		records.Write(record.Bytes())
+	}
+
+	recordBytes := records.Bytes()
+	if codec == compressionGzip {
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		if _, err := gz.Write(recordBytes); err != nil {
+			return nil, err
+		}
+		if err := gz.Close(); err != nil {
+			return nil, err
+		}
+		recordBytes = compressed.Bytes()
+	}
+
+	// the part covered by crc, from attributes to the end.
+	var body encoder
+	body.int16(codec)
+	body.int32(int32(len(values) - 1)) // last offset delta
+	body.int64(timestamp)              // base timestamp
+	body.int64(timestamp)              // max timestamp
+	body.int64(-1)                     // producer id
+	body.int16(-1)                     // producer epoch
+	body.int32(-1)                     // base sequence
+	body.int32(int32(len(values)))
+	body.Write(recordBytes)
+
+	var batch encoder
+	batch.int64(0) // base offset
+	// batch length is from partition leader epoch to the end.
+	batch.int32(int32(4 + 1 + 4 + body.Len()))
+	batch.int32(-1) // partition leader epoch
+	batch.int8(2)   // magic
+	var crc [4]byte
+	binary.BigEndian.PutUint32(crc[:], crc32.Checksum(body.Bytes(), crc32c))
+	batch.Write(crc[:])
+	batch.Write(body.Bytes())
+	return batch.Bytes(), nil
+}
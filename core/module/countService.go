@@ -4,6 +4,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/harbinzhang/goRainbow/core/protocol"
 	"github.com/harbinzhang/goRainbow/core/util"
 )
 
@@ -16,24 +17,35 @@ import (
 type CountService struct {
 	sync.RWMutex
 
-	ProduceQueue chan<- string
+	// Interval is how often counters are sent, 60s by default.
+	Interval     time.Duration
+	ProduceQueue chan<- protocol.Metric
 
 	counterMap map[string]*util.RequestCounter
-	postfix    string
+	stopped    bool
 }
 
 // Start is a general start()
 func (cc *CountService) Start() {
 	cc.counterMap = make(map[string]*util.RequestCounter)
-
-	contextProvider := util.ContextProvider{}
-	contextProvider.Init()
-	cc.postfix = contextProvider.GetPostfix()
+	if cc.Interval == 0 {
+		cc.Interval = 60 * time.Second
+	}
 }
 
-// Stop is a general stop()
+// Stop stops all counters, no new counter would be inited after it.
 func (cc *CountService) Stop() error {
+	cc.Lock()
+	cc.stopped = true
+	counters := make([]*util.RequestCounter, 0, len(cc.counterMap))
 	for _, val := range cc.counterMap {
+		counters = append(counters, val)
+	}
+	cc.Unlock()
+
+	// Stop outside the lock, a counter may be waiting for producer
+	// which is increasing a counter too.
+	for _, val := range counters {
 		err := val.Stop()
 		if err != nil {
 			return err
@@ -45,37 +57,51 @@ func (cc *CountService) Stop() error {
 // Increase inceases 1 in RequestCounterName of env.
 // It would init a new RequestCounter if not exist.
 func (cc *CountService) Increase(RequestCounterName string, env string) {
-	cc.isExistOrInit(RequestCounterName)
-	cc.counterMap[RequestCounterName].Increase(env)
+	if counter := cc.isExistOrInit(RequestCounterName); counter != nil {
+		counter.Increase(env)
+	}
+}
+
+// Add increases n in RequestCounterName of env.
+func (cc *CountService) Add(RequestCounterName string, env string, n int) {
+	if counter := cc.isExistOrInit(RequestCounterName); counter != nil {
+		counter.Add(env, n)
+	}
 }
 
 // IsCountServiceAvailable is for health_check
 func (cc *CountService) IsCountServiceAvailable() bool {
 	const TotalMessage string = "totalMessage"
-	cc.isExistOrInit(TotalMessage)
-	return cc.counterMap[TotalMessage].IsMetricAvailable()
+	counter := cc.isExistOrInit(TotalMessage)
+	return counter != nil && counter.IsMetricAvailable()
 }
 
 // isExistOrInit would init requestCounter if there is no one named RequestCounterName existing.
-func (cc *CountService) isExistOrInit(RequestCounterName string) {
+// It returns nil if the counter doesn't exist and CountService is stopped.
+func (cc *CountService) isExistOrInit(RequestCounterName string) *util.RequestCounter {
 	cc.RLock()
-	if _, ok := cc.counterMap[RequestCounterName]; !ok {
-		cc.RUnlock()
-		// It may have a problem. the same counter can be inited multiple times.
-		// Here is a race condition.I
-		// But it is acceptable.
-		cc.Lock()
-		// init counter
-		rcs := &util.RequestCounter{
-			Name:         RequestCounterName,
-			Interval:     60 * time.Second,
-			ProducerChan: cc.ProduceQueue,
-			Postfix:      cc.postfix,
-		}
-		rcs.Init()
-		cc.counterMap[RequestCounterName] = rcs
-		cc.Unlock()
-	} else {
-		cc.RUnlock()
+	counter, ok := cc.counterMap[RequestCounterName]
+	cc.RUnlock()
+	if ok {
+		return counter
+	}
+
+	cc.Lock()
+	defer cc.Unlock()
+	// check again, it may be inited by others after RUnlock.
+	if counter, ok := cc.counterMap[RequestCounterName]; ok {
+		return counter
+	}
+	if cc.stopped {
+		return nil
+	}
+	// init counter
+	rcs := &util.RequestCounter{
+		Name:         RequestCounterName,
+		Interval:     cc.Interval,
+		ProducerChan: cc.ProduceQueue,
 	}
+	rcs.Init()
+	cc.counterMap[RequestCounterName] = rcs
+	return rcs
 }
@@ -0,0 +1,69 @@
+package module
+
+import (
+	"time"
+
+	"github.com/harbinzhang/goRainbow/core/protocol"
+	"github.com/harbinzhang/goRainbow/core/util"
+)
+
+// ETANever is ETA of lag which never gets 0, as produce rate is not less than consume rate.
+const ETANever = -1
+
+// ETARateWindow is the window of produce rate for ETA, the same as default window of consume rate.
+const ETARateWindow = 2 * time.Minute
+
+// CatchUpETA returns seconds until lag gets 0 at current consume and produce rates.
+func CatchUpETA(lag int, consumePerSecond float64, producePerSecond float64) int64 {
+	if lag <= 0 {
+		return 0
+	}
+	net := consumePerSecond - producePerSecond
+	if net <= 0 {
+		return ETANever
+	}
+	return int64(float64(lag)/net + 0.5)
+}
+
+// PartitionETA estimates ETA of a partition, consume rate is rate of RateKey in rates,
+// produce rate is from head offsets in history. It returns false if any rate is unknown.
+func PartitionETA(cluster string, partition protocol.Partition, rates map[string]util.Rate, history *OffsetHistory) (int64, bool) {
+	if partition.CurrentLag <= 0 {
+		return 0, true
+	}
+	consume, ok := rates[RateKey(partition.Owner, partition.Topic, partition.Partition)]
+	if partition.Owner == "" || !ok || !consume.Valid {
+		return 0, false
+	}
+	produce, ok := history.ProduceRate(cluster, partition.Topic, partition.Partition, ETARateWindow)
+	if !ok {
+		return 0, false
+	}
+	return CatchUpETA(partition.CurrentLag, consume.PerSecond, produce), true
+}
+
+// GroupETA is ETA of the slowest partition, ETANever if any partition never catches up.
+func GroupETA(etas []int64) int64 {
+	var res int64
+	for _, eta := range etas {
+		if eta == ETANever {
+			return ETANever
+		}
+		if eta > res {
+			res = eta
+		}
+	}
+	return res
+}
+
+// RatesByKey indexes current rates of calculator by key.
+func RatesByKey(rates *util.RateCalculator) map[string]util.Rate {
+	res := make(map[string]util.Rate)
+	if rates == nil {
+		return res
+	}
+	for _, rate := range rates.Current() {
+		res[rate.Key] = rate
+	}
+	return res
+}
@@ -0,0 +1,41 @@
+package module
+
+import (
+	"testing"
+
+	"github.com/harbinzhang/goRainbow/core/protocol"
+	"github.com/harbinzhang/goRainbow/core/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCatchUpETA(t *testing.T) {
+	assert.Equal(t, int64(0), CatchUpETA(0, 0, 10))
+	assert.Equal(t, int64(50), CatchUpETA(1000, 30, 10))
+	assert.Equal(t, int64(ETANever), CatchUpETA(1000, 10, 10))
+	assert.Equal(t, int64(ETANever), GroupETA([]int64{10, ETANever, 0}))
+	assert.Equal(t, int64(10), GroupETA([]int64{10, 0}))
+}
+
+func TestPartitionETA(t *testing.T) {
+	// 10 messages per second produced
+	oh := prepareOffsetHistory()
+	rates := map[string]util.Rate{RateKey("host", "topic", 0): {Key: RateKey("host", "topic", 0), Valid: true, PerSecond: 30}}
+	partition := protocol.Partition{Topic: "topic", Partition: 0, Owner: "host", CurrentLag: 1000}
+
+	eta, ok := PartitionETA("test", partition, rates, oh)
+	assert.True(t, ok)
+	assert.Equal(t, int64(50), eta)
+
+	rate, _ := oh.ProduceRate("test", "topic", 0, ETARateWindow)
+	assert.Equal(t, float64(10), rate)
+
+	partition.Owner = "other"
+	_, ok = PartitionETA("test", partition, rates, oh)
+	assert.False(t, ok, "no consume rate")
+	partition.Owner, partition.Topic = "host", "other"
+	_, ok = PartitionETA("test", partition, rates, oh)
+	assert.False(t, ok, "consume rate of the same partition id of another topic is not used")
+	partition.Owner, partition.Topic = "host", "unknown"
+	_, ok = PartitionETA("test", partition, rates, oh)
+	assert.False(t, ok, "no produce rate")
+}
@@ -0,0 +1,302 @@
+package module
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// LagHistory keeps the last Retention of per-partition lag, per-group total lag and per-topic offsets,
+// so lag trends can be queried even when the metrics pipeline is down.
+// Each series is a ring buffer growing up to MaxPoints points, and there are at most MaxSeries series,
+// so memory is capped at about MaxSeries*MaxPoints*16 bytes, 115MB by default. Series without points in Retention
+// are expired every minute.
+// Usage:
+// history.Init()
+// history.RecordTotalLag(cluster, group, lag, timestamp)
+// series := history.Consumer(cluster, group, start, end, step, AggregateMax)
+// history.Stop()
+type LagHistory struct {
+	sync.RWMutex
+
+	Retention time.Duration
+	MaxPoints int
+	MaxSeries int
+
+	series         map[SeriesKey]*ring
+	expireInterval time.Duration
+	ticker         *time.Ticker
+	quitChannel    chan struct{}
+	doneChannel    chan struct{}
+}
+
+// SeriesKey identifies a series.
+// Group is empty for topic offsets, Topic is empty and Partition is -1 for group total lag.
+type SeriesKey struct {
+	Cluster   string `json:"cluster"`
+	Group     string `json:"group,omitempty"`
+	Topic     string `json:"topic,omitempty"`
+	Partition int    `json:"partition"`
+}
+
+// Point is a value at a unix timestamp.
+type Point struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+// Series is points of a SeriesKey in a query range.
+type Series struct {
+	SeriesKey
+	Points []Point `json:"points"`
+}
+
+// Aggregate decides how points in one step are downsampled.
+type Aggregate string
+
+const (
+	// AggregateAvg is the average of points in a step.
+	AggregateAvg Aggregate = "avg"
+	// AggregateMax is the max of points in a step, lag spikes are kept.
+	AggregateMax Aggregate = "max"
+	// AggregateLast is the last point in a step.
+	AggregateLast Aggregate = "last"
+)
+
+// ring is a buffer of at most max points, the oldest point is overwritten when it's full.
+// It grows when points are added, most series never reach max in Retention.
+type ring struct {
+	points []Point
+	max    int
+	start  int
+	size   int
+}
+
+func (r *ring) add(point Point) {
+	if r.size > 0 && point.Timestamp < r.at(r.size-1).Timestamp {
+		// out of order point is dropped, points are kept sorted.
+		return
+	}
+	if len(r.points) < r.max {
+		// start stays 0 until it's full.
+		if len(r.points) == cap(r.points) {
+			points := make([]Point, len(r.points), minInt(2*cap(r.points)+1, r.max))
+			copy(points, r.points)
+			r.points = points
+		}
+		r.points = append(r.points, point)
+		r.size++
+		return
+	}
+	r.points[r.start] = point
+	r.start = (r.start + 1) % len(r.points)
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func (r *ring) at(i int) Point {
+	return r.points[(r.start+i)%len(r.points)]
+}
+
+func (r *ring) last() Point {
+	return r.at(r.size - 1)
+}
+
+// Init is a general init
+func (lh *LagHistory) Init() {
+	if lh.Retention == 0 {
+		lh.Retention = 6 * time.Hour
+	}
+	if lh.MaxPoints == 0 {
+		lh.MaxPoints = 720
+	}
+	if lh.MaxSeries == 0 {
+		lh.MaxSeries = 10000
+	}
+	if lh.expireInterval == 0 {
+		lh.expireInterval = time.Minute
+	}
+	lh.series = make(map[SeriesKey]*ring)
+
+	lh.ticker = time.NewTicker(lh.expireInterval)
+	lh.quitChannel = make(chan struct{})
+	lh.doneChannel = make(chan struct{})
+	go func() {
+		defer close(lh.doneChannel)
+		for {
+			select {
+			case <-lh.ticker.C:
+				lh.Lock()
+				lh.expire(time.Now().Unix())
+				lh.Unlock()
+			case <-lh.quitChannel:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops expiring series.
+func (lh *LagHistory) Stop() error {
+	lh.ticker.Stop()
+	close(lh.quitChannel)
+	<-lh.doneChannel
+	return nil
+}
+
+// RecordTotalLag records total lag of a consumer group.
+func (lh *LagHistory) RecordTotalLag(cluster string, group string, lag int, timestamp int64) bool {
+	return lh.record(SeriesKey{Cluster: cluster, Group: group, Partition: -1}, float64(lag), timestamp)
+}
+
+// RecordPartitionLag records lag of a partition consumed by a consumer group.
+func (lh *LagHistory) RecordPartitionLag(cluster string, group string, topic string, partition int, lag int, timestamp int64) bool {
+	return lh.record(SeriesKey{Cluster: cluster, Group: group, Topic: topic, Partition: partition}, float64(lag), timestamp)
+}
+
+// RecordTopicOffset records head offset of a topic partition.
+func (lh *LagHistory) RecordTopicOffset(cluster string, topic string, partition int, offset int, timestamp int64) bool {
+	return lh.record(SeriesKey{Cluster: cluster, Topic: topic, Partition: partition}, float64(offset), timestamp)
+}
+
+// record returns false if the point is dropped because MaxSeries is reached.
+func (lh *LagHistory) record(key SeriesKey, value float64, timestamp int64) bool {
+	if lh == nil {
+		return true
+	}
+	lh.Lock()
+	defer lh.Unlock()
+
+	r, ok := lh.series[key]
+	if !ok {
+		if len(lh.series) >= lh.MaxSeries {
+			lh.expire(timestamp)
+		}
+		if len(lh.series) >= lh.MaxSeries {
+			return false
+		}
+		r = &ring{max: lh.MaxPoints}
+		lh.series[key] = r
+	}
+	r.add(Point{Timestamp: timestamp, Value: value})
+	return true
+}
+
+// expire removes series without points in Retention, it should be called with lock held.
+func (lh *LagHistory) expire(now int64) {
+	before := now - int64(lh.Retention/time.Second)
+	for key, r := range lh.series {
+		if r.last().Timestamp < before {
+			delete(lh.series, key)
+		}
+	}
+}
+
+// Len returns number of series.
+func (lh *LagHistory) Len() int {
+	lh.RLock()
+	defer lh.RUnlock()
+	return len(lh.series)
+}
+
+// Consumer returns total lag and partition lag series of a consumer group in [start, end].
+func (lh *LagHistory) Consumer(cluster string, group string, start int64, end int64, step int64, aggregate Aggregate) []Series {
+	return lh.query(func(key SeriesKey) bool {
+		return key.Cluster == cluster && key.Group == group
+	}, start, end, step, aggregate)
+}
+
+// Topic returns offset series of a topic in [start, end].
+func (lh *LagHistory) Topic(cluster string, topic string, start int64, end int64, step int64, aggregate Aggregate) []Series {
+	return lh.query(func(key SeriesKey) bool {
+		return key.Cluster == cluster && key.Group == "" && key.Topic == topic
+	}, start, end, step, aggregate)
+}
+
+// query returns matched series sorted by topic and partition, series without points in range are skipped.
+// Points are downsampled into buckets of step seconds if step is positive.
+func (lh *LagHistory) query(match func(SeriesKey) bool, start int64, end int64, step int64, aggregate Aggregate) []Series {
+	lh.RLock()
+	defer lh.RUnlock()
+
+	// points older than Retention are not returned even if they are still in ring.
+	if r := lh.latest(); start < r-int64(lh.Retention/time.Second) {
+		start = r - int64(lh.Retention/time.Second)
+	}
+
+	res := []Series{}
+	for key, r := range lh.series {
+		if !match(key) {
+			continue
+		}
+		var points []Point
+		for i := 0; i < r.size; i++ {
+			point := r.at(i)
+			if point.Timestamp >= start && point.Timestamp <= end {
+				points = append(points, point)
+			}
+		}
+		if len(points) == 0 {
+			continue
+		}
+		if step > 0 {
+			points = downsample(points, start, step, aggregate)
+		}
+		res = append(res, Series{SeriesKey: key, Points: points})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Topic != res[j].Topic {
+			return res[i].Topic < res[j].Topic
+		}
+		return res[i].Partition < res[j].Partition
+	})
+	return res
+}
+
+// latest returns the latest timestamp of all series, it should be called with lock held.
+func (lh *LagHistory) latest() int64 {
+	var latest int64
+	for _, r := range lh.series {
+		if ts := r.last().Timestamp; ts > latest {
+			latest = ts
+		}
+	}
+	return latest
+}
+
+// downsample aggregates sorted points into buckets [start+k*step, start+(k+1)*step),
+// each bucket is one point at its start.
+func downsample(points []Point, start int64, step int64, aggregate Aggregate) []Point {
+	var res []Point
+	var sum float64
+	count := 0
+	for i, point := range points {
+		bucket := start + (point.Timestamp-start)/step*step
+		if count == 0 {
+			res = append(res, Point{Timestamp: bucket, Value: point.Value})
+		}
+		current := &res[len(res)-1]
+		sum += point.Value
+		count++
+		switch aggregate {
+		case AggregateMax:
+			if point.Value > current.Value {
+				current.Value = point.Value
+			}
+		case AggregateLast:
+			current.Value = point.Value
+		default:
+			current.Value = sum / float64(count)
+		}
+		// next point starts a new bucket.
+		if i+1 < len(points) && start+(points[i+1].Timestamp-start)/step*step != bucket {
+			sum, count = 0, 0
+		}
+	}
+	return res
+}
@@ -0,0 +1,99 @@
+package module
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLagHistoryRing(t *testing.T) {
+	lh := &LagHistory{MaxPoints: 3}
+	lh.Init()
+	defer lh.Stop()
+
+	for i := 1; i <= 5; i++ {
+		lh.RecordPartitionLag("test", "group", "topic", 0, i*10, int64(i*100))
+	}
+	// out of order point is dropped.
+	lh.RecordPartitionLag("test", "group", "topic", 0, 0, 50)
+
+	series := lh.Consumer("test", "group", 0, 1000, 0, AggregateAvg)
+	assert.Equal(t, 1, len(series))
+	assert.Equal(t, []Point{{300, 30}, {400, 40}, {500, 50}}, series[0].Points, "oldest points are overwritten")
+	assert.Equal(t, 3, cap(lh.series[series[0].SeriesKey].points), "ring doesn't grow over MaxPoints")
+
+	series = lh.Consumer("test", "group", 350, 450, 0, AggregateAvg)
+	assert.Equal(t, []Point{{400, 40}}, series[0].Points)
+	assert.Equal(t, 0, len(lh.Consumer("test", "unknown", 0, 1000, 0, AggregateAvg)))
+}
+
+func TestLagHistoryQuery(t *testing.T) {
+	lh := &LagHistory{Retention: 10 * time.Minute}
+	lh.Init()
+	defer lh.Stop()
+
+	for ts := int64(1000); ts < 1120; ts += 30 {
+		lh.RecordTotalLag("test", "group", int(ts-1000), ts)
+		lh.RecordPartitionLag("test", "group", "topic", 1, 1, ts)
+		lh.RecordPartitionLag("test", "group", "topic", 0, 0, ts)
+		lh.RecordTopicOffset("test", "topic", 0, int(ts), ts)
+	}
+
+	series := lh.Consumer("test", "group", 1000, 1090, 60, AggregateMax)
+	assert.Equal(t, 3, len(series))
+	assert.Equal(t, -1, series[0].Partition, "total lag goes first")
+	assert.Equal(t, 0, series[1].Partition)
+	assert.Equal(t, []Point{{1000, 30}, {1060, 90}}, series[0].Points)
+
+	series = lh.Consumer("test", "group", 1000, 1090, 60, AggregateAvg)
+	assert.Equal(t, []Point{{1000, 15}, {1060, 75}}, series[0].Points)
+	series = lh.Consumer("test", "group", 1000, 1090, 60, AggregateLast)
+	assert.Equal(t, []Point{{1000, 30}, {1060, 90}}, series[0].Points)
+
+	series = lh.Topic("test", "topic", 0, 2000, 0, AggregateAvg)
+	assert.Equal(t, 1, len(series), "consumer partition lags are not topic offsets")
+	assert.Equal(t, 4, len(series[0].Points))
+
+	// points older than Retention are not returned.
+	lh.RecordTopicOffset("test", "topic", 0, 2000, 2000)
+	series = lh.Topic("test", "topic", 0, 2000, 0, AggregateAvg)
+	assert.Equal(t, []Point{{2000, 2000}}, series[0].Points)
+}
+
+func TestLagHistoryMaxSeries(t *testing.T) {
+	lh := &LagHistory{Retention: time.Minute, MaxSeries: 2}
+	lh.Init()
+	defer lh.Stop()
+
+	assert.True(t, lh.RecordTotalLag("test", "a", 1, 100))
+	assert.True(t, lh.RecordTotalLag("test", "b", 1, 150))
+	assert.False(t, lh.RecordTotalLag("test", "c", 1, 150), "no series expired")
+	assert.True(t, lh.RecordTotalLag("test", "c", 1, 200), "series a is expired")
+	assert.Equal(t, 2, lh.Len())
+
+	var nilHistory *LagHistory
+	assert.True(t, nilHistory.RecordTotalLag("test", "a", 1, 100))
+}
+
+func TestLagHistoryGrowAndExpire(t *testing.T) {
+	lh := &LagHistory{Retention: time.Minute, MaxPoints: 720, expireInterval: 10 * time.Millisecond}
+	lh.Init()
+	defer lh.Stop()
+
+	now := time.Now().Unix()
+	lh.RecordTotalLag("test", "old", 1, now-120)
+	for i := 0; i < 3; i++ {
+		lh.RecordTotalLag("test", "new", i, now+int64(i))
+	}
+	lh.RLock()
+	r := lh.series[SeriesKey{Cluster: "test", Group: "new", Partition: -1}]
+	assert.True(t, cap(r.points) < 720, "ring grows with points")
+	lh.RUnlock()
+	series := lh.Consumer("test", "new", 0, now+10, 0, AggregateAvg)
+	assert.Equal(t, []Point{{now, 0}, {now + 1, 1}, {now + 2, 2}}, series[0].Points)
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 1, lh.Len(), "series older than Retention is expired on timer")
+	assert.Equal(t, 0, len(lh.Consumer("test", "old", 0, now, 0, AggregateAvg)))
+}
@@ -0,0 +1,174 @@
+package module
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OffsetHistory keeps recent head offsets of every topic partition.
+// It is written by topic handlers and read by translators,
+// to estimate how many seconds a consumer is behind the head.
+// Usage:
+// history.Init()
+// history.Record(cluster, topic, partition, headOffset, timestamp)
+// seconds, ok := history.SecondsBehind(cluster, topic, partition, consumerOffset, now)
+type OffsetHistory struct {
+	sync.RWMutex
+
+	// MaxSamples is how many head offsets kept per partition,
+	// 60 by default, i.e. 1 hour with 60s topic poll interval.
+	MaxSamples int
+
+	partitions map[string][]offsetSample
+}
+
+type offsetSample struct {
+	timestamp int64
+	offset    int
+}
+
+// Init is a general init
+func (oh *OffsetHistory) Init() {
+	oh.partitions = make(map[string][]offsetSample)
+	if oh.MaxSamples == 0 {
+		oh.MaxSamples = 60
+	}
+}
+
+func offsetHistoryKey(cluster string, topic string, partition int) string {
+	return cluster + ":" + topic + ":" + strconv.Itoa(partition)
+}
+
+// Record adds head offset of a partition at timestamp(seconds).
+// A head offset going backwards means the topic is recreated, its history is reset.
+func (oh *OffsetHistory) Record(cluster string, topic string, partition int, offset int, timestamp int64) {
+	if oh == nil {
+		return
+	}
+	key := offsetHistoryKey(cluster, topic, partition)
+
+	oh.Lock()
+	defer oh.Unlock()
+	samples := oh.partitions[key]
+	if n := len(samples); n > 0 {
+		last := samples[n-1]
+		if timestamp <= last.timestamp {
+			return
+		}
+		if offset < last.offset {
+			samples = nil
+		}
+	}
+	samples = append(samples, offsetSample{timestamp: timestamp, offset: offset})
+	if len(samples) > oh.MaxSamples {
+		samples = append(samples[:0:0], samples[len(samples)-oh.MaxSamples:]...)
+	}
+	oh.partitions[key] = samples
+}
+
+// Head returns the latest head offset of a partition.
+func (oh *OffsetHistory) Head(cluster string, topic string, partition int) (int, bool) {
+	if oh == nil {
+		return 0, false
+	}
+	oh.RLock()
+	defer oh.RUnlock()
+	samples := oh.partitions[offsetHistoryKey(cluster, topic, partition)]
+	if len(samples) == 0 {
+		return 0, false
+	}
+	return samples[len(samples)-1].offset, true
+}
+
+// Forget removes history of all partitions of topic, for a topic gone from Burrow.
+// An empty topic removes the whole cluster.
+func (oh *OffsetHistory) Forget(cluster string, topic string) {
+	if oh == nil {
+		return
+	}
+	prefix := cluster + ":"
+	if topic != "" {
+		prefix += topic + ":"
+	}
+
+	oh.Lock()
+	defer oh.Unlock()
+	for key := range oh.partitions {
+		if strings.HasPrefix(key, prefix) {
+			delete(oh.partitions, key)
+		}
+	}
+}
+
+// SecondsBehind estimates how long ago the head passed consumer offset, i.e. how old
+// the first unconsumed message is at now. Head time between two samples is linearly
+// interpolated, and extrapolated by the average produce rate if offset is older than history.
+// It returns false if there is no history of this partition yet.
+func (oh *OffsetHistory) SecondsBehind(cluster string, topic string, partition int, offset int, now int64) (int64, bool) {
+	if oh == nil {
+		return 0, false
+	}
+	key := offsetHistoryKey(cluster, topic, partition)
+
+	oh.RLock()
+	defer oh.RUnlock()
+	samples := oh.partitions[key]
+	n := len(samples)
+	if n == 0 {
+		return 0, false
+	}
+
+	// the first unconsumed message is at offset, it's produced when head moves over offset.
+	next := float64(offset + 1)
+	i := 0
+	for i < n && samples[i].offset <= offset {
+		i++
+	}
+
+	var headTime float64
+	switch {
+	case i == n:
+		// consumer has caught up with the latest head we know.
+		return 0, true
+	case i == 0:
+		first, last := samples[0], samples[n-1]
+		headTime = float64(first.timestamp)
+		if last.offset > first.offset {
+			rate := float64(last.offset-first.offset) / float64(last.timestamp-first.timestamp)
+			headTime -= (float64(first.offset) - next) / rate
+		}
+	default:
+		prev, curt := samples[i-1], samples[i]
+		headTime = float64(prev.timestamp) +
+			(next-float64(prev.offset))*float64(curt.timestamp-prev.timestamp)/float64(curt.offset-prev.offset)
+	}
+
+	seconds := int64(float64(now) - headTime)
+	if seconds < 0 {
+		seconds = 0
+	}
+	return seconds, true
+}
+
+// ProduceRate returns messages produced per second over the last window of history,
+// the last 2 samples are used if there are less than 2 samples in window.
+func (oh *OffsetHistory) ProduceRate(cluster string, topic string, partition int, window time.Duration) (float64, bool) {
+	if oh == nil {
+		return 0, false
+	}
+	oh.RLock()
+	defer oh.RUnlock()
+	samples := oh.partitions[offsetHistoryKey(cluster, topic, partition)]
+	n := len(samples)
+	if n < 2 {
+		return 0, false
+	}
+	last := samples[n-1]
+	first := samples[n-2]
+	for i := n - 2; i >= 0 && last.timestamp-samples[i].timestamp <= int64(window/time.Second); i-- {
+		first = samples[i]
+	}
+	return float64(last.offset-first.offset) / float64(last.timestamp-first.timestamp), true
+}
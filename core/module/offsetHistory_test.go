@@ -0,0 +1,63 @@
+package module
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func prepareOffsetHistory() *OffsetHistory {
+	oh := &OffsetHistory{MaxSamples: 3}
+	oh.Init()
+	// 10 messages per second
+	oh.Record("test", "topic", 0, 1000, 100)
+	oh.Record("test", "topic", 0, 1600, 160)
+	oh.Record("test", "topic", 0, 2200, 220)
+	return oh
+}
+
+func TestSecondsBehind(t *testing.T) {
+	oh := prepareOffsetHistory()
+
+	seconds, ok := oh.SecondsBehind("test", "topic", 0, 1299, 230)
+	assert.True(t, ok)
+	assert.Equal(t, int64(100), seconds, "message 1299 is produced at 130")
+
+	seconds, _ = oh.SecondsBehind("test", "topic", 0, 2200, 230)
+	assert.Equal(t, int64(0), seconds, "consumer caught up with head")
+
+	seconds, _ = oh.SecondsBehind("test", "topic", 0, 499, 230)
+	assert.Equal(t, int64(180), seconds, "message 499 is produced at 50 by average rate")
+
+	_, ok = oh.SecondsBehind("test", "topic", 1, 0, 230)
+	assert.False(t, ok, "no history for partition 1")
+}
+
+func TestOffsetHistoryRecord(t *testing.T) {
+	oh := prepareOffsetHistory()
+
+	oh.Record("test", "topic", 0, 2800, 280)
+	assert.Equal(t, 3, len(oh.partitions["test:topic:0"]), "history is limited by MaxSamples")
+	assert.Equal(t, 1600, oh.partitions["test:topic:0"][0].offset)
+
+	oh.Record("test", "topic", 0, 10, 340)
+	assert.Equal(t, 1, len(oh.partitions["test:topic:0"]), "history is reset when topic is recreated")
+
+	var nilHistory *OffsetHistory
+	nilHistory.Record("test", "topic", 0, 10, 340)
+	_, ok := nilHistory.SecondsBehind("test", "topic", 0, 10, 340)
+	assert.False(t, ok)
+}
+
+func TestOffsetHistoryForget(t *testing.T) {
+	oh := prepareOffsetHistory()
+	oh.Record("test", "topic", 1, 10, 100)
+	oh.Record("test", "topic2", 0, 10, 100)
+	oh.Record("test2", "topic", 0, 10, 100)
+
+	oh.Forget("test", "topic")
+	assert.Equal(t, 2, len(oh.partitions), "all partitions of test:topic should be removed")
+
+	oh.Forget("test", "")
+	assert.Equal(t, 1, len(oh.partitions), "cluster test should be removed")
+}
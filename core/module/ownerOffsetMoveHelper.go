@@ -13,37 +13,46 @@ import (
 
 // OwnerOffsetMoveHelper is for statistics of how many records
 // handled per partiton per host per minute.
+// It sends per minute rate as {prefix}.{tag}.{partition}, per second rate as
+// {prefix}.{tag}PerSecond.{partition}, and {prefix}.{tag}Reset.{partition} when offset goes backwards,
+// tagged with owner, and topic for keys of RateKey.
 type OwnerOffsetMoveHelper struct {
+	// Tags are added to all its metrics, e.g. input=push.
+	Tags         map[string]string
 	CountService *CountService
-	ProduceQueue chan<- string
+	ProduceQueue chan<- protocol.Metric
 	Logger       *zap.Logger
+	// RateWindow is the sliding window of rate, 2 minutes by default.
+	RateWindow time.Duration
 
-	syncMap     *util.SyncNestedMap
+	rates       *util.RateCalculator
 	prefix      string
-	postfix     string
 	env         string
 	tag         string
 	ticker      *time.Ticker
 	quitChannel chan struct{}
+	doneChannel chan struct{}
 }
 
 // Init is a general Init
-func (oom *OwnerOffsetMoveHelper) Init(prefix string, postfix string, env string, tag string) {
-	oom.syncMap = &util.SyncNestedMap{}
-	oom.syncMap.Init()
+func (oom *OwnerOffsetMoveHelper) Init(prefix string, env string, tag string) {
+	oom.rates = &util.RateCalculator{Window: oom.RateWindow}
+	oom.rates.Init()
+	oom.RateWindow = oom.rates.Window
 
 	oom.prefix = prefix
-	oom.postfix = postfix
 	oom.env = env
 	oom.tag = tag
 
 	oom.ticker = time.NewTicker(60 * time.Second)
 	oom.quitChannel = make(chan struct{})
+	oom.doneChannel = make(chan struct{})
 	go func() {
+		defer close(oom.doneChannel)
 		for {
 			select {
 			case <-oom.ticker.C:
-				oom.generateMetrics()
+				oom.generateMetrics(time.Now().Unix())
 			case <-oom.quitChannel:
 				return
 			}
@@ -51,85 +60,108 @@ func (oom *OwnerOffsetMoveHelper) Init(prefix string, postfix string, env string
 	}()
 }
 
-// Stop is a general stop
+// Stop stops its ticker and waits until the metrics being generated are sent.
 func (oom *OwnerOffsetMoveHelper) Stop() error {
 	oom.Logger.Info("stopping")
 
 	oom.ticker.Stop()
 	close(oom.quitChannel)
+	<-oom.doneChannel
 
 	return nil
 }
 
-// Update updates current offset for different key.
-func (oom *OwnerOffsetMoveHelper) Update(key string, offset int, timestamp int64) {
-	// It works for the current case. ie. concurrent competition only exists in different key.
-	// It doesn't work for "concurrent competition exists in the same key at the same time".
-	partitionOffsetMove := oom.GetSyncMap().GetChild(key, protocol.PartitionOffsetMove{}).(protocol.PartitionOffsetMove)
-
-	partitionOffsetMove.LastOffset = partitionOffsetMove.CurtOffset
-	partitionOffsetMove.LastTimestamp = partitionOffsetMove.CurtTimestamp
+// RateKey is the key of offset rate of a partition consumed by owner, "owner:topic:partitionID".
+// Topic is in the key, as an owner may consume the same partition id of several topics.
+func RateKey(owner string, topic string, partition int) string {
+	return owner + ":" + topic + ":" + strconv.Itoa(partition)
+}
 
-	partitionOffsetMove.CurtOffset = offset
-	partitionOffsetMove.CurtTimestamp = timestamp
+// parseRateKey parses "owner:topic:partitionID" of RateKey, or "owner:partitionID".
+// owner may contain ':', e.g. an IPv6 host, a topic name can't.
+func parseRateKey(key string) (owner string, topic string, partition string, ok bool) {
+	i := strings.LastIndex(key, ":")
+	if i <= 0 {
+		return "", "", "", false
+	}
+	if _, err := strconv.Atoi(key[i+1:]); err != nil {
+		return "", "", "", false
+	}
+	owner, partition = key[:i], key[i+1:]
+	if j := strings.LastIndex(owner, ":"); j > 0 {
+		owner, topic = owner[:j], owner[j+1:]
+	}
+	return owner, topic, partition, true
+}
 
-	oom.GetSyncMap().PutChild(key, partitionOffsetMove)
+// rateTags are tags of metrics of a parsed key.
+func (oom *OwnerOffsetMoveHelper) rateTags(owner string, topic string) map[string]string {
+	tags := map[string]string{"owner": owner}
+	for k, v := range oom.Tags {
+		tags[k] = v
+	}
+	if topic != "" {
+		tags["topic"] = topic
+	}
+	return tags
 }
 
-func (oom *OwnerOffsetMoveHelper) generateMetrics() {
-	keys := oom.syncMap.GetKeys()
+// Update updates current offset for different key, key is RateKey, or "owner:partitionID".
+func (oom *OwnerOffsetMoveHelper) Update(key string, offset int, timestamp int64) {
+	if reset := oom.rates.Update(key, offset, timestamp); reset != "" {
+		oom.Logger.Info("offset reset",
+			zap.String("key", key),
+			zap.String("prefix", oom.prefix),
+			zap.String("kind", string(reset)),
+			zap.Int("offset", offset),
+			zap.Int64("timestamp", timestamp),
+		)
+	}
+}
 
-	for _, k := range keys {
+func (oom *OwnerOffsetMoveHelper) generateMetrics(now int64) {
+	// keys not updated in 2 windows are gone, e.g. partition moved to another owner.
+	oom.rates.Prune(now - 2*int64(oom.RateWindow/time.Second))
 
-		ks := strings.Split(k, ":")
-		if len(ks) != 2 {
-			// the params are not "owner:partitionID" format, skip this one.
+	for _, rate := range oom.rates.Rates() {
+		owner, topic, partition, ok := parseRateKey(rate.Key)
+		if !ok {
+			// the params are not RateKey format, skip this one.
 			oom.CountService.Increase("exception.invalidFormat", oom.env)
 			continue
 		}
+		tags := oom.rateTags(owner, topic)
 
-		oom.syncMap.SetLock(k)
-		// populate offset move metric
-		partitionOffsetMove := oom.syncMap.GetChild(k, protocol.PartitionOffsetMove{}).(protocol.PartitionOffsetMove)
-		timeDiff := partitionOffsetMove.CurtTimestamp - partitionOffsetMove.LastTimestamp
-		offsetDiff := partitionOffsetMove.CurtOffset - partitionOffsetMove.LastOffset
-		ownerTag := "owner=" + ks[0]
-
-		if timeDiff == 30 {
-			// it's a risky logic.
-			// From my observation, the timeDiff is always stable
-			// but it may change when tasks overload
-			// just because this way is easy to implement and good for now.
-			// I will think of how to get a better solution.
-			offsetMove := strconv.Itoa(offsetDiff * 2)
-			oom.ProduceQueue <- combineInfo([]string{oom.prefix, oom.tag, ks[1]},
-				[]string{offsetMove, strconv.FormatInt(partitionOffsetMove.CurtTimestamp, 10), oom.postfix, ownerTag})
-		} else if timeDiff == 60 {
-			offsetMove := strconv.Itoa(offsetDiff)
-			oom.ProduceQueue <- combineInfo([]string{oom.prefix, oom.tag, ks[1]},
-				[]string{offsetMove, strconv.FormatInt(partitionOffsetMove.CurtTimestamp, 10), oom.postfix, ownerTag})
-		} else {
-			// the precise result should be
-			// offsetMove := strconv.FormatInt(int64(float64(offsetDiff*60)/float64(timeDiff)), 10)
-			oom.CountService.Increase("exception.timeDiffInvalid", oom.env)
-			oom.Logger.Warn("TimeDiff is not valid",
-				zap.String("cluster", oom.env),
-				zap.String("tag", oom.tag),
-				zap.String("key", k),
-				zap.String("prefix", oom.prefix),
-				zap.Int64("timeDiff", timeDiff),
-				zap.Int64("timestamp", partitionOffsetMove.CurtTimestamp),
-			)
+		if rate.Reset != "" {
+			oom.CountService.Increase("offsetReset", oom.env)
+			oom.ProduceQueue <- protocol.NewMetric([]string{oom.prefix, oom.tag + "Reset", partition},
+				1, rate.Timestamp, protocol.WithTag(tags, "kind", string(rate.Reset)))
+		}
+		if !rate.Valid {
+			continue
 		}
-		oom.syncMap.ReleaseLock(k)
+		oom.ProduceQueue <- protocol.NewMetric([]string{oom.prefix, oom.tag, partition},
+			rate.PerMinute, rate.Timestamp, tags)
+		oom.ProduceQueue <- protocol.NewMetric([]string{oom.prefix, oom.tag + "PerSecond", partition},
+			rate.PerSecond, rate.Timestamp, tags)
 	}
 }
 
-// GetSyncMap returns its syncMap
-func (oom *OwnerOffsetMoveHelper) GetSyncMap() *util.SyncNestedMap {
-	return oom.syncMap
+// Finish sends 0 rate for all keys and frees them, for a consumer/topic gone from Burrow.
+func (oom *OwnerOffsetMoveHelper) Finish(timestamp int64) {
+	for _, rate := range oom.rates.Rates() {
+		owner, topic, partition, ok := parseRateKey(rate.Key)
+		if !ok {
+			continue
+		}
+		tags := oom.rateTags(owner, topic)
+		oom.ProduceQueue <- protocol.NewMetric([]string{oom.prefix, oom.tag, partition}, 0, timestamp, tags)
+		oom.ProduceQueue <- protocol.NewMetric([]string{oom.prefix, oom.tag + "PerSecond", partition}, 0, timestamp, tags)
+	}
+	oom.rates.Prune(timestamp + 1)
 }
 
-func combineInfo(prefix []string, postfix []string) string {
-	return strings.Join(prefix, ".") + " " + strings.Join(postfix, " ")
+// GetRateCalculator returns its rate calculator
+func (oom *OwnerOffsetMoveHelper) GetRateCalculator() *util.RateCalculator {
+	return oom.rates
 }
@@ -12,10 +12,11 @@ import (
 
 func TestMain(m *testing.M) {
 	os.Setenv("configPath", "../../config/config.json")
+	os.Exit(m.Run())
 }
 
-func TestUpdate(t *testing.T) {
-	produceQueue := make(chan string, 9000)
+func prepareOwnerOffsetMoveHelper() (*OwnerOffsetMoveHelper, chan protocol.Metric) {
+	produceQueue := make(chan protocol.Metric, 9000)
 	countService := &CountService{ProduceQueue: produceQueue}
 	countService.Start()
 
@@ -24,61 +25,112 @@ func TestUpdate(t *testing.T) {
 		ProduceQueue: produceQueue,
 		Logger:       zap.NewNop(),
 	}
-	oom.Init("prefix", "postfix", "env", "tag")
+	oom.Init("prefix", "env", "tag")
+	return oom, produceQueue
+}
 
-	oom.Update("test1:1", 10, 30)
-	keys := oom.GetSyncMap().GetKeys()
-	assert.Equal(t, 1, len(keys), "keys length should be 1")
+func TestUpdate(t *testing.T) {
+	oom, produceQueue := prepareOwnerOffsetMoveHelper()
+	defer oom.Stop()
 
-	partitionOffsetMove := oom.GetSyncMap().GetChild("test1:1", nil).(protocol.PartitionOffsetMove)
-	assert.Equal(t, 10, partitionOffsetMove.CurtOffset, "curt offset should be 10")
+	oom.Update("test1:1", 10, 30)
+	assert.Equal(t, 1, oom.GetRateCalculator().Len(), "keys length should be 1")
 
 	oom.Update("test2:2", 20, 50)
-	partitionOffsetMove = oom.GetSyncMap().GetChild("test2:2", nil).(protocol.PartitionOffsetMove)
-	assert.Equal(t, 20, partitionOffsetMove.CurtOffset, "curt offset should be 20")
-
 	oom.Update("test1:1", 30, 60)
-	partitionOffsetMove = oom.GetSyncMap().GetChild("test1:1", nil).(protocol.PartitionOffsetMove)
-	assert.Equal(t, 30, partitionOffsetMove.CurtOffset, "curt offset should be 30")
+	assert.Equal(t, 2, oom.GetRateCalculator().Len(), "keys length should be 2")
 
-	// oom.generateMetrics()
-	// res1 := <-produceQueue
-	// res2 := <-produceQueue
-
-	// assert.Equal(t, "prefix.hosts.1 20 60 postfix owner=test1", res1, "wrong")
-	// assert.Equal(t, "prefix.hosts.2 20 50 postfix owner=test2", res2, "wrong")
+	rates := oom.GetRateCalculator().Rates()
+	assert.Equal(t, "test1:1", rates[0].Key)
+	assert.True(t, rates[0].Valid)
+	assert.Equal(t, float64(40), rates[0].PerMinute, "20 records in 30s should be 40 per minute")
+	assert.False(t, rates[1].Valid, "test2:2 has only one sample")
 
 	close(produceQueue)
 }
 
 func TestGenerateMetrics(t *testing.T) {
-	produceQueue := make(chan string, 9000)
-	countService := &CountService{ProduceQueue: produceQueue}
-	countService.Start()
+	oom, produceQueue := prepareOwnerOffsetMoveHelper()
+	defer oom.Stop()
 
-	oom := &OwnerOffsetMoveHelper{
-		CountService: countService,
-		ProduceQueue: produceQueue,
-		Logger:       zap.NewNop(),
-	}
-	oom.Init("prefix", "postfix", "env", "tag")
+	assert.Equal(t, 0, oom.GetRateCalculator().Len(), "keys length should be 0")
+
+	// timeDiff used to be 30s or 60s only, 45s works now.
+	oom.Update("test1:1", 100, 1000)
+	oom.Update("test1:1", 190, 1045)
+	oom.Update("testInvalid", 1, 1000)
+	// test2:2 rewinds
+	oom.Update("test2:2", 100, 1000)
+	oom.Update("test2:2", 50, 1030)
 
-	keys := oom.GetSyncMap().GetKeys()
-	assert.Equal(t, 0, len(keys), "keys length should be 0")
+	oom.generateMetrics(1045)
 
-	oom.GetSyncMap().GetChild("test1:1", protocol.PartitionOffsetMove{4, 3, 2, 1})
-	oom.GetSyncMap().GetChild("testInvalid:2", protocol.PartitionOffsetMove{})
-	oom.GetSyncMap().GetChild("test2:2", protocol.PartitionOffsetMove{60, 100, 30, 50})
+	metrics := make(map[string]protocol.Metric)
+	for len(produceQueue) > 0 {
+		metric := <-produceQueue
+		metrics[metric.Name] = metric
+	}
 
-	keys = oom.GetSyncMap().GetKeys()
-	assert.Equal(t, 3, len(keys), "keys length should be 3")
+	assert.Equal(t, float64(120), metrics["prefix.tag.1"].Value, "90 records in 45s should be 120 per minute")
+	assert.Equal(t, float64(2), metrics["prefix.tagPerSecond.1"].Value)
+	assert.Equal(t, int64(1045), metrics["prefix.tag.1"].Timestamp)
+	assert.Equal(t, map[string]string{"owner": "test1"}, metrics["prefix.tag.1"].Tags)
 
-	// oom.generateMetrics()
-	// res1 := <-produceQueue
-	// res2 := <-produceQueue
+	reset, ok := metrics["prefix.tagReset.2"]
+	assert.True(t, ok, "rewind should send a reset event")
+	assert.Equal(t, map[string]string{"owner": "test2", "kind": "rewind"}, reset.Tags)
+	_, ok = metrics["prefix.tag.2"]
+	assert.False(t, ok, "rate should not span a reset")
 
-	// assert.Equal(t, "prefix.hosts.1 2 4 postfix owner=test1", res1, "wrong")
-	// assert.Equal(t, "prefix.hosts.2 50 60 postfix owner=test2", res2, "wrong")
+	// reset is only reported once, stale keys are pruned.
+	oom.generateMetrics(1045 + 10*60)
+	assert.Equal(t, 0, len(produceQueue))
+	assert.Equal(t, 0, oom.GetRateCalculator().Len())
 
 	close(produceQueue)
 }
+
+func TestParseRateKey(t *testing.T) {
+	owner, topic, partition, ok := parseRateKey(RateKey("::1", "orders", 3))
+	assert.True(t, ok)
+	assert.Equal(t, []string{"::1", "orders", "3"}, []string{owner, topic, partition}, "owner may contain ':'")
+	owner, topic, partition, ok = parseRateKey("orders:3")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"orders", "", "3"}, []string{owner, topic, partition})
+	_, _, _, ok = parseRateKey("orders:x")
+	assert.False(t, ok)
+
+	oom, produceQueue := prepareOwnerOffsetMoveHelper()
+	defer oom.Stop()
+	oom.Update(RateKey("host", "orders", 1), 0, 1000)
+	oom.Update(RateKey("host", "orders", 1), 60, 1060)
+	oom.Update(RateKey("host", "payments", 1), 0, 1000)
+	oom.Update(RateKey("host", "payments", 1), 600, 1060)
+	oom.generateMetrics(1060)
+	values := make(map[string]float64)
+	for len(produceQueue) > 0 {
+		metric := <-produceQueue
+		if metric.Name == "prefix.tag.1" {
+			values[metric.Tags["topic"]] = metric.Value
+		}
+	}
+	assert.Equal(t, map[string]float64{"orders": 60, "payments": 600}, values, "rates of topics are apart")
+}
+
+func TestFinish(t *testing.T) {
+	oom, produceQueue := prepareOwnerOffsetMoveHelper()
+	defer oom.Stop()
+
+	oom.Update("test1:1", 100, 1000)
+	oom.Update("test1:1", 190, 1045)
+	oom.Finish(1100)
+
+	metrics := make(map[string]protocol.Metric)
+	for len(produceQueue) > 0 {
+		metric := <-produceQueue
+		metrics[metric.Name] = metric
+	}
+	assert.Equal(t, float64(0), metrics["prefix.tag.1"].Value, "rate should end with 0")
+	assert.Equal(t, int64(1100), metrics["prefix.tag.1"].Timestamp)
+	assert.Equal(t, 0, oom.GetRateCalculator().Len(), "keys should be freed")
+}
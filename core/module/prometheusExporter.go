@@ -0,0 +1,196 @@
+package module
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/harbinzhang/goRainbow/core/protocol"
+)
+
+// PrometheusExporter keeps the latest value of every metric sent through ProduceQueue
+// and serves them as labelled gauges in Prometheus text format.
+// Usage:
+// exporter.Init()
+// exporter.Update(${metric})
+// http.HandleFunc("/metrics", PrometheusHandler(exporter))
+type PrometheusExporter struct {
+	sync.Mutex
+
+	// Expiry drops a series which has not been updated for a while,
+	// so consumers removed from Burrow disappear from /metrics.
+	Expiry time.Duration
+
+	families map[string]map[string]*gaugeSample
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// familyAliases are gauge names of metric families whose names don't read well in snake case.
+var familyAliases = map[string]string{
+	"maxLagmaxLagPartitionID": "maxLagPartition",
+}
+
+type gaugeSample struct {
+	value   float64
+	updated time.Time
+}
+
+// Init is a general init
+func (pe *PrometheusExporter) Init() {
+	pe.families = make(map[string]map[string]*gaugeSample)
+	if pe.Expiry == 0 {
+		pe.Expiry = 5 * time.Minute
+	}
+}
+
+// Update stores metric as a gauge, its tags become labels.
+func (pe *PrometheusExporter) Update(metric protocol.Metric) {
+	labels := make(map[string]string, len(metric.Tags)+2)
+	for k, v := range metric.Tags {
+		// topic offsets are tagged partitionId, it's the same label as partition of consumer metrics.
+		if k == "partitionId" {
+			k = "partition"
+		}
+		labels[sanitizeName(k)] = v
+	}
+
+	family := parseFamily(metric, labels)
+	seriesKey := labelsString(labels)
+
+	pe.Lock()
+	defer pe.Unlock()
+	series, ok := pe.families[family]
+	if !ok {
+		series = make(map[string]*gaugeSample)
+		pe.families[family] = series
+	}
+	series[seriesKey] = &gaugeSample{
+		value:   metric.Value,
+		updated: time.Now(),
+	}
+}
+
+// WriteTo writes all alive gauges in Prometheus text format, expired ones are removed.
+func (pe *PrometheusExporter) WriteTo(buf *bytes.Buffer) {
+	pe.Lock()
+	defer pe.Unlock()
+
+	deadline := time.Now().Add(-pe.Expiry)
+
+	families := make([]string, 0, len(pe.families))
+	for family := range pe.families {
+		families = append(families, family)
+	}
+	sort.Strings(families)
+
+	for _, family := range families {
+		series := pe.families[family]
+		keys := make([]string, 0, len(series))
+		for key, sample := range series {
+			if sample.updated.Before(deadline) {
+				delete(series, key)
+				continue
+			}
+			keys = append(keys, key)
+		}
+		if len(keys) == 0 {
+			delete(pe.families, family)
+			continue
+		}
+		sort.Strings(keys)
+
+		buf.WriteString("# TYPE " + family + " gauge\n")
+		for _, key := range keys {
+			buf.WriteString(family + key + " " + strconv.FormatFloat(series[key].value, 'f', -1, 64) + "\n")
+		}
+	}
+}
+
+// PrometheusHandler is for /metrics service.
+func PrometheusHandler(pe *PrometheusExporter) func(http.ResponseWriter, *http.Request) {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		pe.WriteTo(&buf)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(buf.Bytes())
+	}
+}
+
+// parseFamily gets gauge name from metric family, and moves the parts
+// of metric name which are values(cluster, partition) into labels.
+// e.g. fjord.burrow.{cluster}.{consumer}.totalLag -> burrow_total_lag{cluster=...}
+// e.g. fjord.burrow.{cluster}.{consumer}.hosts.{partition} -> burrow_hosts{cluster=...,partition=...}
+// Counters are named as in CountService, e.g. fjord.burrow.{env}.exception.ownerInvalid -> burrow_exception_owner_invalid
+func parseFamily(metric protocol.Metric, labels map[string]string) string {
+	if metric.Kind == protocol.KindCounter {
+		return "burrow_" + toSnakeCase(metric.CounterName())
+	}
+
+	segments := strings.Split(metric.Name, ".")
+	if len(segments) > 3 && segments[0] == "fjord" && segments[1] == "burrow" {
+		labels["cluster"] = segments[2]
+	}
+
+	family := metric.Family()
+	if last := segments[len(segments)-1]; last != family {
+		labels["partition"] = last
+	}
+	if alias, ok := familyAliases[family]; ok {
+		family = alias
+	}
+
+	return "burrow_" + toSnakeCase(family)
+}
+
+// toSnakeCase translates totalLag to total_lag, an acronym is one word, e.g. partitionID to partition_id.
+func toSnakeCase(name string) string {
+	runes := []rune(name)
+	var res []rune
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// a word starts at an upper after a lower, or at the last upper of an acronym followed by a lower.
+			if i > 0 && (!unicode.IsUpper(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) &&
+				res[len(res)-1] != '_' {
+				res = append(res, '_')
+			}
+			r = unicode.ToLower(r)
+		}
+		res = append(res, r)
+	}
+	return sanitizeName(string(res))
+}
+
+// sanitizeName replaces the chars which Prometheus doesn't accept in names.
+func sanitizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// labelsString is the sorted {k="v",...} part of a series, it's also used as series key.
+func labelsString(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"=\""+labelValueEscaper.Replace(labels[k])+"\"")
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
@@ -0,0 +1,81 @@
+package module
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harbinzhang/goRainbow/core/protocol"
+)
+
+func TestPrometheusExporterUpdate(t *testing.T) {
+	exporter := &PrometheusExporter{}
+	exporter.Init()
+
+	exporter.Update(protocol.Metric{
+		Name:      "fjord.burrow.test.group1.totalLag",
+		Value:     10,
+		Timestamp: 1547157609,
+		Tags:      map[string]string{"env": "test", "consumer": "group1"},
+	})
+	exporter.Update(protocol.Metric{
+		Name:      "fjord.burrow.test.group1.hosts.3",
+		Value:     20,
+		Timestamp: 1547157609,
+		Tags:      map[string]string{"owner": "host1"},
+	})
+
+	var buf bytes.Buffer
+	exporter.WriteTo(&buf)
+
+	expected := "# TYPE burrow_hosts gauge\n" +
+		"burrow_hosts{cluster=\"test\",owner=\"host1\",partition=\"3\"} 20\n" +
+		"# TYPE burrow_total_lag gauge\n" +
+		"burrow_total_lag{cluster=\"test\",consumer=\"group1\",env=\"test\"} 10\n"
+	assert.Equal(t, expected, buf.String(), "exposition not correct")
+}
+
+func TestPrometheusExporterNames(t *testing.T) {
+	exporter := &PrometheusExporter{}
+	exporter.Init()
+
+	exporter.Update(protocol.Metric{Name: "fjord.burrow.test.group1.maxLagmaxLagPartitionID", Value: 3,
+		Tags: map[string]string{"consumer": "group1", "topic": "t1"}})
+	exporter.Update(protocol.Metric{Name: "fjord.burrow.test.topic.t1.0.offset", Value: 100,
+		Tags: map[string]string{"topic": "t1", "partitionId": "0"}})
+	exporter.Update(protocol.Metric{Name: "fjord.burrow.test.exception.sinkDropped.kafka", Value: 2,
+		Tags: map[string]string{"env": "test"}, Kind: protocol.KindCounter})
+
+	var buf bytes.Buffer
+	exporter.WriteTo(&buf)
+
+	expected := "# TYPE burrow_exception_sink_dropped_kafka gauge\n" +
+		"burrow_exception_sink_dropped_kafka{env=\"test\"} 2\n" +
+		"# TYPE burrow_max_lag_partition gauge\n" +
+		"burrow_max_lag_partition{cluster=\"test\",consumer=\"group1\",topic=\"t1\"} 3\n" +
+		"# TYPE burrow_offset gauge\n" +
+		"burrow_offset{cluster=\"test\",partition=\"0\",topic=\"t1\"} 100\n"
+	assert.Equal(t, expected, buf.String(), "exposition names not correct")
+}
+
+func TestPrometheusExporterExpiry(t *testing.T) {
+	exporter := &PrometheusExporter{Expiry: 10 * time.Millisecond}
+	exporter.Init()
+
+	exporter.Update(protocol.Metric{Name: "fjord.burrow.test.group1.totalLag", Value: 10})
+	time.Sleep(20 * time.Millisecond)
+
+	var buf bytes.Buffer
+	exporter.WriteTo(&buf)
+	assert.Equal(t, "", buf.String(), "expired series should be removed")
+}
+
+func TestToSnakeCase(t *testing.T) {
+	assert.Equal(t, "total_lag", toSnakeCase("totalLag"))
+	assert.Equal(t, "lag", toSnakeCase("Lag"))
+	assert.Equal(t, "exception_owner_invalid", toSnakeCase("exception.ownerInvalid"))
+	assert.Equal(t, "partition_id", toSnakeCase("partitionID"))
+	assert.Equal(t, "http_server", toSnakeCase("HTTPServer"))
+}
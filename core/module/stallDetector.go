@@ -0,0 +1,120 @@
+package module
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/harbinzhang/goRainbow/core/protocol"
+)
+
+// ConsumptionState is how a consumer group consumes a partition, whatever Burrow status is.
+type ConsumptionState string
+
+const (
+	// ConsumptionIdle is a caught up partition without new data.
+	ConsumptionIdle ConsumptionState = "idle"
+	// ConsumptionActive is a partition whose committed offset moves, or is caught up with new data.
+	ConsumptionActive ConsumptionState = "active"
+	// ConsumptionStalled is a partition with lag whose committed offset doesn't move for Window.
+	ConsumptionStalled ConsumptionState = "stalled"
+	// ConsumptionRewinding is a partition whose committed offset goes backwards.
+	ConsumptionRewinding ConsumptionState = "rewinding"
+	// ConsumptionUnknown is a partition without committed offset, it's not one of ConsumptionStates.
+	ConsumptionUnknown ConsumptionState = ""
+)
+
+// ConsumptionStates are all states, in order of severity.
+var ConsumptionStates = []ConsumptionState{ConsumptionIdle, ConsumptionActive, ConsumptionStalled, ConsumptionRewinding}
+
+// Code is the metric value of state, a more severe state has a bigger code.
+func (cs ConsumptionState) Code() int {
+	for i, state := range ConsumptionStates {
+		if state == cs {
+			return i
+		}
+	}
+	return -1
+}
+
+// StallDetector classifies partitions of a consumer group by their committed offsets across polls.
+// Lag is head offset from OffsetHistory minus committed offset, or Burrow current lag without head offset.
+// It's not thread safe, it should be called in order of polls.
+type StallDetector struct {
+	// Window is how long a partition with lag can keep its committed offset, 5 minutes by default.
+	Window        time.Duration
+	OffsetHistory *OffsetHistory
+
+	partitions map[string]*commitState
+}
+
+type commitState struct {
+	offset int
+	// moved is when offset changed last time.
+	moved int64
+}
+
+// Init is a general init
+func (sd *StallDetector) Init() {
+	if sd.Window == 0 {
+		sd.Window = 5 * time.Minute
+	}
+	sd.partitions = make(map[string]*commitState)
+}
+
+// Classify returns state of each partition, and state of the consumer group, which is the most severe one.
+// A partition seen for the first time has its committed offset moved at timestamp.
+// A partition without committed offset is ConsumptionUnknown, and doesn't affect the consumer group.
+func (sd *StallDetector) Classify(cluster string, partitions []protocol.Partition, timestamp int64) ([]ConsumptionState, ConsumptionState) {
+	states := make([]ConsumptionState, len(partitions))
+	group := ConsumptionIdle
+	seen := make(map[string]bool, len(partitions))
+	for i, partition := range partitions {
+		if !partition.HasCommittedOffset() {
+			states[i] = ConsumptionUnknown
+			continue
+		}
+		key := partition.Topic + ":" + strconv.Itoa(partition.Partition)
+		seen[key] = true
+		offset := partition.End.Offset
+
+		lag := partition.CurrentLag
+		if head, ok := sd.OffsetHistory.Head(cluster, partition.Topic, partition.Partition); ok && head >= offset {
+			lag = head - offset
+		}
+
+		commit, ok := sd.partitions[key]
+		switch {
+		case !ok:
+			sd.partitions[key] = &commitState{offset: offset, moved: timestamp}
+			states[i] = ConsumptionActive
+		case offset < commit.offset:
+			states[i] = ConsumptionRewinding
+		case offset > commit.offset:
+			states[i] = ConsumptionActive
+		case lag > 0 && timestamp-commit.moved >= int64(sd.Window/time.Second):
+			states[i] = ConsumptionStalled
+		case lag > 0:
+			// not moved in Window yet.
+			states[i] = ConsumptionActive
+		case timestamp-commit.moved >= int64(sd.Window/time.Second):
+			states[i] = ConsumptionIdle
+		default:
+			states[i] = ConsumptionActive
+		}
+		if ok && offset != commit.offset {
+			commit.offset = offset
+			commit.moved = timestamp
+		}
+
+		if states[i].Code() > group.Code() {
+			group = states[i]
+		}
+	}
+	// partitions not consumed any longer are forgotten.
+	for key := range sd.partitions {
+		if !seen[key] {
+			delete(sd.partitions, key)
+		}
+	}
+	return states, group
+}
@@ -0,0 +1,52 @@
+package module
+
+import (
+	"testing"
+	"time"
+
+	"github.com/harbinzhang/goRainbow/core/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStallDetector(t *testing.T) {
+	oh := &OffsetHistory{}
+	oh.Init()
+	sd := &StallDetector{Window: time.Minute, OffsetHistory: oh}
+	sd.Init()
+
+	partition := func(id int, offset int, lag int) protocol.Partition {
+		p := protocol.Partition{Topic: "topic", Partition: id, CurrentLag: lag}
+		p.End.Offset = offset
+		return p
+	}
+
+	states, group := sd.Classify("test", []protocol.Partition{partition(0, 100, 0), partition(1, 100, 10)}, 1000)
+	assert.Equal(t, []ConsumptionState{ConsumptionActive, ConsumptionActive}, states, "first poll")
+	assert.Equal(t, ConsumptionActive, group)
+
+	// partition 0 is caught up, partition 1 keeps lag without commits.
+	states, _ = sd.Classify("test", []protocol.Partition{partition(0, 100, 0), partition(1, 100, 10)}, 1030)
+	assert.Equal(t, []ConsumptionState{ConsumptionActive, ConsumptionActive}, states, "not in window yet")
+	states, group = sd.Classify("test", []protocol.Partition{partition(0, 100, 0), partition(1, 100, 10)}, 1060)
+	assert.Equal(t, []ConsumptionState{ConsumptionIdle, ConsumptionStalled}, states)
+	assert.Equal(t, ConsumptionStalled, group)
+
+	// Burrow says caught up, but head offset from topic handler shows new data.
+	oh.Record("test", "topic", 0, 150, 1080)
+	states, _ = sd.Classify("test", []protocol.Partition{partition(0, 100, 0), partition(1, 120, 10)}, 1090)
+	assert.Equal(t, []ConsumptionState{ConsumptionStalled, ConsumptionActive}, states)
+
+	states, group = sd.Classify("test", []protocol.Partition{partition(0, 150, 0), partition(1, 50, 80)}, 1120)
+	assert.Equal(t, []ConsumptionState{ConsumptionActive, ConsumptionRewinding}, states)
+	assert.Equal(t, ConsumptionRewinding, group)
+	assert.Equal(t, 3, group.Code())
+
+	// no commit data, e.g. pushed lags, is never stalled whatever the head offset is.
+	noCommit := protocol.Partition{Topic: "topic", Partition: 2, CurrentLag: 10}
+	oh.Record("test", "topic", 2, 500, 1120)
+	for _, timestamp := range []int64{1120, 1200, 1300} {
+		states, group = sd.Classify("test", []protocol.Partition{partition(0, 150, 0), noCommit}, timestamp)
+		assert.Equal(t, ConsumptionUnknown, states[1])
+		assert.NotEqual(t, ConsumptionStalled, group)
+	}
+}
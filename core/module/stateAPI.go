@@ -0,0 +1,180 @@
+package module
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StateAPIPrefix is the path prefix of REST API on health_check server.
+const StateAPIPrefix = "/api/v1/"
+
+// StateAPI is for REST API of the live lag snapshot in StateStore:
+// GET /api/v1/clusters
+// GET /api/v1/clusters/{cluster}
+// GET /api/v1/clusters/{cluster}/consumers
+// GET /api/v1/clusters/{cluster}/consumers/{group}
+// GET /api/v1/clusters/{cluster}/topics
+// GET /api/v1/clusters/{cluster}/topics/{topic}
+// and lag trends in LagHistory, history is optional:
+// GET /api/v1/clusters/{cluster}/consumers/{group}/history?start=&end=&step=&aggregate=
+// GET /api/v1/clusters/{cluster}/topics/{topic}/history?start=&end=&step=&aggregate=
+func StateAPI(ss *StateStore, history *LagHistory) func(http.ResponseWriter, *http.Request) {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSONError(w, http.StatusMethodNotAllowed, "only GET is allowed")
+			return
+		}
+
+		parts, err := splitPath(r.URL.EscapedPath())
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if parts[0] != "clusters" {
+			writeJSONError(w, http.StatusNotFound, "unknown path "+r.URL.Path)
+			return
+		}
+
+		switch len(parts) {
+		case 1:
+			writeJSON(w, map[string]interface{}{"clusters": ss.Clusters()})
+		case 2:
+			consumers, ok := ss.Consumers(parts[1])
+			if !ok {
+				writeJSONError(w, http.StatusNotFound, "cluster "+parts[1]+" not found")
+				return
+			}
+			topics, _ := ss.Topics(parts[1])
+			writeJSON(w, map[string]interface{}{"cluster": parts[1], "consumers": consumers, "topics": topics})
+		case 3:
+			var names []string
+			var ok bool
+			switch parts[2] {
+			case "consumers":
+				names, ok = ss.Consumers(parts[1])
+			case "topics":
+				names, ok = ss.Topics(parts[1])
+			default:
+				writeJSONError(w, http.StatusNotFound, "unknown path "+r.URL.Path)
+				return
+			}
+			if !ok {
+				writeJSONError(w, http.StatusNotFound, "cluster "+parts[1]+" not found")
+				return
+			}
+			writeJSON(w, map[string]interface{}{"cluster": parts[1], parts[2]: names})
+		case 4:
+			switch parts[2] {
+			case "consumers":
+				state, ok := ss.Consumer(parts[1], parts[3])
+				if !ok {
+					writeJSONError(w, http.StatusNotFound, "consumer "+parts[3]+" not found in cluster "+parts[1])
+					return
+				}
+				writeJSON(w, state)
+			case "topics":
+				state, ok := ss.Topic(parts[1], parts[3])
+				if !ok {
+					writeJSONError(w, http.StatusNotFound, "topic "+parts[3]+" not found in cluster "+parts[1])
+					return
+				}
+				writeJSON(w, state)
+			default:
+				writeJSONError(w, http.StatusNotFound, "unknown path "+r.URL.Path)
+			}
+		case 5:
+			if parts[4] != "history" || history == nil {
+				writeJSONError(w, http.StatusNotFound, "unknown path "+r.URL.Path)
+				return
+			}
+			start, end, step, aggregate, err := parseRange(r)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			var series []Series
+			switch parts[2] {
+			case "consumers":
+				series = history.Consumer(parts[1], parts[3], start, end, step, aggregate)
+			case "topics":
+				series = history.Topic(parts[1], parts[3], start, end, step, aggregate)
+			default:
+				writeJSONError(w, http.StatusNotFound, "unknown path "+r.URL.Path)
+				return
+			}
+			if len(series) == 0 {
+				writeJSONError(w, http.StatusNotFound, "no history of "+parts[3]+" in cluster "+parts[1])
+				return
+			}
+			writeJSON(w, map[string]interface{}{"start": start, "end": end, "step": step, "series": series})
+		default:
+			writeJSONError(w, http.StatusNotFound, "unknown path "+r.URL.Path)
+		}
+	}
+}
+
+// splitPath splits escaped path after StateAPIPrefix into unescaped segments,
+// so a name with "/", escaped as "%2F", is one segment.
+func splitPath(escapedPath string) ([]string, error) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(escapedPath, StateAPIPrefix), "/"), "/")
+	for i, part := range parts {
+		unescaped, err := url.PathUnescape(part)
+		if err != nil {
+			return nil, err
+		}
+		parts[i] = unescaped
+	}
+	return parts, nil
+}
+
+// parseRange parses query of history, end is now and start is an hour before end by default,
+// step 0 means raw points.
+func parseRange(r *http.Request) (start int64, end int64, step int64, aggregate Aggregate, err error) {
+	query := r.URL.Query()
+	end = time.Now().Unix()
+	if value := query.Get("end"); value != "" {
+		if end, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return 0, 0, 0, "", errors.New("end should be a unix timestamp")
+		}
+	}
+	start = end - 3600
+	if value := query.Get("start"); value != "" {
+		if start, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return 0, 0, 0, "", errors.New("start should be a unix timestamp")
+		}
+	}
+	if start > end {
+		return 0, 0, 0, "", errors.New("start should not be after end")
+	}
+	if value := query.Get("step"); value != "" {
+		if step, err = strconv.ParseInt(value, 10, 64); err != nil || step < 0 {
+			return 0, 0, 0, "", errors.New("step should be seconds, 0 for raw points")
+		}
+	}
+	aggregate = Aggregate(query.Get("aggregate"))
+	switch aggregate {
+	case "":
+		aggregate = AggregateAvg
+	case AggregateAvg, AggregateMax, AggregateLast:
+	default:
+		return 0, 0, 0, "", errors.New("aggregate should be avg, max or last")
+	}
+	return start, end, step, aggregate, nil
+}
+
+func writeJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
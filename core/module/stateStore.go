@@ -0,0 +1,318 @@
+package module
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/harbinzhang/goRainbow/core/protocol"
+	"github.com/harbinzhang/goRainbow/core/util"
+)
+
+// StateStore keeps the latest LagInfo of every consumer and the latest offsets of every topic,
+// it's updated by translators and topic handlers and read by REST API.
+// Usage:
+// store.Init()
+// store.UpdateConsumer(lagInfo, rates)
+// state, ok := store.Consumer(cluster, group)
+type StateStore struct {
+	sync.RWMutex
+
+	// OffsetHistory is for lag in seconds of partitions, optional.
+	OffsetHistory *OffsetHistory
+
+	clusters map[string]*clusterState
+}
+
+type clusterState struct {
+	consumers map[string]*consumerEntry
+	topics    map[string]*topicEntry
+}
+
+type consumerEntry struct {
+	lagInfo protocol.LagInfo
+	rates   *util.RateCalculator
+}
+
+type topicEntry struct {
+	offsets   []int
+	timestamp int64
+	rates     *util.RateCalculator
+}
+
+// ConsumerState is the latest state of a consumer group.
+type ConsumerState struct {
+	Cluster   string          `json:"cluster"`
+	Group     string          `json:"group"`
+	Status    string          `json:"status"`
+	TotalLag  int             `json:"totalLag"`
+	Timestamp int64           `json:"timestamp"`
+	MaxLag    protocol.MaxLag `json:"maxLag"`
+	// ETASeconds is seconds until lag gets 0, -1 means never, absent when any partition ETA is unknown.
+	ETASeconds *int64           `json:"etaSeconds,omitempty"`
+	Partitions []PartitionState `json:"partitions"`
+	// Rates are offset rates per owner and partition.
+	Rates []RateState `json:"rates"`
+}
+
+// PartitionState is the latest state of a partition consumed by a consumer group.
+type PartitionState struct {
+	Topic       string `json:"topic"`
+	Partition   int    `json:"partition"`
+	Owner       string `json:"owner"`
+	Status      string `json:"status"`
+	CurrentLag  int    `json:"currentLag"`
+	StartOffset int    `json:"startOffset"`
+	EndOffset   int    `json:"endOffset"`
+	// TimeLagSeconds is absent when there is no head offset history of the partition.
+	TimeLagSeconds *int64 `json:"timeLagSeconds,omitempty"`
+	// ETASeconds is seconds until lag gets 0, -1 means never, absent without consume or produce rate.
+	ETASeconds *int64 `json:"etaSeconds,omitempty"`
+	// OffsetsUnknown is true if StartOffset and EndOffset are not known, e.g. pushed by Slack attachments.
+	OffsetsUnknown bool `json:"offsetsUnknown,omitempty"`
+}
+
+// TopicState is the latest state of a topic.
+type TopicState struct {
+	Cluster   string      `json:"cluster"`
+	Topic     string      `json:"topic"`
+	Timestamp int64       `json:"timestamp"`
+	Offsets   []int       `json:"offsets"`
+	Rates     []RateState `json:"rates"`
+}
+
+// RateState is offset rate of a partition, Owner is the consumer host, or the topic for topic rates.
+type RateState struct {
+	Owner string `json:"owner"`
+	// Topic is the topic consumed by Owner, absent for topic rates.
+	Topic     string  `json:"topic,omitempty"`
+	Partition string  `json:"partition"`
+	PerSecond float64 `json:"perSecond"`
+	PerMinute float64 `json:"perMinute"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+// Init is a general init
+func (ss *StateStore) Init() {
+	ss.clusters = make(map[string]*clusterState)
+}
+
+// getCluster should be called with lock held.
+func (ss *StateStore) getCluster(cluster string) *clusterState {
+	state, ok := ss.clusters[cluster]
+	if !ok {
+		state = &clusterState{
+			consumers: make(map[string]*consumerEntry),
+			topics:    make(map[string]*topicEntry),
+		}
+		ss.clusters[cluster] = state
+	}
+	return state
+}
+
+// UpdateConsumer saves the latest LagInfo of a consumer, rates are read when the state is queried.
+func (ss *StateStore) UpdateConsumer(lagInfo protocol.LagInfo, rates *util.RateCalculator) {
+	if ss == nil {
+		return
+	}
+	ss.Lock()
+	defer ss.Unlock()
+	ss.getCluster(lagInfo.Lag.Status.Cluster).consumers[lagInfo.Lag.Status.Group] = &consumerEntry{
+		lagInfo: lagInfo,
+		rates:   rates,
+	}
+}
+
+// UpdateTopic saves the latest offsets of a topic, rates are read when the state is queried.
+func (ss *StateStore) UpdateTopic(cluster string, topic string, offsets []int, timestamp int64, rates *util.RateCalculator) {
+	if ss == nil {
+		return
+	}
+	ss.Lock()
+	defer ss.Unlock()
+	ss.getCluster(cluster).topics[topic] = &topicEntry{
+		offsets:   offsets,
+		timestamp: timestamp,
+		rates:     rates,
+	}
+}
+
+// RemoveConsumer removes a consumer gone from Burrow.
+func (ss *StateStore) RemoveConsumer(cluster string, group string) {
+	if ss == nil {
+		return
+	}
+	ss.Lock()
+	defer ss.Unlock()
+	if state, ok := ss.clusters[cluster]; ok {
+		delete(state.consumers, group)
+		ss.removeEmpty(cluster)
+	}
+}
+
+// RemoveTopic removes a topic gone from Burrow.
+func (ss *StateStore) RemoveTopic(cluster string, topic string) {
+	if ss == nil {
+		return
+	}
+	ss.Lock()
+	defer ss.Unlock()
+	if state, ok := ss.clusters[cluster]; ok {
+		delete(state.topics, topic)
+		ss.removeEmpty(cluster)
+	}
+}
+
+func (ss *StateStore) removeEmpty(cluster string) {
+	if state := ss.clusters[cluster]; len(state.consumers) == 0 && len(state.topics) == 0 {
+		delete(ss.clusters, cluster)
+	}
+}
+
+// Clusters returns sorted names of clusters.
+func (ss *StateStore) Clusters() []string {
+	ss.RLock()
+	defer ss.RUnlock()
+	names := make([]string, 0, len(ss.clusters))
+	for name := range ss.clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Consumers returns sorted names of consumers in cluster, false if cluster is unknown.
+func (ss *StateStore) Consumers(cluster string) ([]string, bool) {
+	ss.RLock()
+	defer ss.RUnlock()
+	state, ok := ss.clusters[cluster]
+	if !ok {
+		return nil, false
+	}
+	names := make([]string, 0, len(state.consumers))
+	for name := range state.consumers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, true
+}
+
+// Topics returns sorted names of topics in cluster, false if cluster is unknown.
+func (ss *StateStore) Topics(cluster string) ([]string, bool) {
+	ss.RLock()
+	defer ss.RUnlock()
+	state, ok := ss.clusters[cluster]
+	if !ok {
+		return nil, false
+	}
+	names := make([]string, 0, len(state.topics))
+	for name := range state.topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, true
+}
+
+// Consumer returns the latest state of a consumer group.
+func (ss *StateStore) Consumer(cluster string, group string) (ConsumerState, bool) {
+	ss.RLock()
+	state, ok := ss.clusters[cluster]
+	var entry *consumerEntry
+	if ok {
+		entry, ok = state.consumers[group]
+	}
+	ss.RUnlock()
+	if !ok {
+		return ConsumerState{}, false
+	}
+
+	status := entry.lagInfo.Lag.Status
+	res := ConsumerState{
+		Cluster:    cluster,
+		Group:      group,
+		Status:     status.Status,
+		TotalLag:   status.Totallag,
+		Timestamp:  entry.lagInfo.Timestamp,
+		MaxLag:     status.Maxlag,
+		Partitions: make([]PartitionState, 0, len(status.Partitions)),
+		Rates:      rateStates(entry.rates),
+	}
+	rates := RatesByKey(entry.rates)
+	etas := make([]int64, 0, len(status.Partitions))
+	for _, partition := range status.Partitions {
+		partitionState := PartitionState{
+			Topic:       partition.Topic,
+			Partition:   partition.Partition,
+			Owner:       partition.Owner,
+			Status:      partition.Status,
+			CurrentLag:  partition.CurrentLag,
+			StartOffset: partition.Start.Offset,
+			EndOffset:   partition.End.Offset,
+		}
+		if !partition.HasCommittedOffset() {
+			partitionState.OffsetsUnknown = true
+		} else if partition.CurrentLag == 0 {
+			zero := int64(0)
+			partitionState.TimeLagSeconds = &zero
+		} else if seconds, ok := ss.OffsetHistory.SecondsBehind(cluster, partition.Topic, partition.Partition,
+			partition.End.Offset, entry.lagInfo.Timestamp); ok {
+			partitionState.TimeLagSeconds = &seconds
+		}
+		if eta, ok := PartitionETA(cluster, partition, rates, ss.OffsetHistory); ok {
+			partitionState.ETASeconds = &eta
+			etas = append(etas, eta)
+		}
+		res.Partitions = append(res.Partitions, partitionState)
+	}
+	if len(etas) == len(status.Partitions) && len(etas) > 0 {
+		eta := GroupETA(etas)
+		res.ETASeconds = &eta
+	}
+	return res, true
+}
+
+// Topic returns the latest state of a topic.
+func (ss *StateStore) Topic(cluster string, topic string) (TopicState, bool) {
+	ss.RLock()
+	state, ok := ss.clusters[cluster]
+	var entry *topicEntry
+	if ok {
+		entry, ok = state.topics[topic]
+	}
+	ss.RUnlock()
+	if !ok {
+		return TopicState{}, false
+	}
+	return TopicState{
+		Cluster:   cluster,
+		Topic:     topic,
+		Timestamp: entry.timestamp,
+		Offsets:   entry.offsets,
+		Rates:     rateStates(entry.rates),
+	}, true
+}
+
+// rateStates translates rates keyed by "owner:partition".
+func rateStates(rates *util.RateCalculator) []RateState {
+	res := []RateState{}
+	if rates == nil {
+		return res
+	}
+	for _, rate := range rates.Current() {
+		if !rate.Valid {
+			continue
+		}
+		owner, topic, partition, ok := parseRateKey(rate.Key)
+		if !ok {
+			continue
+		}
+		res = append(res, RateState{
+			Owner:     owner,
+			Topic:     topic,
+			Partition: partition,
+			PerSecond: rate.PerSecond,
+			PerMinute: rate.PerMinute,
+			Timestamp: rate.Timestamp,
+		})
+	}
+	return res
+}
@@ -0,0 +1,131 @@
+package module
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/harbinzhang/goRainbow/core/protocol"
+	"github.com/harbinzhang/goRainbow/core/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func prepareStateStore() *StateStore {
+	ss := &StateStore{OffsetHistory: prepareOffsetHistory()}
+	ss.Init()
+
+	lagInfo := protocol.LagInfo{Timestamp: 230}
+	lagInfo.Lag.Status.Cluster = "test"
+	lagInfo.Lag.Status.Group = "group"
+	lagInfo.Lag.Status.Status = "WARN"
+	lagInfo.Lag.Status.Totallag = 900
+	partition := protocol.Partition{Topic: "topic", Partition: 0, Owner: "host", CurrentLag: 900}
+	partition.End.Offset = 1299
+	lagInfo.Lag.Status.Partitions = []protocol.Partition{partition}
+
+	rates := &util.RateCalculator{}
+	rates.Init()
+	rates.Update(RateKey("host", "topic", 0), 999, 200)
+	rates.Update(RateKey("host", "topic", 0), 1299, 230)
+	ss.UpdateConsumer(lagInfo, rates)
+
+	ss.UpdateTopic("test", "topic", []int{2200}, 220, nil)
+	return ss
+}
+
+func TestStateStore(t *testing.T) {
+	ss := prepareStateStore()
+
+	assert.Equal(t, []string{"test"}, ss.Clusters())
+	topics, ok := ss.Topics("test")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"topic"}, topics)
+
+	state, ok := ss.Consumer("test", "group")
+	assert.True(t, ok)
+	assert.Equal(t, 900, state.TotalLag)
+	assert.Equal(t, "host", state.Partitions[0].Owner)
+	assert.Equal(t, int64(100), *state.Partitions[0].TimeLagSeconds, "message 1299 is produced at 130")
+	assert.Equal(t, int64(ETANever), *state.ETASeconds, "consumed as fast as produced")
+	assert.Equal(t, []RateState{{Owner: "host", Topic: "topic", Partition: "0", PerSecond: 10, PerMinute: 600, Timestamp: 230}}, state.Rates)
+	// rates are read again, pending resets are not cleared by queries.
+	state, _ = ss.Consumer("test", "group")
+	assert.Equal(t, 1, len(state.Rates))
+
+	ss.RemoveConsumer("test", "group")
+	_, ok = ss.Consumer("test", "group")
+	assert.False(t, ok)
+	ss.RemoveTopic("test", "topic")
+	assert.Equal(t, []string{}, ss.Clusters(), "empty cluster is removed")
+
+	var nilStore *StateStore
+	nilStore.UpdateTopic("test", "topic", nil, 0, nil)
+}
+
+func TestStateAPI(t *testing.T) {
+	history := &LagHistory{}
+	history.Init()
+	defer history.Stop()
+	history.RecordTotalLag("test", "group", 900, 230)
+	history.RecordTotalLag("test", "group", 600, 260)
+	history.RecordTotalLag("test", "team/group", 10, 260)
+	ss := prepareStateStore()
+	lagInfo := protocol.LagInfo{Timestamp: 230}
+	lagInfo.Lag.Status.Cluster = "test"
+	lagInfo.Lag.Status.Group = "team/group"
+	lagInfo.Lag.Status.Totallag = 10
+	ss.UpdateConsumer(lagInfo, nil)
+	server := httptest.NewServer(http.HandlerFunc(StateAPI(ss, history)))
+	defer server.Close()
+
+	get := func(path string) (int, map[string]interface{}) {
+		resp, err := http.Get(server.URL + path)
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		body := make(map[string]interface{})
+		assert.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+		return resp.StatusCode, body
+	}
+
+	status, body := get("/api/v1/clusters")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []interface{}{"test"}, body["clusters"])
+
+	_, body = get("/api/v1/clusters/test/")
+	assert.Equal(t, []interface{}{"group", "team/group"}, body["consumers"])
+	assert.Equal(t, []interface{}{"topic"}, body["topics"])
+
+	_, body = get("/api/v1/clusters/test/consumers/group")
+	assert.Equal(t, float64(900), body["totalLag"])
+	assert.Equal(t, "WARN", body["status"])
+
+	status, body = get("/api/v1/clusters/test/consumers/team%2Fgroup")
+	assert.Equal(t, http.StatusOK, status, "escaped / should be in the name")
+	assert.Equal(t, float64(10), body["totalLag"])
+	status, _ = get("/api/v1/clusters/test/consumers/team%2Fgroup/history?start=200&end=300")
+	assert.Equal(t, http.StatusOK, status)
+
+	_, body = get("/api/v1/clusters/test/topics/topic")
+	assert.Equal(t, []interface{}{float64(2200)}, body["offsets"])
+
+	_, body = get("/api/v1/clusters/test/consumers/group/history?start=200&end=300&step=100&aggregate=max")
+	series := body["series"].([]interface{})
+	assert.Equal(t, 1, len(series))
+	assert.Equal(t, []interface{}{map[string]interface{}{"timestamp": float64(200), "value": float64(900)}},
+		series[0].(map[string]interface{})["points"])
+
+	status, _ = get("/api/v1/clusters/test/topics/topic/history?start=200&end=300")
+	assert.Equal(t, http.StatusNotFound, status, "no topic history")
+	status, _ = get("/api/v1/clusters/test/consumers/group/history?start=300&end=200")
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, body = get("/api/v1/clusters/test/consumers/unknown")
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Contains(t, body["error"], "unknown")
+
+	status, _ = get("/api/v1/clusters/unknown/topics")
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = get("/api/v1/nothing")
+	assert.Equal(t, http.StatusNotFound, status)
+}
@@ -1,59 +1,106 @@
 package pipeline
 
 import (
+	"context"
 	"fmt"
 	"regexp"
 	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/harbinzhang/goRainbow/core/alert"
+	"github.com/harbinzhang/goRainbow/core/burrow"
 	"github.com/harbinzhang/goRainbow/core/module"
+	"github.com/harbinzhang/goRainbow/core/protocol"
 	"github.com/harbinzhang/goRainbow/core/util"
 )
 
 // AliveConsumersMaintainer is a maintainer for alive consumers
 // It checks Burrow periodically to see if there is a new consumer, then creates a new thread for this consumer.
 type AliveConsumersMaintainer struct {
-	BurrowURL    string
-	ProduceQueue chan string
-	CountService *module.CountService
-	Logger       *zap.Logger
+	Burrow            *burrow.Client
+	PollInterval      time.Duration
+	DiscoveryInterval time.Duration
+	RetryInterval     time.Duration
+	StallTimeout      time.Duration
+	ProduceQueue      chan protocol.Metric
+	CountService      *module.CountService
+	OffsetHistory     *module.OffsetHistory
+	Emission          EmissionPolicy
+	StallWindow       time.Duration
+	State             *module.StateStore
+	History           *module.LagHistory
+	Alerts            *alert.Engine
+	Logger            *zap.Logger
 
 	clusterConsumerMap *util.SyncNestedMap
+	supervisor         *Supervisor
+	doneChannel        chan struct{}
 }
 
-// Start is a general start
-func (acm *AliveConsumersMaintainer) Start() {
-	defer acm.Logger.Sync()
-
+// Init is a general init
+func (acm *AliveConsumersMaintainer) Init() {
 	acm.clusterConsumerMap = &util.SyncNestedMap{}
 	acm.clusterConsumerMap.Init()
+	acm.doneChannel = make(chan struct{})
+
+	// a handler without a completed poll in StallTimeout(5 PollInterval by default) is restarted.
+	if acm.StallTimeout == 0 {
+		acm.StallTimeout = 5 * acm.PollInterval
+	}
+	acm.supervisor = &Supervisor{
+		Kind:         "consumer",
+		StallTimeout: acm.StallTimeout,
+		CountService: acm.CountService,
+		Logger:       acm.Logger.With(zap.String("supervisor", "consumer")),
+	}
+	acm.supervisor.Init()
+}
+
+// Start is a general start, it keeps discovering consumers until ctx is cancelled.
+// ctx is passed to all consumer handlers.
+func (acm *AliveConsumersMaintainer) Start(ctx context.Context) {
+	defer acm.Logger.Sync()
+	defer close(acm.doneChannel)
+
+	go acm.supervisor.Start(ctx)
 
 	contextProvider := util.ContextProvider{}
 	contextProvider.Init()
 	blacklist := contextProvider.GetBlacklist()
 
 	for {
-		clusters, clusterLink := getClusters(acm.BurrowURL)
-		if clusters == nil {
+		clusters, err := acm.Burrow.Clusters(ctx)
+		if err != nil {
 			// Burrow server is not ready
-			acm.Logger.Info("Burrow server not ready.")
-			time.Sleep(1 * time.Minute)
+			acm.Logger.Info("Burrow server not ready.",
+				zap.String("error", err.Error()),
+			)
+			if !sleepWithContext(ctx, acm.RetryInterval) {
+				return
+			}
 			continue
 		}
-		for _, cluster := range clusters.([]interface{}) {
-			clusterString := cluster.(string)
+		for _, clusterString := range clusters {
 			fmt.Println(clusterString)
+			consumers, err := acm.Burrow.Consumers(ctx, clusterString)
+			if err != nil {
+				// keep handlers of this cluster, try again in next discovery.
+				acm.Logger.Warn("Get consumers error",
+					zap.String("cluster", clusterString),
+					zap.String("error", err.Error()),
+					zap.Int64("timestamp", time.Now().Unix()),
+				)
+				continue
+			}
+			fmt.Println(clusterString, consumers)
+
 			consumersSet := acm.clusterConsumerMap.GetChild(clusterString, make(map[string]interface{})).(map[string]interface{})
 
 			acm.clusterConsumerMap.SetLock(clusterString)
 
-			consumers, consumersLink := getConsumers(clusterLink, clusterString)
-			fmt.Println(consumers, consumersLink)
-
 			// create new consumer handler if it does not exist.
-			for _, consumer := range consumers.([]interface{}) {
-				consumerString := consumer.(string)
+			for _, consumerString := range consumers {
 				if _, ok := consumersSet[consumerString]; !ok {
 					// A new consumer found, need to: 1. create new thread 2. put it into map.
 					consumersSet[consumerString] = true
@@ -65,16 +112,28 @@ func (acm *AliveConsumersMaintainer) Start() {
 						)
 						continue
 					}
-					consumerHandler := &ConsumerHandler{
-						ProduceQueue:       acm.ProduceQueue,
-						CountService:       acm.CountService,
-						ClusterConsumerMap: acm.clusterConsumerMap,
-						Logger: util.GetLogger().With(
-							zap.String("module", "consumerHandler"),
-						),
-					}
-					consumerHandler.Init(consumersLink, consumerString, clusterString)
-					go consumerHandler.Start()
+					name, cluster := consumerString, clusterString
+					acm.supervisor.Go(ctx, name, cluster, func(heartbeat func()) supervisedHandler {
+						consumerHandler := &ConsumerHandler{
+							Burrow:             acm.Burrow,
+							PollInterval:       acm.PollInterval,
+							ProduceQueue:       acm.ProduceQueue,
+							OffsetHistory:      acm.OffsetHistory,
+							Emission:           acm.Emission,
+							StallWindow:        acm.StallWindow,
+							State:              acm.State,
+							History:            acm.History,
+							Alerts:             acm.Alerts,
+							CountService:       acm.CountService,
+							ClusterConsumerMap: acm.clusterConsumerMap,
+							Heartbeat:          heartbeat,
+							Logger: util.GetLogger().With(
+								zap.String("module", "consumerHandler"),
+							),
+						}
+						consumerHandler.Init(name, cluster)
+						return consumerHandler
+					})
 					acm.Logger.Info("create a new consumer handler",
 						zap.String("consumer", consumerString),
 						zap.String("cluster", clusterString),
@@ -82,14 +141,39 @@ func (acm *AliveConsumersMaintainer) Start() {
 				}
 			}
 
+			// remove handlers of consumers gone from Burrow.
+			for _, name := range pruneGone(consumersSet, consumers) {
+				acm.remove(name, clusterString)
+			}
+
 			acm.clusterConsumerMap.ReleaseLock(clusterString)
 		}
-		// AliveConsumerMaintainer refresh its alive Consumers list every 5 minutes.
-		time.Sleep(5 * time.Minute)
+		// remove handlers of clusters gone from Burrow.
+		for cluster, names := range pruneGoneClusters(acm.clusterConsumerMap, clusters) {
+			for _, name := range names {
+				acm.remove(name, cluster)
+			}
+		}
+		// AliveConsumerMaintainer refresh its alive Consumers list every DiscoveryInterval(5 minutes by default).
+		if !sleepWithContext(ctx, acm.DiscoveryInterval) {
+			return
+		}
 	}
 }
 
-// Stop is a general stop
+// remove stops handler of a consumer gone from Burrow.
+func (acm *AliveConsumersMaintainer) remove(consumer string, cluster string) {
+	acm.supervisor.Remove(consumer, cluster)
+	acm.CountService.Increase("consumerRemoved", cluster)
+	acm.Logger.Info("remove a consumer handler",
+		zap.String("consumer", consumer),
+		zap.String("cluster", cluster),
+		zap.Int64("timestamp", time.Now().Unix()),
+	)
+}
+
+// Stop waits until maintainer and all its consumer handlers exit, ctx of Start should be cancelled before.
 func (acm *AliveConsumersMaintainer) Stop() error {
-	return nil
+	<-acm.doneChannel
+	return acm.supervisor.Stop()
 }
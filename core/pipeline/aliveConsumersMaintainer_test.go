@@ -0,0 +1,93 @@
+package pipeline
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/harbinzhang/goRainbow/core/burrow"
+	"github.com/harbinzhang/goRainbow/core/module"
+	"github.com/harbinzhang/goRainbow/core/protocol"
+)
+
+func TestAliveConsumersMaintainerPrunes(t *testing.T) {
+	lag, _ := ioutil.ReadFile("../../config/pull_content.json")
+	// 0: test has a and b, 1: b is gone, 2: cluster test is gone
+	var stage int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/lag"):
+			w.Write(lag)
+		case strings.HasSuffix(r.URL.Path, "/consumer"):
+			if atomic.LoadInt32(&stage) == 0 {
+				w.Write([]byte(`{"consumers":["a","b"]}`))
+			} else {
+				w.Write([]byte(`{"consumers":["a"]}`))
+			}
+		default:
+			if atomic.LoadInt32(&stage) < 2 {
+				w.Write([]byte(`{"clusters":["test"]}`))
+			} else {
+				w.Write([]byte(`{"clusters":[]}`))
+			}
+		}
+	}))
+	defer server.Close()
+	client := &burrow.Client{BaseURL: server.URL}
+	client.Init()
+
+	produceQueue := make(chan protocol.Metric, 90000)
+	countService := &module.CountService{ProduceQueue: produceQueue}
+	countService.Start()
+
+	acm := &AliveConsumersMaintainer{
+		Burrow:            client,
+		PollInterval:      10 * time.Millisecond,
+		DiscoveryInterval: 20 * time.Millisecond,
+		RetryInterval:     20 * time.Millisecond,
+		ProduceQueue:      produceQueue,
+		CountService:      countService,
+		Logger:            zap.NewNop(),
+	}
+	acm.Init()
+	ctx, cancel := context.WithCancel(context.Background())
+	go acm.Start(ctx)
+
+	consumers := func() []string {
+		acm.clusterConsumerMap.SetLock("test")
+		defer acm.clusterConsumerMap.ReleaseLock("test")
+		var names []string
+		for name := range acm.clusterConsumerMap.GetChild("test", map[string]interface{}{}).(map[string]interface{}) {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	}
+	handlers := func() int {
+		acm.supervisor.mutex.Lock()
+		defer acm.supervisor.mutex.Unlock()
+		return len(acm.supervisor.children)
+	}
+
+	waitFor(t, func() bool { return handlers() == 2 }, "handlers of a and b should start")
+
+	atomic.StoreInt32(&stage, 1)
+	waitFor(t, func() bool { return handlers() == 1 }, "handler of b should be removed")
+	assert.Equal(t, []string{"a"}, consumers())
+
+	atomic.StoreInt32(&stage, 2)
+	waitFor(t, func() bool { return handlers() == 0 }, "handlers of cluster test should be removed")
+	assert.Equal(t, 0, len(consumers()))
+
+	cancel()
+	assert.Nil(t, acm.Stop())
+}
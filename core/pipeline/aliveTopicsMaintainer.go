@@ -1,86 +1,155 @@
 package pipeline
 
 import (
+	"context"
 	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/harbinzhang/goRainbow/core/burrow"
 	"github.com/harbinzhang/goRainbow/core/module"
+	"github.com/harbinzhang/goRainbow/core/protocol"
 	"github.com/harbinzhang/goRainbow/core/util"
 )
 
 // AliveTopicsMaintainer is a maintainer for alive topics
 // It checks Burrow periodically to see if there is a new topic, then creates a new thread for this topic.
 type AliveTopicsMaintainer struct {
-	BurrowURL    string
-	ProduceQueue chan string
-	CountService *module.CountService
-	Logger       *zap.Logger
+	Burrow            *burrow.Client
+	PollInterval      time.Duration
+	DiscoveryInterval time.Duration
+	RetryInterval     time.Duration
+	StallTimeout      time.Duration
+	ProduceQueue      chan protocol.Metric
+	CountService      *module.CountService
+	OffsetHistory     *module.OffsetHistory
+	State             *module.StateStore
+	History           *module.LagHistory
+	Logger            *zap.Logger
 
 	clusterTopicMap *util.SyncNestedMap
+	supervisor      *Supervisor
+	doneChannel     chan struct{}
 }
 
-// Start is a general start
-func (atm *AliveTopicsMaintainer) Start() {
-	defer atm.Logger.Sync()
-
-	contextProvider := util.ContextProvider{}
-	contextProvider.Init()
-	postfix := contextProvider.GetPostfix()
-
+// Init is a general init
+func (atm *AliveTopicsMaintainer) Init() {
 	atm.clusterTopicMap = &util.SyncNestedMap{}
 	atm.clusterTopicMap.Init()
+	atm.doneChannel = make(chan struct{})
+
+	// a handler without a completed poll in StallTimeout(5 PollInterval by default) is restarted.
+	if atm.StallTimeout == 0 {
+		atm.StallTimeout = 5 * atm.PollInterval
+	}
+	atm.supervisor = &Supervisor{
+		Kind:         "topic",
+		StallTimeout: atm.StallTimeout,
+		CountService: atm.CountService,
+		Logger:       atm.Logger.With(zap.String("supervisor", "topic")),
+	}
+	atm.supervisor.Init()
+}
+
+// Start is a general start, it keeps discovering topics until ctx is cancelled.
+// ctx is passed to all topic handlers.
+func (atm *AliveTopicsMaintainer) Start(ctx context.Context) {
+	defer atm.Logger.Sync()
+	defer close(atm.doneChannel)
+
+	go atm.supervisor.Start(ctx)
 
 	for {
-		clusters, clusterLink := getClusters(atm.BurrowURL)
-		if clusters == nil {
+		clusters, err := atm.Burrow.Clusters(ctx)
+		if err != nil {
 			// Burrow server is not ready
-			atm.Logger.Info("Burrow server not ready")
-			time.Sleep(1 * time.Minute)
+			atm.Logger.Info("Burrow server not ready",
+				zap.String("error", err.Error()),
+			)
+			if !sleepWithContext(ctx, atm.RetryInterval) {
+				return
+			}
 			continue
 		}
-		for _, cluster := range clusters.([]interface{}) {
-			clusterString := cluster.(string)
+		for _, clusterString := range clusters {
+			topics, err := atm.Burrow.Topics(ctx, clusterString)
+			if err != nil {
+				// keep handlers of this cluster, try again in next discovery.
+				atm.Logger.Warn("Get topics error",
+					zap.String("cluster", clusterString),
+					zap.String("error", err.Error()),
+					zap.Int64("timestamp", time.Now().Unix()),
+				)
+				continue
+			}
+
 			topicsSet := atm.clusterTopicMap.GetChild(clusterString, make(map[string]interface{})).(map[string]interface{})
 
 			atm.clusterTopicMap.SetLock(clusterString)
 
-			topics, topicsLink := getTopics(clusterLink, clusterString)
-
 			// create new go routine if consumer not exists.
-			for _, topic := range topics.([]interface{}) {
-				topicString := topic.(string)
+			for _, topicString := range topics {
 				if _, ok := topicsSet[topicString]; !ok {
 					// A new consumer found, need to 1. create new thread 2. put it into map.
 					topicsSet[topicString] = true
-					topicHandler := &TopicHandler{
-						ProduceQueue:    atm.ProduceQueue,
-						ClusterTopicMap: atm.clusterTopicMap,
-						CountService:    atm.CountService,
-						Logger: util.GetLogger().With(
-							zap.String("module", "topicHandler"),
-						),
-					}
-					topicHandler.Init(topicsLink, topicString, clusterString, postfix)
-					go topicHandler.Start()
+					name, cluster := topicString, clusterString
+					atm.supervisor.Go(ctx, name, cluster, func(heartbeat func()) supervisedHandler {
+						topicHandler := &TopicHandler{
+							Burrow:          atm.Burrow,
+							PollInterval:    atm.PollInterval,
+							ProduceQueue:    atm.ProduceQueue,
+							ClusterTopicMap: atm.clusterTopicMap,
+							OffsetHistory:   atm.OffsetHistory,
+							State:           atm.State,
+							History:         atm.History,
+							CountService:    atm.CountService,
+							Heartbeat:       heartbeat,
+							Logger: util.GetLogger().With(
+								zap.String("module", "topicHandler"),
+							),
+						}
+						topicHandler.Init(name, cluster)
+						return topicHandler
+					})
 					atm.Logger.Info("create a new topic handler",
 						zap.String("topic", topicString),
 						zap.String("cluster", clusterString),
 					)
 				}
 			}
+
+			// remove handlers of topics gone from Burrow.
+			for _, name := range pruneGone(topicsSet, topics) {
+				atm.remove(name, clusterString)
+			}
+
 			atm.clusterTopicMap.ReleaseLock(clusterString)
 		}
-		time.Sleep(5 * time.Minute)
+		// remove handlers of clusters gone from Burrow.
+		for cluster, names := range pruneGoneClusters(atm.clusterTopicMap, clusters) {
+			for _, name := range names {
+				atm.remove(name, cluster)
+			}
+		}
+		if !sleepWithContext(ctx, atm.DiscoveryInterval) {
+			return
+		}
 	}
 }
 
-// Stop is a general Stop
-func (atm *AliveTopicsMaintainer) Stop() error {
-	return nil
+// remove stops handler of a topic gone from Burrow.
+func (atm *AliveTopicsMaintainer) remove(topic string, cluster string) {
+	atm.supervisor.Remove(topic, cluster)
+	atm.CountService.Increase("topicRemoved", cluster)
+	atm.Logger.Info("remove a topic handler",
+		zap.String("topic", topic),
+		zap.String("cluster", cluster),
+		zap.Int64("timestamp", time.Now().Unix()),
+	)
 }
 
-func getTopics(link string, cluster string) (interface{}, string) {
-	topicsLink := link + cluster + "/topic"
-	return getHTTPSubSlice(topicsLink, "topics"), topicsLink + "/"
+// Stop waits until maintainer and all its topic handlers exit, ctx of Start should be cancelled before.
+func (atm *AliveTopicsMaintainer) Stop() error {
+	<-atm.doneChannel
+	return atm.supervisor.Stop()
 }
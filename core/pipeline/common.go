@@ -1,65 +1,56 @@
 package pipeline
 
 import (
-	"encoding/json"
-	"net/http"
+	"context"
 	"time"
 
 	"github.com/harbinzhang/goRainbow/core/util"
-	"go.uber.org/zap"
 )
 
-var logger *zap.Logger
-
-// PrepareLogger is Deprecated
-func PrepareLogger() {
-	logger = util.GetLogger()
-}
-
-// GetHTTPStruct put HTTP GET body into target
-func getHTTPStruct(link string, target interface{}) {
-	client := &http.Client{Timeout: 10 * time.Second}
-
-	resp, err := client.Get(link)
-	if err != nil {
-		logger.Error(err.Error())
+// sleepWithContext sleeps for d, returns false if ctx is cancelled before.
+func sleepWithContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
 	}
-
-	defer resp.Body.Close()
-	json.NewDecoder(resp.Body).Decode(target)
-}
-
-// getConsumers gets consumers based on cluster
-func getConsumers(link string, cluster string) (interface{}, string) {
-	consumersLink := link + cluster + "/consumer/"
-	return getHTTPSubSlice(consumersLink, "consumers"), consumersLink
 }
 
-// getClusters gets clusters
-func getClusters(link string) (interface{}, string) {
-	// defer Info.Println("Exit getClusters")
-	// Info.Println("Into getClusters")
-	return getHTTPSubSlice(link, "clusters"), link + "/"
+// pruneGone deletes the names not in alive from set, and returns them.
+func pruneGone(set map[string]interface{}, alive []string) []string {
+	aliveSet := make(map[string]bool, len(alive))
+	for _, name := range alive {
+		aliveSet[name] = true
+	}
+	var gone []string
+	for name := range set {
+		if !aliveSet[name] {
+			delete(set, name)
+			gone = append(gone, name)
+		}
+	}
+	return gone
 }
 
-// getHTTPSubSlice is getting json value from link
-func getHTTPSubSlice(link string, key string) interface{} {
-	resp, err := http.Get(link)
-	if err != nil {
-		logger.Error(err.Error())
-		return nil
+// pruneGoneClusters deletes all names of the clusters not in alive from clusterMap, and returns them by cluster.
+func pruneGoneClusters(clusterMap *util.SyncNestedMap, alive []string) map[string][]string {
+	aliveSet := make(map[string]bool, len(alive))
+	for _, cluster := range alive {
+		aliveSet[cluster] = true
 	}
-
-	decode := json.NewDecoder(resp.Body)
-
-	var s interface{}
-	err = decode.Decode(&s)
-	if err != nil {
-		logger.Error(err.Error())
-		return nil
+	gone := make(map[string][]string)
+	for _, cluster := range clusterMap.GetKeys() {
+		if aliveSet[cluster] {
+			continue
+		}
+		clusterMap.SetLock(cluster)
+		if names := pruneGone(clusterMap.GetChild(cluster, nil).(map[string]interface{}), nil); len(names) > 0 {
+			gone[cluster] = names
+		}
+		clusterMap.ReleaseLock(cluster)
 	}
-
-	// copy needed string slice to res
-	res := s.(map[string]interface{})
-	return res[key]
+	return gone
 }
@@ -1,11 +1,15 @@
 package pipeline
 
 import (
+	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/harbinzhang/goRainbow/core/alert"
+	"github.com/harbinzhang/goRainbow/core/burrow"
 	"github.com/harbinzhang/goRainbow/core/module"
 	"github.com/harbinzhang/goRainbow/core/protocol"
 	"github.com/harbinzhang/goRainbow/core/util"
@@ -17,77 +21,151 @@ import (
 // 3. consumer partition lag
 // 4. consumer max lag of partition
 // 5. consumer offset change rate
+// Heartbeat, if set, is called after every completed poll, failed or not, for Supervisor.
 type ConsumerHandler struct {
-	ProduceQueue       chan string
+	Burrow             *burrow.Client
+	PollInterval       time.Duration
+	ProduceQueue       chan protocol.Metric
 	CountService       *module.CountService
+	OffsetHistory      *module.OffsetHistory
+	Emission           EmissionPolicy
+	StallWindow        time.Duration
+	State              *module.StateStore
+	History            *module.LagHistory
+	Alerts             *alert.Engine
 	Logger             *zap.Logger
 	ClusterConsumerMap *util.SyncNestedMap
+	Heartbeat          func()
 
-	consumersLink string
-	consumer      string
-	cluster       string
+	consumer       string
+	cluster        string
+	removeOnce     sync.Once
+	removedChannel chan struct{}
+	doneChannel    chan struct{}
 }
 
 // Init is a general init
-func (ch *ConsumerHandler) Init(consumersLink string, consumer string, cluster string) {
-	ch.consumersLink = consumersLink
+func (ch *ConsumerHandler) Init(consumer string, cluster string) {
 	ch.consumer = consumer
 	ch.cluster = cluster
+	ch.removedChannel = make(chan struct{})
+	ch.doneChannel = make(chan struct{})
 }
 
-// Start is a general start
-func (ch *ConsumerHandler) Start() {
+// Remove tells handler the consumer is removed from Burrow consumer list,
+// handler sends 0 lag and stops.
+func (ch *ConsumerHandler) Remove() {
+	ch.removeOnce.Do(func() { close(ch.removedChannel) })
+}
+
+// Start is a general start, it keeps polling until ctx is cancelled or the consumer is gone from Burrow.
+// A gone consumer ends its lag metrics with 0.
+// A poll failed because Burrow is down is skipped, the consumer is kept.
+func (ch *ConsumerHandler) Start(ctx context.Context) {
 	defer ch.Logger.Sync()
+	defer close(ch.doneChannel)
 
-	fmt.Println("New consumer found: ", ch.consumersLink, ch.consumer)
+	fmt.Println("New consumer found: ", ch.cluster, ch.consumer)
 
 	lagInfoQueue := make(chan protocol.LagInfo)
 
-	ticker := time.NewTicker(30 * time.Second)
+	ticker := time.NewTicker(ch.PollInterval)
+	defer ticker.Stop()
 
 	prefix := "fjord.burrow." + ch.cluster + "." + ch.consumer
 
 	translator := &Translator{
-		LagQueue:     lagInfoQueue,
-		ProduceQueue: ch.ProduceQueue,
-		CountService: ch.CountService,
+		LagQueue:      lagInfoQueue,
+		ProduceQueue:  ch.ProduceQueue,
+		CountService:  ch.CountService,
+		OffsetHistory: ch.OffsetHistory,
+		Emission:      ch.Emission,
+		StallWindow:   ch.StallWindow,
+		State:         ch.State,
+		History:       ch.History,
+		Alerts:        ch.Alerts,
 		Logger: util.GetLogger().With(
 			zap.String("module", "Translator"),
 		),
 	}
 	translator.Init(prefix, ch.cluster)
-	go translator.Start()
+	go func() {
+		defer reportPanic(ch.CountService, ch.Logger, ch.cluster)
+		translator.Start()
+	}()
 
+	// translator sends all metrics it has before handler exits.
+	defer translator.Stop()
+	defer close(lagInfoQueue)
+
+	removed := false
+poll:
 	for {
 		// check its ch.consumer lag from Burrow periodically
-		<-ticker.C
-		var lagInfo protocol.LagInfo
-		getHTTPStruct(ch.consumersLink+ch.consumer+"/lag", &lagInfo.Lag)
-		if lagInfo.Lag.Error {
+		select {
+		case <-ctx.Done():
+			ch.Logger.Info("context cancelled, will stop handler.",
+				zap.String("consumer", ch.consumer),
+				zap.String("cluster", ch.cluster),
+			)
+			return
+		case <-ch.removedChannel:
+			removed = true
+			break poll
+		case <-ticker.C:
+		}
+
+		lag, err := ch.Burrow.ConsumerLag(ctx, ch.cluster, ch.consumer)
+		if burrow.IsNotFound(err) {
 			ch.Logger.Warn("Get consumer /lag error",
-				zap.String("message", lagInfo.Lag.Message),
+				zap.String("message", err.Error()),
 				zap.Int64("timestamp", time.Now().Unix()),
 			)
-			break
+			break poll
+		}
+		if err != nil {
+			ch.CountService.Increase("exception.burrowUnavailable", ch.cluster)
+			ch.Logger.Warn("Burrow unavailable, skip this poll",
+				zap.String("consumer", ch.consumer),
+				zap.String("error", err.Error()),
+				zap.Int64("timestamp", time.Now().Unix()),
+			)
+			// the handler is alive while Burrow is down, it's not restarted by Supervisor.
+			if ch.Heartbeat != nil {
+				ch.Heartbeat()
+			}
+			continue
+		}
+		lagInfoQueue <- protocol.LagInfo{
+			Lag:       lag,
+			Timestamp: time.Now().Unix(),
+		}
+		if ch.Heartbeat != nil {
+			ch.Heartbeat()
 		}
-		lagInfo.Timestamp = time.Now().Unix()
-		lagInfoQueue <- lagInfo
 	}
 
-	// snm.DeregisterChild(cluster, ch.consumer)
-	ch.ClusterConsumerMap.SetLock(ch.cluster)
-	delete(ch.ClusterConsumerMap.GetChild(ch.cluster, nil).(map[string]interface{}), ch.consumer)
-	ch.ClusterConsumerMap.ReleaseLock(ch.cluster)
+	// end metrics with 0 so dashboards end cleanly.
+	translator.Finish()
+
+	// a removed consumer is deleted from map by maintainer already,
+	// it may be added back by maintainer if it's back to Burrow.
+	if !removed {
+		// snm.DeregisterChild(cluster, ch.consumer)
+		ch.ClusterConsumerMap.SetLock(ch.cluster)
+		delete(ch.ClusterConsumerMap.GetChild(ch.cluster, nil).(map[string]interface{}), ch.consumer)
+		ch.ClusterConsumerMap.ReleaseLock(ch.cluster)
+	}
 
-	close(lagInfoQueue)
-	ch.Logger.Warn("consumer is invalid, will stop handler.",
+	ch.Logger.Warn("consumer is gone, will stop handler.",
 		zap.String("consumer", ch.consumer),
 		zap.String("cluster", ch.cluster),
 		zap.Int64("timestamp", time.Now().Unix()),
 	)
 }
 
-// Stop is a general stop
+// Stop waits until handler exits, its context should be cancelled before.
 func (ch *ConsumerHandler) Stop() error {
+	<-ch.doneChannel
 	return nil
 }
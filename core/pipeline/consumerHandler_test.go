@@ -0,0 +1,195 @@
+package pipeline
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/harbinzhang/goRainbow/core/burrow"
+	"github.com/harbinzhang/goRainbow/core/module"
+	"github.com/harbinzhang/goRainbow/core/protocol"
+	"github.com/harbinzhang/goRainbow/core/util"
+)
+
+func TestConsumerHandlerStopsOnCancel(t *testing.T) {
+	lag, _ := ioutil.ReadFile("../../config/pull_content.json")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(lag)
+	}))
+	defer server.Close()
+	client := &burrow.Client{BaseURL: server.URL}
+	client.Init()
+
+	produceQueue := make(chan protocol.Metric, 9000)
+	countService := &module.CountService{ProduceQueue: produceQueue}
+	countService.Start()
+
+	clusterConsumerMap := &util.SyncNestedMap{}
+	clusterConsumerMap.Init()
+
+	consumerHandler := &ConsumerHandler{
+		Burrow:             client,
+		PollInterval:       10 * time.Millisecond,
+		ProduceQueue:       produceQueue,
+		CountService:       countService,
+		ClusterConsumerMap: clusterConsumerMap,
+		Logger:             zap.NewNop(),
+	}
+	consumerHandler.Init("console-consumer-0", "test")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go consumerHandler.Start(ctx)
+
+	metric := <-produceQueue
+	assert.NotEqual(t, "", metric.Name, "handler should send metrics")
+
+	cancel()
+	stopped := make(chan error)
+	go func() { stopped <- consumerHandler.Stop() }()
+	select {
+	case err := <-stopped:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("handler should stop after context is cancelled")
+	}
+}
+
+func TestConsumerHandlerKeepsConsumerWhenBurrowDown(t *testing.T) {
+	lag, _ := ioutil.ReadFile("../../config/pull_content.json")
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.Write(lag)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":true,"message":"cluster or consumer not found"}`))
+		}
+	}))
+	defer server.Close()
+	client := &burrow.Client{BaseURL: server.URL}
+	client.Init()
+
+	produceQueue := make(chan protocol.Metric, 9000)
+	countService := &module.CountService{ProduceQueue: produceQueue}
+	countService.Start()
+
+	clusterConsumerMap := &util.SyncNestedMap{}
+	clusterConsumerMap.Init()
+	clusterConsumerMap.GetChild("test", map[string]interface{}{"console-consumer-0": true})
+
+	consumerHandler := &ConsumerHandler{
+		Burrow:             client,
+		PollInterval:       10 * time.Millisecond,
+		ProduceQueue:       produceQueue,
+		CountService:       countService,
+		ClusterConsumerMap: clusterConsumerMap,
+		Logger:             zap.NewNop(),
+	}
+	consumerHandler.Init("console-consumer-0", "test")
+
+	done := make(chan struct{})
+	go func() {
+		consumerHandler.Start(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler should stop when consumer is not found")
+	}
+
+	assert.True(t, len(produceQueue) > 0, "handler should keep polling after Burrow is back")
+	consumers := clusterConsumerMap.GetChild("test", nil).(map[string]interface{})
+	assert.Equal(t, 0, len(consumers), "consumer not found should be deregistered")
+}
+
+func TestConsumerHandlerRemoveSendsZero(t *testing.T) {
+	lag, _ := ioutil.ReadFile("../../config/pull_content.json")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(lag)
+	}))
+	defer server.Close()
+	client := &burrow.Client{BaseURL: server.URL}
+	client.Init()
+
+	produceQueue := make(chan protocol.Metric, 9000)
+	countService := &module.CountService{ProduceQueue: produceQueue}
+	countService.Start()
+
+	clusterConsumerMap := &util.SyncNestedMap{}
+	clusterConsumerMap.Init()
+
+	consumerHandler := &ConsumerHandler{
+		Burrow:             client,
+		PollInterval:       10 * time.Millisecond,
+		ProduceQueue:       produceQueue,
+		CountService:       countService,
+		ClusterConsumerMap: clusterConsumerMap,
+		Logger:             zap.NewNop(),
+	}
+	consumerHandler.Init("console-consumer-0", "test")
+
+	polled := make(chan struct{})
+	var once sync.Once
+	consumerHandler.Heartbeat = func() { once.Do(func() { close(polled) }) }
+
+	go consumerHandler.Start(context.Background())
+	<-polled
+	consumerHandler.Remove()
+	assert.Nil(t, consumerHandler.Stop())
+
+	var last protocol.Metric
+	for len(produceQueue) > 0 {
+		metric := <-produceQueue
+		if metric.Name == "fjord.burrow.test.console-consumer-0.totalLag" {
+			last = metric
+		}
+	}
+	assert.Equal(t, float64(0), last.Value, "removed consumer should end totalLag with 0")
+}
+
+func TestConsumerHandlerHeartbeatsWhenBurrowDown(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	client := &burrow.Client{BaseURL: server.URL}
+	client.Init()
+
+	produceQueue := make(chan protocol.Metric, 9000)
+	countService := &module.CountService{ProduceQueue: produceQueue}
+	countService.Start()
+
+	consumerHandler := &ConsumerHandler{
+		Burrow:       client,
+		PollInterval: 10 * time.Millisecond,
+		ProduceQueue: produceQueue,
+		CountService: countService,
+		Logger:       zap.NewNop(),
+	}
+	consumerHandler.Init("console-consumer-0", "test")
+	polled := make(chan struct{})
+	var once sync.Once
+	consumerHandler.Heartbeat = func() { once.Do(func() { close(polled) }) }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go consumerHandler.Start(ctx)
+	select {
+	case <-polled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a failed poll should heartbeat, so supervisor doesn't restart handler during Burrow outage")
+	}
+	cancel()
+	assert.Nil(t, consumerHandler.Stop())
+}
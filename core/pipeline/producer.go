@@ -1,97 +1,77 @@
 package pipeline
 
 import (
+	"errors"
 	"os"
+	"strings"
 	"time"
 
-	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"go.uber.org/zap"
 
 	"github.com/harbinzhang/goRainbow/core/module"
-	"github.com/harbinzhang/goRainbow/core/util"
+	"github.com/harbinzhang/goRainbow/core/protocol"
+	"github.com/harbinzhang/goRainbow/core/sink"
 )
 
-// Producer send metrics to Kafka
+// Producer fans metrics out to all sink workers.
 type Producer struct {
-	ProduceQueue chan string
+	ProduceQueue chan protocol.Metric
 	CountService *module.CountService
+	Workers      []*sink.Worker
 	Logger       *zap.Logger
+
+	doneChannel chan struct{}
+}
+
+// Init is a general init
+func (p *Producer) Init() {
+	p.doneChannel = make(chan struct{})
 }
 
-// Start is a general start
+// Start is a general start, it exits when ProduceQueue is closed and drained.
 func (p *Producer) Start() {
 	defer p.Logger.Sync()
+	defer close(p.doneChannel)
 
-	contextProvider := util.ContextProvider{}
-	contextProvider.Init()
-	postfix := contextProvider.GetPostfix()
-	conf := contextProvider.GetConf()
-
-	kafkaConfig := kafka.ConfigMap{
-		"batch.num.messages": 2000,
-		"linger.ms":          1,
-		"bootstrap.servers":  conf.Kafka.BrokerServers,
-		// "buffer.memory=33554432"
-		"socket.send.buffer.bytes": 1024000,
-		// "reconnect.backoff.ms":     100,
-		// "block.on.buffer.full=true"
-		"retries":            6,
-		"retry.backoff.ms":   100,
-		"compression.type":   "gzip",
-		"request.timeout.ms": 900000,
-	}
-	kafkaProducer, err := kafka.NewProducer(&kafkaConfig)
-	if err != nil {
-		panic("Err building kafka producer: " + err.Error())
-	}
-
-	defer kafkaProducer.Close()
-
-	// Delivery report handler for produced messages
-	go func() {
-		for e := range kafkaProducer.Events() {
-			switch ev := e.(type) {
-			case *kafka.Message:
-				if ev.TopicPartition.Error != nil {
-					p.Logger.Warn("Delivery failed",
-						zap.String("topicPartition", ev.TopicPartition.String()),
-						zap.Int64("timestamp", time.Now().Unix()),
-					)
-				}
-			}
-		}
-	}()
-
-	// rcsMetricsSent is for metrics level traffic, how many metrics sent to wavefront
-	rcsMetricsSent := &util.RequestCounter{
-		Name:         "metricsSent",
-		Interval:     60 * time.Second,
-		ProducerChan: p.ProduceQueue,
-		Postfix:      postfix,
+	for _, worker := range p.Workers {
+		go worker.Start()
 	}
-	rcsMetricsSent.Init()
 
 	// message := "fjord.burrow.test3.python-consumer-1.BusinessEvent.0.maxLag 0.00 1541214139 source=192.168.3.169 data_center=slv dca_zone=local department=fjord planet=sbx888 service_name=porter_rainbow porter_tools=porter-rainbow"
 
-	// Wait for message deliveries before shutting down
-	go kafkaProducer.Flush(15 * 1000)
-
-	// Produce messages to topic (asynchronously)
-	topic := conf.Kafka.Topic
-
 	env := os.Getenv("ENV")
 
-	for message := range p.ProduceQueue {
-		go rcsMetricsSent.Increase(env)
-		p.Logger.Debug("Produced to speed-racer: " + message)
-		kafkaProducer.Produce(&kafka.Message{
-			TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
-			Value:          []byte(message),
-		}, nil)
+	for metric := range p.ProduceQueue {
+		if err := metric.Validate(); err != nil {
+			p.CountService.Increase("exception.invalidMetric", env)
+			p.Logger.Warn("Invalid metric",
+				zap.String("error", err.Error()),
+				zap.Int64("timestamp", time.Now().Unix()),
+			)
+			continue
+		}
+		// metricsSent is for metrics level traffic, how many metrics sent to sinks
+		p.CountService.Increase("metricsSent", env)
+		// Offer never blocks, a full sink drops the metric by itself.
+		for _, worker := range p.Workers {
+			worker.Offer(metric)
+		}
 	}
 }
 
-// Stop is a general stop
+// Stop waits until ProduceQueue is drained, then stops all sinks.
+// ProduceQueue should be closed before.
 func (p *Producer) Stop() error {
+	<-p.doneChannel
+
+	var errs []string
+	for _, worker := range p.Workers {
+		if err := worker.Stop(); err != nil {
+			errs = append(errs, worker.Name+": "+err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New("stop sinks: " + strings.Join(errs, "; "))
+	}
 	return nil
 }
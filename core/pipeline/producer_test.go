@@ -0,0 +1,75 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/harbinzhang/goRainbow/core/module"
+	"github.com/harbinzhang/goRainbow/core/protocol"
+	"github.com/harbinzhang/goRainbow/core/sink"
+)
+
+type recordingSink struct {
+	sync.Mutex
+	metrics []protocol.Metric
+}
+
+func (rs *recordingSink) Init(options json.RawMessage, logger *zap.Logger) error { return nil }
+
+func (rs *recordingSink) Send(metric protocol.Metric) error {
+	rs.Lock()
+	defer rs.Unlock()
+	rs.metrics = append(rs.metrics, metric)
+	return nil
+}
+
+func (rs *recordingSink) Stop() error { return nil }
+
+func (rs *recordingSink) names() map[string]bool {
+	rs.Lock()
+	defer rs.Unlock()
+	names := make(map[string]bool)
+	for _, metric := range rs.metrics {
+		names[metric.Name] = true
+	}
+	return names
+}
+
+func TestProducerWithoutEnv(t *testing.T) {
+	env, ok := os.LookupEnv("ENV")
+	os.Unsetenv("ENV")
+	if ok {
+		defer os.Setenv("ENV", env)
+	}
+
+	produceQueue := make(chan protocol.Metric, 100)
+	countService := &module.CountService{Interval: 20 * time.Millisecond, ProduceQueue: produceQueue}
+	countService.Start()
+	recorder := &recordingSink{}
+	worker := &sink.Worker{Name: "recorder", Sink: recorder, CountService: countService, Logger: zap.NewNop()}
+	worker.Init(10)
+	producer := &Producer{
+		ProduceQueue: produceQueue,
+		CountService: countService,
+		Workers:      []*sink.Worker{worker},
+		Logger:       zap.NewNop(),
+	}
+	producer.Init()
+	go producer.Start()
+
+	produceQueue <- protocol.NewMetric([]string{"fjord.burrow.c1.g1.totalLag"}, 1, 100, map[string]string{"env": "c1"})
+	waitFor(t, func() bool {
+		return recorder.names()["fjord.burrow.metricsSent"]
+	}, "internal counters should be sent without ENV")
+
+	countService.Stop()
+	close(produceQueue)
+	assert.Nil(t, producer.Stop())
+	assert.False(t, recorder.names()["fjord.burrow.exception.invalidMetric"], "no metric should be invalid")
+}
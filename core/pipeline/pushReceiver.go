@@ -0,0 +1,212 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/harbinzhang/goRainbow/core/alert"
+	"github.com/harbinzhang/goRainbow/core/burrow"
+	"github.com/harbinzhang/goRainbow/core/module"
+	"github.com/harbinzhang/goRainbow/core/protocol"
+	"github.com/harbinzhang/goRainbow/core/util"
+)
+
+// PushReceiver accepts posts of Burrow HTTP notifier, and feeds them to a Translator per consumer group,
+// the same as ConsumerHandler does with polled lags.
+// A consumer group without posts for IdleTimeout ends its metrics with 0, like a consumer gone from Burrow.
+// Tags are added to all push metrics, e.g. input=push keeps them apart from pull ones.
+// State, History and Alerts are optional, they should be left nil if pull mode feeds them.
+type PushReceiver struct {
+	Tags          map[string]string
+	IdleTimeout   time.Duration
+	ProduceQueue  chan protocol.Metric
+	CountService  *module.CountService
+	OffsetHistory *module.OffsetHistory
+	Emission      EmissionPolicy
+	StallWindow   time.Duration
+	State         *module.StateStore
+	History       *module.LagHistory
+	Alerts        *alert.Engine
+	Logger        *zap.Logger
+
+	mutex       sync.Mutex
+	stopped     bool
+	consumers   map[string]*pushedConsumer
+	doneChannel chan struct{}
+}
+
+// pushQueueSize is LagInfo buffered for a translator, a post is rejected if its translator is this far behind,
+// so a slow translator doesn't block posts of the other consumer groups.
+const pushQueueSize = 100
+
+// maxNotificationSize bounds the body of a post.
+const maxNotificationSize = 8 << 20
+
+type pushedConsumer struct {
+	translator *Translator
+	lagQueue   chan protocol.LagInfo
+	lastSeen   time.Time
+}
+
+// Init is a general init
+func (pr *PushReceiver) Init() {
+	if pr.IdleTimeout == 0 {
+		pr.IdleTimeout = 10 * time.Minute
+	}
+	pr.consumers = make(map[string]*pushedConsumer)
+	pr.doneChannel = make(chan struct{})
+}
+
+// Start is a general start, it finishes idle consumer groups until ctx is cancelled.
+func (pr *PushReceiver) Start(ctx context.Context) {
+	defer pr.Logger.Sync()
+	defer close(pr.doneChannel)
+
+	ticker := time.NewTicker(pr.IdleTimeout / 10)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			pr.stopAll()
+			return
+		case <-ticker.C:
+			pr.finishIdle(time.Now())
+		}
+	}
+}
+
+// Stop waits until all translators exit, ctx of Start should be cancelled before.
+func (pr *PushReceiver) Stop() error {
+	<-pr.doneChannel
+	return nil
+}
+
+// Handler is for Burrow HTTP notifier, see burrow.ParseNotification for accepted payloads.
+func (pr *PushReceiver) Handler() func(http.ResponseWriter, *http.Request) {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxNotificationSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		lags, err := burrow.ParseNotification(body)
+		if err != nil {
+			pr.CountService.Increase("exception.invalidNotification", "push")
+			pr.Logger.Warn("invalid Burrow notification",
+				zap.String("error", err.Error()),
+				zap.Int64("timestamp", time.Now().Unix()),
+			)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		timestamp := time.Now().Unix()
+		for _, lag := range lags {
+			if err := pr.Receive(protocol.LagInfo{Lag: lag, Timestamp: timestamp}); err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Receive feeds a pushed LagInfo to translator of its consumer group, a new group gets a new translator.
+// It never blocks, the LagInfo is dropped with an error if the translator is behind.
+func (pr *PushReceiver) Receive(lagInfo protocol.LagInfo) error {
+	pr.mutex.Lock()
+	defer pr.mutex.Unlock()
+	if pr.stopped {
+		return errors.New("push receiver is stopped")
+	}
+
+	cluster, group := lagInfo.Lag.Status.Cluster, lagInfo.Lag.Status.Group
+	key := cluster + ":" + group
+	consumer, ok := pr.consumers[key]
+	if !ok {
+		consumer = pr.newConsumer(cluster, group)
+		pr.consumers[key] = consumer
+		pr.Logger.Info("new consumer pushed",
+			zap.String("consumer", group),
+			zap.String("cluster", cluster),
+		)
+	}
+	consumer.lastSeen = time.Now()
+	select {
+	case consumer.lagQueue <- lagInfo:
+		pr.CountService.Increase("pushedMessage", cluster)
+		return nil
+	default:
+		pr.CountService.Increase("exception.pushDropped", cluster)
+		return errors.New("translator of " + key + " is behind, the post is dropped")
+	}
+}
+
+// newConsumer should be called with mutex held.
+func (pr *PushReceiver) newConsumer(cluster string, group string) *pushedConsumer {
+	lagQueue := make(chan protocol.LagInfo, pushQueueSize)
+	translator := &Translator{
+		LagQueue:      lagQueue,
+		ProduceQueue:  pr.ProduceQueue,
+		CountService:  pr.CountService,
+		OffsetHistory: pr.OffsetHistory,
+		Emission:      pr.Emission,
+		StallWindow:   pr.StallWindow,
+		State:         pr.State,
+		History:       pr.History,
+		Alerts:        pr.Alerts,
+		Tags:          pr.Tags,
+		Logger: util.GetLogger().With(
+			zap.String("module", "pushTranslator"),
+		),
+	}
+	translator.Init("fjord.burrow."+cluster+"."+group, cluster)
+	go translator.Start()
+	return &pushedConsumer{translator: translator, lagQueue: lagQueue}
+}
+
+// finishIdle ends metrics of consumer groups without posts for IdleTimeout with 0.
+func (pr *PushReceiver) finishIdle(now time.Time) {
+	pr.mutex.Lock()
+	var idle []*pushedConsumer
+	for key, consumer := range pr.consumers {
+		if now.Sub(consumer.lastSeen) >= pr.IdleTimeout {
+			consumer.translator.Finish()
+			close(consumer.lagQueue)
+			delete(pr.consumers, key)
+			idle = append(idle, consumer)
+		}
+	}
+	pr.mutex.Unlock()
+
+	for _, consumer := range idle {
+		consumer.translator.Stop()
+	}
+}
+
+// stopAll stops all translators without 0 lag, as pull mode does on shutdown.
+func (pr *PushReceiver) stopAll() {
+	pr.mutex.Lock()
+	pr.stopped = true
+	consumers := pr.consumers
+	pr.consumers = make(map[string]*pushedConsumer)
+	for _, consumer := range consumers {
+		close(consumer.lagQueue)
+	}
+	pr.mutex.Unlock()
+
+	for _, consumer := range consumers {
+		consumer.translator.Stop()
+	}
+}
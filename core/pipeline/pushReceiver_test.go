@@ -0,0 +1,161 @@
+package pipeline
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/harbinzhang/goRainbow/core/burrow"
+	"github.com/harbinzhang/goRainbow/core/module"
+	"github.com/harbinzhang/goRainbow/core/protocol"
+)
+
+func TestPushReceiver(t *testing.T) {
+	produceQueue := make(chan protocol.Metric, 9000)
+	countService := &module.CountService{ProduceQueue: produceQueue}
+	countService.Start()
+
+	pushReceiver := &PushReceiver{
+		Tags:         map[string]string{"input": "push"},
+		IdleTimeout:  time.Hour,
+		ProduceQueue: produceQueue,
+		CountService: countService,
+		Logger:       zap.NewNop(),
+	}
+	pushReceiver.Init()
+	ctx, cancel := context.WithCancel(context.Background())
+	go pushReceiver.Start(ctx)
+
+	server := httptest.NewServer(http.HandlerFunc(pushReceiver.Handler()))
+	defer server.Close()
+
+	body, _ := ioutil.ReadFile("../../config/pull_content.json")
+	resp, err := http.Post(server.URL, "application/json", strings.NewReader(string(body)))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, err = http.Post(server.URL, "application/json", strings.NewReader(`{"unknown": 1}`))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	metric := <-produceQueue
+	assert.True(t, strings.HasPrefix(metric.Name, "fjord.burrow.test.console-consumer-0."), metric.Name)
+	assert.Equal(t, "push", metric.Tags["input"], "push metrics are tagged, not renamed")
+
+	// an idle consumer ends with 0 total lag.
+	pushReceiver.finishIdle(time.Now().Add(time.Hour))
+	var totalLag *protocol.Metric
+	for len(produceQueue) > 0 {
+		metric := <-produceQueue
+		if metric.Name == "fjord.burrow.test.console-consumer-0.totalLag" {
+			totalLag = &metric
+		}
+	}
+	assert.NotNil(t, totalLag)
+	assert.Equal(t, float64(0), totalLag.Value)
+
+	cancel()
+	pushReceiver.Stop()
+	resp, err = http.Post(server.URL, "application/json", strings.NewReader(string(body)))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, "stopped receiver rejects posts")
+}
+
+func TestPushReceiverSlowTranslator(t *testing.T) {
+	// nobody reads metrics, translators are stuck.
+	produceQueue := make(chan protocol.Metric)
+	countService := &module.CountService{ProduceQueue: produceQueue}
+	countService.Start()
+
+	pushReceiver := &PushReceiver{
+		IdleTimeout:  time.Hour,
+		ProduceQueue: produceQueue,
+		CountService: countService,
+		Logger:       zap.NewNop(),
+	}
+	pushReceiver.Init()
+	ctx, cancel := context.WithCancel(context.Background())
+	go pushReceiver.Start(ctx)
+
+	slow := protocol.LagInfo{Timestamp: 100}
+	slow.Lag.Status.Cluster, slow.Lag.Status.Group = "test", "slow"
+	var err error
+	for i := 0; i < 2*pushQueueSize && err == nil; i++ {
+		err = pushReceiver.Receive(slow)
+	}
+	assert.NotNil(t, err, "posts of a stuck translator should be dropped instead of blocking")
+	other := slow
+	other.Lag.Status.Group = "other"
+	assert.Nil(t, pushReceiver.Receive(other), "other groups are not blocked")
+
+	server := httptest.NewServer(http.HandlerFunc(pushReceiver.Handler()))
+	defer server.Close()
+	resp, err := http.Post(server.URL, "application/json", strings.NewReader(strings.Repeat(" ", maxNotificationSize+1)))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	message, _ := ioutil.ReadAll(resp.Body)
+	assert.Contains(t, string(message), "too large", "too large post should be rejected")
+
+	go func() {
+		for range produceQueue {
+		}
+	}()
+	cancel()
+	pushReceiver.Stop()
+}
+
+func TestPushReceiverSlackAttachments(t *testing.T) {
+	produceQueue := make(chan protocol.Metric, 9000)
+	countService := &module.CountService{ProduceQueue: produceQueue}
+	countService.Start()
+	timestamp := time.Now().Unix()
+	offsetHistory := &module.OffsetHistory{}
+	offsetHistory.Init()
+	offsetHistory.Record("test", "orders", 0, 100, timestamp-100)
+	offsetHistory.Record("test", "orders", 0, 200, timestamp)
+
+	pushReceiver := &PushReceiver{
+		IdleTimeout:   time.Hour,
+		ProduceQueue:  produceQueue,
+		CountService:  countService,
+		OffsetHistory: offsetHistory,
+		StallWindow:   time.Second,
+		Logger:        zap.NewNop(),
+	}
+	pushReceiver.Init()
+	ctx, cancel := context.WithCancel(context.Background())
+	go pushReceiver.Start(ctx)
+
+	lags, err := burrow.ParseNotification([]byte(`{"attachments": [{"color": "danger", "fields": [
+		{"title": "Cluster", "value": "test"},
+		{"title": "Group", "value": "slack"},
+		{"title": "orders:0", "value": "10"}
+	]}]}`))
+	assert.Nil(t, err)
+	assert.Nil(t, pushReceiver.Receive(protocol.LagInfo{Lag: lags[0], Timestamp: timestamp}))
+	assert.Nil(t, pushReceiver.Receive(protocol.LagInfo{Lag: lags[0], Timestamp: timestamp + 10}))
+	cancel()
+	pushReceiver.Stop()
+
+	metrics := make(map[string]protocol.Metric)
+	for len(produceQueue) > 0 {
+		metric := <-produceQueue
+		metrics[metric.Name] = metric
+	}
+	prefix := "fjord.burrow.test.slack."
+	assert.Equal(t, float64(10), metrics[prefix+"orders.0.Lag"].Value)
+	for _, name := range []string{"orders.0.startOffset", "orders.0.endOffset", "orders.0.timeLag",
+		"orders.0.consumptionState", "maxTimeLag", "maxLagEndOffset"} {
+		_, ok := metrics[prefix+name]
+		assert.False(t, ok, name+" should not be sent without offsets")
+	}
+	assert.Equal(t, float64(module.ConsumptionIdle.Code()), metrics[prefix+"consumptionState"].Value,
+		"partitions without offsets should not be stalled")
+}
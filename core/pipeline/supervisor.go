@@ -0,0 +1,304 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/harbinzhang/goRainbow/core/module"
+)
+
+// supervisedHandler is a consumer or topic handler run by Supervisor.
+// Remove tells the handler its consumer/topic is gone from Burrow, it should end its metrics and return.
+type supervisedHandler interface {
+	Start(ctx context.Context)
+	Remove()
+}
+
+// handlerFactory creates a new handler, the handler should call heartbeat after every completed poll,
+// including a failed one, so a Burrow outage doesn't restart handlers.
+type handlerFactory func(heartbeat func()) supervisedHandler
+
+// Supervisor runs handlers for a maintainer. It recovers a handler from panic, and restarts
+// a handler which panics or has no completed poll in StallTimeout, with exponential backoff.
+// A handler which returns by itself(e.g. consumer is gone) or is removed is not restarted.
+// Usage:
+// supervisor.Init()
+// go supervisor.Start(ctx)
+// supervisor.Go(ctx, name, cluster, factory)
+type Supervisor struct {
+	// Kind is "consumer" or "topic", restarts are counted as {Kind}HandlerRestart.{name}
+	Kind          string
+	StallTimeout  time.Duration
+	CheckInterval time.Duration
+	Backoff       time.Duration
+	MaxBackoff    time.Duration
+	CountService  *module.CountService
+	Logger        *zap.Logger
+
+	mutex       sync.Mutex
+	children    map[string]*supervisedChild
+	wg          sync.WaitGroup
+	doneChannel chan struct{}
+}
+
+type supervisedChild struct {
+	name     string
+	cluster  string
+	factory  handlerFactory
+	failures int
+	current  *handlerInstance
+	removed  bool
+	cancel   context.CancelFunc
+}
+
+// handlerInstance is one run of a handler, a stalled instance is abandoned instead of waited.
+type handlerInstance struct {
+	// heartbeat is unix nano of last completed poll
+	heartbeat int64
+	handler   supervisedHandler
+	cancel    context.CancelFunc
+	stalled   chan struct{}
+	isStalled bool
+	// release gives back its slot in wg, on exit or when it's abandoned, so Stop doesn't wait for a stuck one.
+	release func()
+}
+
+// Init is a general init
+func (s *Supervisor) Init() {
+	if s.StallTimeout == 0 {
+		s.StallTimeout = 5 * time.Minute
+	}
+	if s.CheckInterval == 0 {
+		s.CheckInterval = 10 * time.Second
+	}
+	if s.Backoff == 0 {
+		s.Backoff = time.Second
+	}
+	if s.MaxBackoff == 0 {
+		s.MaxBackoff = 5 * time.Minute
+	}
+	s.children = make(map[string]*supervisedChild)
+	s.doneChannel = make(chan struct{})
+}
+
+// Start is a general start, it checks stalled handlers until ctx is cancelled.
+func (s *Supervisor) Start(ctx context.Context) {
+	defer close(s.doneChannel)
+
+	ticker := time.NewTicker(s.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.checkStalled(now)
+		}
+	}
+}
+
+// Stop waits until the stall checker and all handlers exit, ctx of Start and Go should be cancelled before.
+// Abandoned handlers are not waited.
+func (s *Supervisor) Stop() error {
+	<-s.doneChannel
+	s.wg.Wait()
+	return nil
+}
+
+// Remove stops the handler of a consumer/topic gone from Burrow, the handler sends its final metrics before exit.
+func (s *Supervisor) Remove(name string, cluster string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	child, ok := s.children[cluster+":"+name]
+	if !ok || child.removed {
+		return
+	}
+	child.removed = true
+	if child.current != nil && child.current.handler != nil && !child.current.isStalled {
+		child.current.handler.Remove()
+		return
+	}
+	// it's waiting for restart, or stalled.
+	child.cancel()
+}
+
+// Go runs a handler created by factory under supervision until ctx is cancelled or the handler returns.
+func (s *Supervisor) Go(ctx context.Context, name string, cluster string, factory handlerFactory) {
+	ctx, cancel := context.WithCancel(ctx)
+	child := &supervisedChild{
+		name:    name,
+		cluster: cluster,
+		factory: factory,
+		cancel:  cancel,
+	}
+	key := cluster + ":" + name
+
+	s.mutex.Lock()
+	s.children[key] = child
+	s.mutex.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+		s.run(ctx, child)
+
+		s.mutex.Lock()
+		if s.children[key] == child {
+			delete(s.children, key)
+		}
+		s.mutex.Unlock()
+	}()
+}
+
+func (s *Supervisor) run(ctx context.Context, child *supervisedChild) {
+	for {
+		instanceCtx, cancel := context.WithCancel(ctx)
+		var releaseOnce sync.Once
+		instance := &handlerInstance{
+			heartbeat: time.Now().UnixNano(),
+			cancel:    cancel,
+			stalled:   make(chan struct{}),
+			release:   func() { releaseOnce.Do(s.wg.Done) },
+		}
+		s.mutex.Lock()
+		child.current = instance
+		s.mutex.Unlock()
+
+		exited := make(chan bool, 1)
+		s.wg.Add(1)
+		go func() {
+			defer instance.release()
+			exited <- s.runInstance(instanceCtx, child, instance)
+		}()
+
+		var reason string
+		select {
+		case panicked := <-exited:
+			if !panicked {
+				cancel()
+				return
+			}
+			reason = "panic"
+		case <-instance.stalled:
+			reason = "stalled"
+			instance.release()
+		case <-ctx.Done():
+			return
+		}
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+
+		s.mutex.Lock()
+		if child.removed {
+			s.mutex.Unlock()
+			return
+		}
+		child.failures++
+		backoff := s.backoff(child.failures)
+		s.mutex.Unlock()
+
+		s.CountService.Increase(s.Kind+"HandlerRestart."+child.name, child.cluster)
+		s.Logger.Warn("handler will restart",
+			zap.String("handler", child.name),
+			zap.String("cluster", child.cluster),
+			zap.String("reason", reason),
+			zap.Duration("backoff", backoff),
+			zap.Int64("timestamp", time.Now().Unix()),
+		)
+		if !sleepWithContext(ctx, backoff) {
+			return
+		}
+	}
+}
+
+// runInstance runs the handler and recovers it from panic.
+func (s *Supervisor) runInstance(ctx context.Context, child *supervisedChild, instance *handlerInstance) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicked = true
+			s.CountService.Increase("exception.handlerPanic", child.cluster)
+			s.Logger.Error("handler panic",
+				zap.String("handler", child.name),
+				zap.String("cluster", child.cluster),
+				zap.String("panic", fmt.Sprint(r)),
+				zap.String("stack", string(debug.Stack())),
+				zap.Int64("timestamp", time.Now().Unix()),
+			)
+		}
+	}()
+
+	handler := child.factory(func() {
+		atomic.StoreInt64(&instance.heartbeat, time.Now().UnixNano())
+		// a completed poll resets the backoff
+		s.mutex.Lock()
+		child.failures = 0
+		s.mutex.Unlock()
+	})
+	s.mutex.Lock()
+	instance.handler = handler
+	removed := child.removed
+	s.mutex.Unlock()
+	if removed {
+		handler.Remove()
+	}
+	handler.Start(ctx)
+	return false
+}
+
+// checkStalled cancels and abandons the handlers without a completed poll in StallTimeout.
+func (s *Supervisor) checkStalled(now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, child := range s.children {
+		instance := child.current
+		if instance == nil || instance.isStalled {
+			continue
+		}
+		if now.Sub(time.Unix(0, atomic.LoadInt64(&instance.heartbeat))) <= s.StallTimeout {
+			continue
+		}
+		instance.isStalled = true
+		instance.cancel()
+		close(instance.stalled)
+		s.CountService.Increase("exception.handlerStalled", child.cluster)
+	}
+}
+
+// reportPanic recovers a goroutine started by a handler, e.g. parsing or offset handling,
+// so its panic doesn't kill the process. It should be deferred directly.
+func reportPanic(countService *module.CountService, logger *zap.Logger, cluster string) {
+	if r := recover(); r != nil {
+		if countService != nil {
+			countService.Increase("exception.handlerPanic", cluster)
+		}
+		logger.Error("goroutine panic",
+			zap.String("cluster", cluster),
+			zap.String("panic", fmt.Sprint(r)),
+			zap.String("stack", string(debug.Stack())),
+			zap.Int64("timestamp", time.Now().Unix()),
+		)
+	}
+}
+
+// backoff is Backoff * 2^(failures-1), up to MaxBackoff.
+func (s *Supervisor) backoff(failures int) time.Duration {
+	d := s.Backoff
+	for i := 1; i < failures && d < s.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > s.MaxBackoff {
+		d = s.MaxBackoff
+	}
+	return d
+}
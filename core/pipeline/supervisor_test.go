@@ -0,0 +1,183 @@
+package pipeline
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/harbinzhang/goRainbow/core/module"
+	"github.com/harbinzhang/goRainbow/core/protocol"
+)
+
+// fakeHandler panics, stalls or returns as its behavior tells.
+type fakeHandler struct {
+	behavior  func(ctx context.Context, heartbeat func())
+	heartbeat func()
+	removed   int32
+}
+
+func (fh *fakeHandler) Start(ctx context.Context) {
+	fh.behavior(ctx, fh.heartbeat)
+}
+
+func (fh *fakeHandler) Remove() {
+	atomic.StoreInt32(&fh.removed, 1)
+}
+
+func prepareSupervisor() (*Supervisor, context.CancelFunc) {
+	produceQueue := make(chan protocol.Metric, 9000)
+	countService := &module.CountService{ProduceQueue: produceQueue}
+	countService.Start()
+
+	supervisor := &Supervisor{
+		Kind:          "consumer",
+		StallTimeout:  50 * time.Millisecond,
+		CheckInterval: 10 * time.Millisecond,
+		Backoff:       time.Millisecond,
+		MaxBackoff:    10 * time.Millisecond,
+		CountService:  countService,
+		Logger:        zap.NewNop(),
+	}
+	supervisor.Init()
+	ctx, cancel := context.WithCancel(context.Background())
+	go supervisor.Start(ctx)
+	return supervisor, cancel
+}
+
+func goFake(s *Supervisor, name string, starts *int32, behavior func(ctx context.Context, heartbeat func())) context.CancelFunc {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.Go(ctx, name, "test", func(heartbeat func()) supervisedHandler {
+		atomic.AddInt32(starts, 1)
+		return &fakeHandler{behavior: behavior, heartbeat: heartbeat}
+	})
+	return cancel
+}
+
+func waitFor(t *testing.T, condition func() bool, message string) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSupervisorRestartsPanic(t *testing.T) {
+	supervisor, cancel := prepareSupervisor()
+
+	var starts int32
+	stopHandler := goFake(supervisor, "panic", &starts, func(ctx context.Context, heartbeat func()) {
+		if atomic.LoadInt32(&starts) < 3 {
+			panic("unchecked resp.Body")
+		}
+		<-ctx.Done()
+	})
+	waitFor(t, func() bool { return atomic.LoadInt32(&starts) == 3 }, "panicked handler should be restarted")
+
+	stopHandler()
+	cancel()
+	assert.Nil(t, supervisor.Stop())
+}
+
+func TestSupervisorRestartsStalled(t *testing.T) {
+	supervisor, cancel := prepareSupervisor()
+
+	var starts, healthyStarts int32
+	block := make(chan struct{})
+	defer close(block)
+	stopStalled := goFake(supervisor, "stalled", &starts, func(ctx context.Context, heartbeat func()) {
+		if atomic.LoadInt32(&starts) == 1 {
+			// hangs and ignores ctx
+			<-block
+			return
+		}
+		<-ctx.Done()
+	})
+	stopHealthy := goFake(supervisor, "healthy", &healthyStarts, func(ctx context.Context, heartbeat func()) {
+		ticker := time.NewTicker(5 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				heartbeat()
+			}
+		}
+	})
+	waitFor(t, func() bool { return atomic.LoadInt32(&starts) >= 2 }, "stalled handler should be restarted")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&healthyStarts), "healthy handler should not be restarted")
+
+	stopStalled()
+	stopHealthy()
+	cancel()
+	stopped := make(chan error)
+	go func() { stopped <- supervisor.Stop() }()
+	select {
+	case err := <-stopped:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Stop should not wait for the abandoned handler")
+	}
+}
+
+func TestSupervisorDoesNotRestartReturned(t *testing.T) {
+	supervisor, cancel := prepareSupervisor()
+
+	var starts int32
+	goFake(supervisor, "gone", &starts, func(ctx context.Context, heartbeat func()) {})
+	waitFor(t, func() bool {
+		supervisor.mutex.Lock()
+		defer supervisor.mutex.Unlock()
+		return len(supervisor.children) == 0
+	}, "returned handler should be removed")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&starts))
+
+	cancel()
+	assert.Nil(t, supervisor.Stop())
+}
+
+func TestSupervisorRemove(t *testing.T) {
+	supervisor, cancel := prepareSupervisor()
+
+	var starts int32
+	handler := &fakeHandler{}
+	handler.behavior = func(ctx context.Context, heartbeat func()) {
+		for atomic.LoadInt32(&handler.removed) == 0 {
+			heartbeat()
+			time.Sleep(time.Millisecond)
+		}
+	}
+	ctx, stopHandler := context.WithCancel(context.Background())
+	defer stopHandler()
+	supervisor.Go(ctx, "removed", "test", func(heartbeat func()) supervisedHandler {
+		atomic.AddInt32(&starts, 1)
+		handler.heartbeat = heartbeat
+		return handler
+	})
+	waitFor(t, func() bool { return atomic.LoadInt32(&starts) == 1 }, "handler should start")
+
+	supervisor.Remove("removed", "test")
+	waitFor(t, func() bool {
+		supervisor.mutex.Lock()
+		defer supervisor.mutex.Unlock()
+		return len(supervisor.children) == 0
+	}, "removed handler should exit")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&starts), "removed handler should not be restarted")
+
+	cancel()
+	assert.Nil(t, supervisor.Stop())
+}
+
+func TestSupervisorBackoff(t *testing.T) {
+	supervisor := &Supervisor{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	supervisor.Init()
+	assert.Equal(t, time.Second, supervisor.backoff(1))
+	assert.Equal(t, 4*time.Second, supervisor.backoff(3))
+	assert.Equal(t, 5*time.Second, supervisor.backoff(100))
+}
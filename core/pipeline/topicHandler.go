@@ -1,44 +1,65 @@
 package pipeline
 
 import (
+	"context"
 	"fmt"
 	"strconv"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/harbinzhang/goRainbow/core/burrow"
 	"github.com/harbinzhang/goRainbow/core/module"
 	"github.com/harbinzhang/goRainbow/core/protocol"
 	"github.com/harbinzhang/goRainbow/core/util"
 )
 
 // TopicHandler is a offset handler for topic.
+// Heartbeat, if set, is called after every completed poll, failed or not, for Supervisor.
 type TopicHandler struct {
-	ProduceQueue    chan string
+	Burrow          *burrow.Client
+	PollInterval    time.Duration
+	ProduceQueue    chan protocol.Metric
 	ClusterTopicMap *util.SyncNestedMap
 	CountService    *module.CountService
+	OffsetHistory   *module.OffsetHistory
+	State           *module.StateStore
+	History         *module.LagHistory
 	Logger          *zap.Logger
-
-	topicLink string
-	topic     string
-	cluster   string
-	postfix   string
-	oom       *module.OwnerOffsetMoveHelper
+	Heartbeat       func()
+
+	topic          string
+	cluster        string
+	oom            *module.OwnerOffsetMoveHelper
+	wg             sync.WaitGroup
+	removeOnce     sync.Once
+	removedChannel chan struct{}
+	doneChannel    chan struct{}
 }
 
 // Init is a general init
-func (th *TopicHandler) Init(topicLink string, topic string, cluster string, postfix string) {
-	th.topicLink = topicLink
+func (th *TopicHandler) Init(topic string, cluster string) {
 	th.topic = topic
 	th.cluster = cluster
-	th.postfix = postfix
+	th.removedChannel = make(chan struct{})
+	th.doneChannel = make(chan struct{})
+}
+
+// Remove tells handler the topic is removed from Burrow topic list,
+// handler sends 0 offset rate and stops.
+func (th *TopicHandler) Remove() {
+	th.removeOnce.Do(func() { close(th.removedChannel) })
 }
 
-// Start is a general start
-func (th *TopicHandler) Start() {
+// Start is a general start, it keeps polling until ctx is cancelled or the topic is gone from Burrow.
+// A poll failed because Burrow is down is skipped, the topic is kept.
+// A gone topic ends its offset rates with 0 and frees its offset history.
+func (th *TopicHandler) Start(ctx context.Context) {
 	defer th.Logger.Sync()
+	defer close(th.doneChannel)
 
-	fmt.Println("New topic found: ", th.topicLink, th.topic)
+	fmt.Println("New topic found: ", th.cluster, th.topic)
 
 	prefix := "fjord.burrow." + th.cluster + ".topic." + th.topic
 
@@ -50,51 +71,106 @@ func (th *TopicHandler) Start() {
 			zap.String("module", "topicOwnerOffsetMoveHelper"),
 		),
 	}
-	th.oom.Init(prefix, th.postfix, th.cluster, "offsetRate")
+	th.oom.Init(prefix, th.cluster, "offsetRate")
+
+	// all offsets are sent before handler exits.
+	defer th.oom.Stop()
+	defer th.wg.Wait()
 
-	ticker := time.NewTicker(60 * time.Second)
+	ticker := time.NewTicker(th.PollInterval)
+	defer ticker.Stop()
+	removed := false
+poll:
 	for {
 		// check its topic offset from Burrow periodically
-		<-ticker.C
-		var topicOffset protocol.TopicOffset
-		getHTTPStruct(th.topicLink+th.topic, &topicOffset)
-		if topicOffset.Error {
-			th.Logger.Warn("Get consumer /lag error",
-				zap.String("message", topicOffset.Message),
-				zap.Int64("timestamp", time.Now().Unix()),
+		select {
+		case <-ctx.Done():
+			th.Logger.Info("context cancelled, will stop handler",
+				zap.String("topic", th.topic),
+				zap.String("cluster", th.cluster),
 			)
-			break
+			return
+		case <-th.removedChannel:
+			removed = true
+			break poll
+		case <-ticker.C:
 		}
 
-		go th.handleTopicOffset(topicOffset, prefix, time.Now().Unix())
+		topicOffset, err := th.Burrow.TopicOffsets(ctx, th.cluster, th.topic)
+		if burrow.IsNotFound(err) {
+			th.Logger.Warn("Get topic offsets error",
+				zap.String("message", err.Error()),
+				zap.Int64("timestamp", time.Now().Unix()),
+			)
+			break poll
+		}
+		if err != nil {
+			th.CountService.Increase("exception.burrowUnavailable", th.cluster)
+			th.Logger.Warn("Burrow unavailable, skip this poll",
+				zap.String("topic", th.topic),
+				zap.String("error", err.Error()),
+				zap.Int64("timestamp", time.Now().Unix()),
+			)
+			// the handler is alive while Burrow is down, it's not restarted by Supervisor.
+			if th.Heartbeat != nil {
+				th.Heartbeat()
+			}
+			continue
+		}
 
+		th.wg.Add(1)
+		go func(timestamp int64) {
+			defer th.wg.Done()
+			defer reportPanic(th.CountService, th.Logger, th.cluster)
+			th.handleTopicOffset(topicOffset, prefix, timestamp)
+		}(time.Now().Unix())
+		if th.Heartbeat != nil {
+			th.Heartbeat()
+		}
 	}
 
-	// snm.DeregisterChild(cluster, topic)
-	// this can be deadlock in some extreme cases.
-	th.ClusterTopicMap.SetLock(th.cluster)
-	delete(th.ClusterTopicMap.GetChild(th.cluster, nil).(map[string]interface{}), th.topic)
-	th.ClusterTopicMap.ReleaseLock(th.cluster)
+	// end offset rates with 0 after the last offsets are handled.
+	th.wg.Wait()
+	th.oom.Finish(time.Now().Unix())
+	th.OffsetHistory.Forget(th.cluster, th.topic)
+	th.State.RemoveTopic(th.cluster, th.topic)
+
+	// a removed topic is deleted from map by maintainer already,
+	// it may be added back by maintainer if it's back to Burrow.
+	if !removed {
+		// snm.DeregisterChild(cluster, topic)
+		// this can be deadlock in some extreme cases.
+		th.ClusterTopicMap.SetLock(th.cluster)
+		delete(th.ClusterTopicMap.GetChild(th.cluster, nil).(map[string]interface{}), th.topic)
+		th.ClusterTopicMap.ReleaseLock(th.cluster)
+	}
 
-	th.Logger.Warn("Topic is invalid, will stop handler",
+	th.Logger.Warn("Topic is gone, will stop handler",
 		zap.String("topic", th.topic),
 		zap.String("cluster", th.cluster),
 		zap.Int64("timestamp", time.Now().Unix()),
 	)
 }
 
-// Stop is a general stop
+// Stop waits until handler exits, its context should be cancelled before.
 func (th *TopicHandler) Stop() error {
+	<-th.doneChannel
 	return nil
 }
 
 func (th *TopicHandler) handleTopicOffset(topicOffset protocol.TopicOffset, prefix string, timestamp int64) {
-	topicTag := "topic=" + th.topic
 	for id, offset := range topicOffset.Offsets {
-		timeString := strconv.FormatInt(timestamp, 10)
-		partitionIDTag := "partitionId=" + strconv.Itoa(id)
+		tags := map[string]string{
+			"topic":       th.topic,
+			"partitionId": strconv.Itoa(id),
+		}
 		th.oom.Update(th.topic+":"+strconv.Itoa(id), offset, timestamp)
-		th.ProduceQueue <- combineInfo([]string{prefix, strconv.Itoa(id), "offset"},
-			[]string{strconv.Itoa(offset), timeString, th.postfix, topicTag, partitionIDTag})
+		th.OffsetHistory.Record(th.cluster, th.topic, id, offset, timestamp)
+		if !th.History.RecordTopicOffset(th.cluster, th.topic, id, offset, timestamp) {
+			th.CountService.Increase("exception.lagHistoryFull", th.cluster)
+		}
+		th.ProduceQueue <- protocol.NewMetric([]string{prefix, strconv.Itoa(id), "offset"},
+			float64(offset), timestamp, tags)
 	}
+	th.State.UpdateTopic(th.cluster, th.topic, topicOffset.Offsets, timestamp, th.oom.GetRateCalculator())
 }
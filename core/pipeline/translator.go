@@ -1,174 +1,577 @@
 package pipeline
 
 import (
+	"math"
+	"sort"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/harbinzhang/goRainbow/core/alert"
 	"github.com/harbinzhang/goRainbow/core/module"
 	"github.com/harbinzhang/goRainbow/core/protocol"
 	"github.com/harbinzhang/goRainbow/core/util"
 )
 
-// Translator for message translate from struct to string
-type Translator struct {
-	LagQueue     <-chan protocol.LagInfo
-	ProduceQueue chan<- string
-	CountService *module.CountService
-	Logger       *zap.Logger
+// EmissionPolicy decides when Translator sends totalLag and partition metrics.
+type EmissionPolicy string
+
+const (
+	// EmitAlways sends all metrics every poll.
+	EmitAlways EmissionPolicy = "always"
+	// EmitOnChange sends a metric when it changes, an unchanged one is sent every 2 polls as heartbeat.
+	EmitOnChange EmissionPolicy = "onChange"
+	// EmitZeroBracketed sends a partition once when its lag gets 0 and stops sending it until lag is back,
+	// then the previous 0 is sent before the new lag, so every lag period starts and ends with 0.
+	EmitZeroBracketed EmissionPolicy = "zeroBracketed"
+)
 
-	prefix  string
-	env     string
-	postfix string
-	oom     *module.OwnerOffsetMoveHelper
+// Translator for message translate from LagInfo to metrics
+type Translator struct {
+	LagQueue      <-chan protocol.LagInfo
+	ProduceQueue  chan<- protocol.Metric
+	CountService  *module.CountService
+	OffsetHistory *module.OffsetHistory
+	Emission      EmissionPolicy
+	StallWindow   time.Duration
+	State         *module.StateStore
+	History       *module.LagHistory
+	Alerts        *alert.Engine
+	// Tags are added to all its metrics, e.g. input=push.
+	Tags   map[string]string
+	Logger *zap.Logger
+
+	prefix      string
+	env         string
+	finished    bool
+	tsm         *util.TwinStateMachine
+	ownership   *util.OwnershipTracker
+	stall       *module.StallDetector
+	oom         *module.OwnerOffsetMoveHelper
+	wg          sync.WaitGroup
+	doneChannel chan struct{}
 }
 
 // Init is a general init
 func (t *Translator) Init(prefix string, env string) {
 	t.prefix = prefix
 	t.env = env
+	t.doneChannel = make(chan struct{})
 
-	contextProvider := util.ContextProvider{}
-	contextProvider.Init()
-	t.postfix = contextProvider.GetPostfix()
+	if t.Emission == "" {
+		t.Emission = EmitAlways
+	}
+	t.tsm = &util.TwinStateMachine{}
+	t.tsm.Init()
+	t.ownership = &util.OwnershipTracker{}
+	t.ownership.Init()
+	t.stall = &module.StallDetector{Window: t.StallWindow, OffsetHistory: t.OffsetHistory}
+	t.stall.Init()
 
 	// Prepare consumer side offset change per minute
 	t.oom = &module.OwnerOffsetMoveHelper{
+		Tags:         t.Tags,
 		CountService: t.CountService,
 		ProduceQueue: t.ProduceQueue,
 		Logger: util.GetLogger().With(
 			zap.String("module", "consumerOwnerOffsetMoveHelper"),
 		),
 	}
-	t.oom.Init(t.prefix, t.postfix, t.env, "hosts")
+	t.oom.Init(t.prefix, t.env, "hosts")
 }
 
-// Start is a general start
+// Start is a general start, it exits when LagQueue is closed and all LagInfo are translated.
 func (t *Translator) Start() {
 	defer t.Logger.Sync()
+	defer close(t.doneChannel)
 
+	var lastLag *protocol.LagStatus
+	var previousTimestamp int64
 	for lagInfo := range t.LagQueue {
-		go t.parseInfo(lagInfo)
+		lagInfo := lagInfo
+		lastLag = &lagInfo.Lag
+		// emission is decided in order of LagInfo, parse goroutines may run in any order.
+		emission := t.decideEmission(t.Emission, lagInfo.Lag, previousTimestamp)
+		emission.consumption, emission.groupConsumption = t.stall.Classify(t.env, lagInfo.Lag.Status.Partitions, lagInfo.Timestamp)
+		previousTimestamp = lagInfo.Timestamp
+		t.State.UpdateConsumer(lagInfo, t.oom.GetRateCalculator())
+		t.Alerts.Observe(lagInfo)
+		t.goParse(func() { t.parseInfo(lagInfo, emission) })
 	}
 
+	t.wg.Wait()
+	if t.finished && lastLag != nil {
+		// after all LagInfo are translated, so 0 is the last one.
+		final := protocol.LagInfo{
+			Lag:       zeroLag(*lastLag),
+			Timestamp: time.Now().Unix(),
+		}
+		emission := t.decideEmission(EmitAlways, final.Lag, previousTimestamp)
+		emission.ownership = t.ownership.Finish()
+		t.goParse(func() { t.parseInfo(final, emission) })
+		t.wg.Wait()
+	}
+	if t.finished {
+		t.oom.Finish(time.Now().Unix())
+		if lastLag != nil {
+			t.State.RemoveConsumer(t.env, lastLag.Status.Group)
+			t.Alerts.Forget(t.env, lastLag.Status.Group, time.Now().Unix())
+		}
+	}
+	t.oom.Stop()
+
 	t.Logger.Warn("translator exit",
 		zap.String("prefix", t.prefix),
 		zap.Int64("timestamp", time.Now().Unix()),
 	)
 }
 
-// Stop is a general stop
+// Finish marks the consumer is gone, translator ends its lags and offset rates with 0 before exit.
+// It should be called before LagQueue is closed.
+func (t *Translator) Finish() {
+	t.finished = true
+}
+
+// Stop waits until translator exits, LagQueue should be closed before.
 func (t *Translator) Stop() error {
+	<-t.doneChannel
 	return nil
 }
 
-func (t *Translator) parseInfo(lagInfo protocol.LagInfo) {
+// goParse leaves heavy workload to goroutine, and tracks it for Stop.
+func (t *Translator) goParse(parse func()) {
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		defer reportPanic(t.CountService, t.Logger, t.env)
+		parse()
+	}()
+}
+
+// lagEmission is what to send for a LagInfo, decided by EmissionPolicy.
+// ownership and consumption states of consumer group are always sent.
+type lagEmission struct {
+	totalLag          bool
+	topics            map[string]bool
+	partitions        []partitionEmission
+	previousTimestamp int64
+	ownership         util.Ownership
+	consumption       []module.ConsumptionState
+	groupConsumption  module.ConsumptionState
+}
+
+type partitionEmission struct {
+	current bool
+	// previous is to send 0 lag at previousTimestamp before current lag.
+	previous bool
+}
+
+// decideEmission runs lags through TwinStateMachine and OwnershipTracker, it should be called in order of LagInfo.
+func (t *Translator) decideEmission(policy EmissionPolicy, lag protocol.LagStatus, previousTimestamp int64) lagEmission {
+	partitions := lag.Status.Partitions
+	emission := lagEmission{
+		totalLag:          true,
+		topics:            make(map[string]bool),
+		partitions:        make([]partitionEmission, len(partitions)),
+		previousTimestamp: previousTimestamp,
+		ownership:         t.ownership.Update(partitions),
+	}
+
+	topicLags := make(map[string]int)
+	for _, partition := range partitions {
+		topicLags[partition.Topic] += partition.CurrentLag
+	}
+
+	switch policy {
+	case EmitOnChange:
+		emission.totalLag = t.tsm.Put("totalLag", lag.Status.Totallag)
+		for topic, topicLag := range topicLags {
+			emission.topics[topic] = t.tsm.Put("topicLag:"+topic, topicLag)
+		}
+		for i, partition := range partitions {
+			emission.partitions[i].current = t.tsm.Put(partitionKey(partition), partition.CurrentLag)
+		}
+	case EmitZeroBracketed:
+		emission.totalLag = t.tsm.Put("totalLag", lag.Status.Totallag)
+		for topic, topicLag := range topicLags {
+			emission.topics[topic] = t.tsm.Put("topicLag:"+topic, topicLag)
+		}
+		for i, partition := range partitions {
+			current, previous := t.tsm.PartitionPut(partitionKey(partition), partition.CurrentLag)
+			emission.partitions[i] = partitionEmission{
+				current:  current,
+				previous: previous && previousTimestamp != 0,
+			}
+		}
+	default:
+		for topic := range topicLags {
+			emission.topics[topic] = true
+		}
+		for i := range partitions {
+			emission.partitions[i].current = true
+		}
+	}
+	return emission
+}
+
+func partitionKey(partition protocol.Partition) string {
+	return partition.Topic + ":" + strconv.Itoa(partition.Partition)
+}
+
+func (t *Translator) parseInfo(lagInfo protocol.LagInfo, emission lagEmission) {
 	// lag is 0 or non-zero.
 	// parse it into lower level(partitions, maxlag).
 	cluster := lagInfo.Lag.Status.Cluster
 	group := lagInfo.Lag.Status.Group
-	totalLag := strconv.Itoa(lagInfo.Lag.Status.Totallag)
-	timestamp := strconv.FormatInt(lagInfo.Timestamp, 10)
+	totalLag := lagInfo.Lag.Status.Totallag
+	timestamp := lagInfo.Timestamp
 
-	envTag := "env=" + cluster
-	consumerTag := "consumer=" + group
-	newPostfix := strings.Join([]string{timestamp, t.postfix, envTag, consumerTag}, " ")
+	tags := map[string]string{
+		"env":      cluster,
+		"consumer": group,
+	}
+	for k, v := range t.Tags {
+		tags[k] = v
+	}
 
 	t.CountService.Increase("totalMessage", cluster)
 
-	t.ProduceQueue <- combineInfo([]string{t.prefix, "totalLag"}, []string{totalLag, newPostfix})
+	if emission.totalLag {
+		t.ProduceQueue <- protocol.NewMetric([]string{t.prefix, "totalLag"}, float64(totalLag), timestamp, tags)
+	}
 
-	if totalLag != "0" {
+	if totalLag != 0 {
 		t.CountService.Increase("validMessage", cluster)
 	}
 
-	go t.parsePartitionInfo(lagInfo.Lag.Status.Partitions, newPostfix, lagInfo.Timestamp)
-	go t.parseMaxLagInfo(lagInfo.Lag.Status.Maxlag, newPostfix)
+	t.recordHistory(lagInfo)
+
+	t.goParse(func() { t.parsePartitionInfo(lagInfo.Lag.Status.Partitions, emission, tags, timestamp) })
+	t.goParse(func() { t.parseMaxLagInfo(lagInfo.Lag.Status.Maxlag, tags, timestamp) })
+	t.goParse(func() { t.parseTopicLag(lagInfo.Lag.Status.Partitions, emission, tags, timestamp) })
+	t.goParse(func() { t.parseOwnership(emission.ownership, tags, timestamp) })
+	if emission.consumption != nil {
+		t.goParse(func() { t.parseConsumption(lagInfo.Lag.Status.Partitions, emission, tags, timestamp) })
+	}
+	if t.OffsetHistory != nil {
+		t.goParse(func() { t.parseTimeLag(lagInfo.Lag.Status.Partitions, emission, tags, timestamp) })
+	}
 }
 
-func (t *Translator) parsePartitionInfo(partitions []protocol.Partition, postfix string, timestamp int64) {
+// recordHistory keeps total lag and all partition lags in LagHistory, whatever the emission is.
+func (t *Translator) recordHistory(lagInfo protocol.LagInfo) {
+	status := lagInfo.Lag.Status
+	recorded := t.History.RecordTotalLag(status.Cluster, status.Group, status.Totallag, lagInfo.Timestamp)
+	for _, partition := range status.Partitions {
+		recorded = t.History.RecordPartitionLag(status.Cluster, status.Group, partition.Topic, partition.Partition,
+			partition.CurrentLag, lagInfo.Timestamp) && recorded
+	}
+	if !recorded {
+		t.CountService.Increase("exception.lagHistoryFull", status.Cluster)
+	}
+}
+
+// parseTopicLag sends lag of the consumer group on each topic: sum, max, p50 and p95 across partitions,
+// and how many partitions have lag. Sum and max are topicTotalLag/topicMaxLag,
+// families are shared by name leaf, so totalLag/maxLag would be summed with the group ones.
+func (t *Translator) parseTopicLag(partitions []protocol.Partition, emission lagEmission, tags map[string]string, timestamp int64) {
+	topicLags := make(map[string][]int)
 	for _, partition := range partitions {
+		topicLags[partition.Topic] = append(topicLags[partition.Topic], partition.CurrentLag)
+	}
+
+	for topic, lags := range topicLags {
+		if !emission.topics[topic] {
+			continue
+		}
+		sort.Ints(lags)
+		var sum, lagging int
+		for _, lag := range lags {
+			sum += lag
+			if lag > 0 {
+				lagging++
+			}
+		}
+
+		topicTags := protocol.WithTag(tags, "topic", topic)
+		t.ProduceQueue <- protocol.NewMetric([]string{t.prefix, topic, "topicTotalLag"}, float64(sum), timestamp, topicTags)
+		t.ProduceQueue <- protocol.NewMetric([]string{t.prefix, topic, "topicMaxLag"}, float64(lags[len(lags)-1]), timestamp, topicTags)
+		t.ProduceQueue <- protocol.NewMetric([]string{t.prefix, topic, "p50Lag"}, float64(percentile(lags, 0.5)), timestamp, topicTags)
+		t.ProduceQueue <- protocol.NewMetric([]string{t.prefix, topic, "p95Lag"}, float64(percentile(lags, 0.95)), timestamp, topicTags)
+		t.ProduceQueue <- protocol.NewMetric([]string{t.prefix, topic, "laggingPartitions"}, float64(lagging), timestamp, topicTags)
+	}
+}
+
+// percentile is nearest-rank percentile of sorted values.
+func percentile(sorted []int, p float64) int {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// parseTimeLag sends how many seconds each partition is behind the head,
+// and max/total of them for the consumer group.
+// It needs head offset history from topic handlers, partitions without history or committed offset are skipped.
+func (t *Translator) parseTimeLag(partitions []protocol.Partition, emission lagEmission, tags map[string]string, timestamp int64) {
+	var maxTimeLag, totalTimeLag int64
+	estimated := 0
+	for i, partition := range partitions {
+		if !partition.HasCommittedOffset() {
+			continue
+		}
+		var timeLag int64
+		if partition.CurrentLag > 0 {
+			var ok bool
+			timeLag, ok = t.OffsetHistory.SecondsBehind(t.env, partition.Topic, partition.Partition, partition.End.Offset, timestamp)
+			if !ok {
+				continue
+			}
+		}
+		estimated++
+		if timeLag > maxTimeLag {
+			maxTimeLag = timeLag
+		}
+		totalTimeLag += timeLag
+		if !emission.partitions[i].current {
+			continue
+		}
+
+		partitionID := strconv.Itoa(partition.Partition)
+		partitionTags := protocol.WithTag(tags, "topic", partition.Topic)
+		partitionTags["partition"] = partitionID
+		t.ProduceQueue <- protocol.NewMetric([]string{t.prefix, partition.Topic, partitionID, "timeLag"}, float64(timeLag), timestamp, partitionTags)
+	}
+	if estimated == 0 {
+		return
+	}
+	t.ProduceQueue <- protocol.NewMetric([]string{t.prefix, "maxTimeLag"}, float64(maxTimeLag), timestamp, tags)
+	t.ProduceQueue <- protocol.NewMetric([]string{t.prefix, "totalTimeLag"}, float64(totalTimeLag), timestamp, tags)
+}
+
+func (t *Translator) parsePartitionInfo(partitions []protocol.Partition, emission lagEmission, tags map[string]string, timestamp int64) {
+	for i, partition := range partitions {
 
 		partitionID := strconv.Itoa(partition.Partition)
 		currentLag := partition.CurrentLag
 
 		owner := partition.Owner
-		// it happens when info is invalid, skip this info.
-		if owner == "" {
-			t.Logger.Warn("owner invalid",
-				zap.String("prefix", t.prefix),
-				zap.Int("currentLag", currentLag),
-				zap.String("partitionID", partitionID),
-				zap.Int64("timestamp", time.Now().Unix()),
-			)
-			t.CountService.Increase("exception.ownerInvalid", t.env)
-			return
+		topic := partition.Topic
+
+		startOffset := partition.Start.Offset
+		// startOffsetTimestamp := partition.Start.Timestamp
+		endOffset := partition.End.Offset
+		// endOffsetTimestamp := partition.End.Timestamp
+
+		partitionTags := protocol.WithTag(tags, "topic", topic)
+		partitionTags["partition"] = partitionID
+		// an unassigned partition, e.g. in rebalance, is sent without owner and counted by parseOwnership.
+		if owner != "" {
+			if partition.HasCommittedOffset() {
+				t.oom.Update(module.RateKey(owner, topic, partition.Partition), partition.End.Offset, timestamp)
+			}
+			partitionTags["owner"] = owner
 		}
 
-		topic := partition.Topic
+		// previous "lag=0" makes a lag period start with 0.
+		if emission.partitions[i].previous {
+			t.ProduceQueue <- protocol.NewMetric([]string{t.prefix, topic, partitionID, "Lag"}, 0, emission.previousTimestamp, partitionTags)
+		}
+		if !emission.partitions[i].current {
+			continue
+		}
+
+		t.ProduceQueue <- protocol.NewMetric([]string{t.prefix, topic, partitionID, "Lag"}, float64(currentLag), timestamp, partitionTags)
+		if !partition.HasCommittedOffset() {
+			continue
+		}
+		t.ProduceQueue <- protocol.NewMetric([]string{t.prefix, topic, partitionID, "startOffset"}, float64(startOffset), timestamp, partitionTags)
+		t.ProduceQueue <- protocol.NewMetric([]string{t.prefix, topic, partitionID, "endOffset"}, float64(endOffset), timestamp, partitionTags)
+	}
+
+	// after offsets are updated, so host throughput includes this poll.
+	t.parseHosts(partitions, emission.ownership.Owners, tags, timestamp)
+	t.parseETA(partitions, emission, tags, timestamp)
+}
 
-		startOffset := strconv.Itoa(partition.Start.Offset)
-		// startOffsetTimestamp := strconv.FormatInt(partition.Start.Timestamp, 10)
-		endOffset := strconv.Itoa(partition.End.Offset)
-		// endOffsetTimestamp := strconv.FormatInt(partition.End.Timestamp, 10)
-
-		t.oom.Update(owner+":"+partitionID, partition.End.Offset, timestamp)
-
-		topicTag := "topic=" + topic
-		partitionTag := "partition=" + partitionID
-		ownerTag := "owner=" + owner
-
-		// This part code doesn't work.
-		// ie. send a 30s before timestamp doesn't work in wavefront.
-		// The goal is to send previous "lag=0" to make metric look better.
-		// if shouldSendPreviousLag {
-		// 	previousTimestamp, err := strconv.ParseInt(strings.Split(postfix, " ")[0], 10, 64)
-		// 	previousTimestamp -= 60
-		// 	if err != nil {
-		// 		log.Println("ERROR: Cannot parse previousTimestamp in shouldSendPreviousLag.")
-		// 		return
-		// 	}
-		// 	produceQueue <- combineInfo([]string{prefix, topic, partitionID, "Lag"}, []string{"0", strconv.FormatInt(previousTimestamp, 10), postfix, topicTag, partitionTag, ownerTag})
-		// }
-
-		t.ProduceQueue <- combineInfo([]string{t.prefix, topic, partitionID, "Lag"}, []string{strconv.Itoa(currentLag), postfix, topicTag, partitionTag, ownerTag})
-		t.ProduceQueue <- combineInfo([]string{t.prefix, topic, partitionID, "startOffset"}, []string{startOffset, postfix, topicTag, partitionTag, ownerTag})
-		t.ProduceQueue <- combineInfo([]string{t.prefix, topic, partitionID, "endOffset"}, []string{endOffset, postfix, topicTag, partitionTag, ownerTag})
-	}
-}
-
-func (t *Translator) parseMaxLagInfo(maxLag protocol.MaxLag, postfix string) {
-	// tags: owner
-	// metrics: partitionID, currentLag, startOffset, endOffset, topic
-
-	owner := maxLag.Owner
-	// it happens when info is invalid, skip this info.
-	if owner == "" {
-		t.Logger.Warn("owner invalid",
-			zap.String("prefix", t.prefix),
-			zap.Int("maxLagPartitionID", maxLag.Partition),
-			zap.Int64("timestamp", time.Now().Unix()),
-		)
-		t.CountService.Increase("exception.ownerInvalid", t.env)
+// parseETA sends estimated seconds until lag gets 0 per partition and per group, module.ETANever(-1) if never.
+// Group ETA is sent only if ETA of all partitions with lag are known.
+func (t *Translator) parseETA(partitions []protocol.Partition, emission lagEmission, tags map[string]string, timestamp int64) {
+	rates := module.RatesByKey(t.oom.GetRateCalculator())
+	etas := make([]int64, 0, len(partitions))
+	for i, partition := range partitions {
+		eta, ok := module.PartitionETA(t.env, partition, rates, t.OffsetHistory)
+		if !ok {
+			continue
+		}
+		etas = append(etas, eta)
+		if !emission.partitions[i].current {
+			continue
+		}
+		partitionID := strconv.Itoa(partition.Partition)
+		partitionTags := protocol.WithTag(tags, "topic", partition.Topic)
+		partitionTags["partition"] = partitionID
+		t.ProduceQueue <- protocol.NewMetric([]string{t.prefix, partition.Topic, partitionID, "eta"}, float64(eta), timestamp, partitionTags)
+	}
+	if len(etas) == len(partitions) && len(partitions) > 0 {
+		t.ProduceQueue <- protocol.NewMetric([]string{t.prefix, "eta"}, float64(module.GroupETA(etas)), timestamp, tags)
+	}
+}
+
+// parseHosts rolls partitions up to consumer hosts: records per minute and lag held by each host,
+// and skew of the group, which is max/mean of hosts holding partitions, 1 means balanced.
+// Partitions held by each host are sent by parseOwnership. Hosts gone since last poll get 0.
+func (t *Translator) parseHosts(partitions []protocol.Partition, owners map[string]int, tags map[string]string, timestamp int64) {
+	if len(owners) == 0 {
 		return
 	}
-	ownerTag := "owner=" + owner
+	rates := module.RatesByKey(t.oom.GetRateCalculator())
+	lags := make(map[string]int)
+	records := make(map[string]float64)
+	for _, partition := range partitions {
+		if partition.Owner == "" {
+			continue
+		}
+		lags[partition.Owner] += partition.CurrentLag
+		// keyed by topic, owner and partition, so a moved partition is not counted for the previous host,
+		// and the same partition id of another topic is not mixed up.
+		if rate, ok := rates[module.RateKey(partition.Owner, partition.Topic, partition.Partition)]; ok && rate.Valid {
+			records[partition.Owner] += rate.PerMinute
+		}
+	}
+
+	var active int
+	var maxRecords, sumRecords, maxLag, sumLag float64
+	for owner, count := range owners {
+		hostTags := protocol.WithTag(tags, "owner", owner)
+		if count == 0 {
+			t.ProduceQueue <- protocol.NewMetric([]string{t.prefix, "hostRecords"}, 0, timestamp, hostTags)
+			t.ProduceQueue <- protocol.NewMetric([]string{t.prefix, "hostLag"}, 0, timestamp, hostTags)
+			continue
+		}
+		t.ProduceQueue <- protocol.NewMetric([]string{t.prefix, "hostRecords"}, records[owner], timestamp, hostTags)
+		t.ProduceQueue <- protocol.NewMetric([]string{t.prefix, "hostLag"}, float64(lags[owner]), timestamp, hostTags)
+
+		active++
+		sumRecords += records[owner]
+		sumLag += float64(lags[owner])
+		maxRecords = math.Max(maxRecords, records[owner])
+		maxLag = math.Max(maxLag, float64(lags[owner]))
+	}
+	if active == 0 {
+		return
+	}
+	t.ProduceQueue <- protocol.NewMetric([]string{t.prefix, "throughputSkew"}, skew(maxRecords, sumRecords, active), timestamp, tags)
+	t.ProduceQueue <- protocol.NewMetric([]string{t.prefix, "lagSkew"}, skew(maxLag, sumLag, active), timestamp, tags)
+}
+
+// skew is max/mean, it's 1 if all are 0.
+func skew(max float64, sum float64, n int) float64 {
+	if sum == 0 {
+		return 1
+	}
+	return max / (sum / float64(n))
+}
+
+func (t *Translator) parseMaxLagInfo(maxLag protocol.MaxLag, tags map[string]string, timestamp int64) {
+	// tags: owner, topic
+	// metrics: partitionID, currentLag, startOffset, endOffset
+
+	maxLagTags := protocol.WithTag(tags, "owner", maxLag.Owner)
+	// max lag partition may be unassigned.
+	if maxLag.Owner == "" {
+		delete(maxLagTags, "owner")
+	}
+	// topic used to be a metric "maxLagTopic", but a metric value has to be a number.
+	if maxLag.Topic != "" {
+		maxLagTags["topic"] = maxLag.Topic
+	}
 
 	// MaxLagPartition Level handle
-	maxLagMap := make(map[string]string)
-	maxLagMap["maxLagmaxLagPartitionID"] = strconv.Itoa(maxLag.Partition)
-	maxLagMap["maxLagCurrentLag"] = strconv.Itoa(maxLag.CurrentLag)
-	maxLagMap["maxLagStartOffset"] = strconv.Itoa(maxLag.Start.Offset)
-	maxLagMap["maxLagEndOffset"] = strconv.Itoa(maxLag.End.Offset)
-	maxLagMap["maxLagTopic"] = maxLag.Topic
+	maxLagMap := make(map[string]int)
+	maxLagMap["maxLagmaxLagPartitionID"] = maxLag.Partition
+	maxLagMap["maxLagCurrentLag"] = maxLag.CurrentLag
+	if !maxLag.OffsetsUnknown {
+		maxLagMap["maxLagStartOffset"] = maxLag.Start.Offset
+		maxLagMap["maxLagEndOffset"] = maxLag.End.Offset
+	}
 
 	for key, value := range maxLagMap {
-		t.ProduceQueue <- combineInfo([]string{t.prefix, key}, []string{value, postfix, ownerTag})
+		t.ProduceQueue <- protocol.NewMetric([]string{t.prefix, key}, float64(value), timestamp, maxLagTags)
 	}
 }
 
-func combineInfo(prefix []string, postfix []string) string {
-	return strings.Join(prefix, ".") + " " + strings.Join(postfix, " ")
+// parseOwnership sends partitions per owner and unassigned partitions of the consumer group.
+// On rebalance, it sends how many partitions moved, and an event per moved partition with previous and new owner.
+func (t *Translator) parseOwnership(ownership util.Ownership, tags map[string]string, timestamp int64) {
+	t.ProduceQueue <- protocol.NewMetric([]string{t.prefix, "unassignedPartitions"}, float64(ownership.Unassigned), timestamp, tags)
+	for owner, count := range ownership.Owners {
+		t.ProduceQueue <- protocol.NewMetric([]string{t.prefix, "ownerPartitions"}, float64(count), timestamp, protocol.WithTag(tags, "owner", owner))
+	}
+	if len(ownership.Moves) == 0 {
+		return
+	}
+
+	// rebalances per minute of cluster are reported by CountService.
+	t.CountService.Increase("rebalance", t.env)
+	t.Logger.Info("rebalance found",
+		zap.String("prefix", t.prefix),
+		zap.Int("partitionsMoved", len(ownership.Moves)),
+		zap.Int64("timestamp", time.Now().Unix()),
+	)
+	t.ProduceQueue <- protocol.NewMetric([]string{t.prefix, "rebalance"}, float64(len(ownership.Moves)), timestamp, tags)
+	for _, move := range ownership.Moves {
+		partitionID := strconv.Itoa(move.Partition)
+		moveTags := protocol.WithTag(tags, "topic", move.Topic)
+		moveTags["partition"] = partitionID
+		moveTags["previousOwner"] = move.Previous
+		moveTags["owner"] = move.Current
+		t.ProduceQueue <- protocol.NewMetric([]string{t.prefix, move.Topic, partitionID, "ownerMoved"}, 1, timestamp, moveTags)
+	}
+}
+
+// parseConsumption sends consumption state of the consumer group and its partitions, and partitions per state.
+// A state is sent as its code, with tag "state". Partitions without committed offset have no state.
+func (t *Translator) parseConsumption(partitions []protocol.Partition, emission lagEmission, tags map[string]string, timestamp int64) {
+	group := emission.groupConsumption
+	t.ProduceQueue <- protocol.NewMetric([]string{t.prefix, "consumptionState"}, float64(group.Code()), timestamp, protocol.WithTag(tags, "state", string(group)))
+
+	counts := make(map[module.ConsumptionState]int)
+	for i, partition := range partitions {
+		state := emission.consumption[i]
+		if state == module.ConsumptionUnknown {
+			continue
+		}
+		counts[state]++
+		if !emission.partitions[i].current {
+			continue
+		}
+		partitionID := strconv.Itoa(partition.Partition)
+		partitionTags := protocol.WithTag(tags, "topic", partition.Topic)
+		partitionTags["partition"] = partitionID
+		partitionTags["state"] = string(state)
+		t.ProduceQueue <- protocol.NewMetric([]string{t.prefix, partition.Topic, partitionID, "consumptionState"}, float64(state.Code()), timestamp, partitionTags)
+	}
+	for _, state := range module.ConsumptionStates {
+		t.ProduceQueue <- protocol.NewMetric([]string{t.prefix, "partitionsByState"}, float64(counts[state]), timestamp, protocol.WithTag(tags, "state", string(state)))
+	}
+	if counts[module.ConsumptionStalled] > 0 {
+		t.CountService.Increase("stalledConsumer", t.env)
+	}
+}
+
+// zeroLag is a copy of lag with all lags set to 0, offsets are kept.
+func zeroLag(lag protocol.LagStatus) protocol.LagStatus {
+	lag.Status.Totallag = 0
+	lag.Status.Maxlag.CurrentLag = 0
+	partitions := make([]protocol.Partition, len(lag.Status.Partitions))
+	for i, partition := range lag.Status.Partitions {
+		partition.CurrentLag = 0
+		partitions[i] = partition
+	}
+	lag.Status.Partitions = partitions
+	return lag
 }
@@ -10,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
 
 	"github.com/harbinzhang/goRainbow/core/module"
@@ -18,11 +19,20 @@ import (
 )
 
 func TestMain(m *testing.M) {
-	PrepareLogger()
 	os.Setenv("configPath", "../../config/config.json")
+	os.Exit(m.Run())
+}
+
+// skipLongTest skips tests which simulate Burrow for minutes,
+// set RAINBOW_LONG_TEST to run them.
+func skipLongTest(t *testing.T) {
+	if os.Getenv("RAINBOW_LONG_TEST") == "" {
+		t.Skip("set RAINBOW_LONG_TEST to run it")
+	}
 }
 
 func TestWithProducer(t *testing.T) {
+	skipLongTest(t)
 
 	contextProvider := util.ContextProvider{}
 	contextProvider.Init()
@@ -44,6 +54,7 @@ func TestWithProducer(t *testing.T) {
 }
 
 func TestStartFrom0(t *testing.T) {
+	skipLongTest(t)
 
 	contextProvider := util.ContextProvider{}
 	contextProvider.Init()
@@ -146,14 +157,15 @@ func TestMany(t *testing.T) {
 // 	assert.Equal(t, strconv.FormatInt(time.Now().Unix(), 10), getEpochTime("0001-01-01 00:00:00"), "Not passed")
 // }
 
-func preparePipeline() (chan<- protocol.LagInfo, chan string) {
+func preparePipeline() (chan<- protocol.LagInfo, chan protocol.Metric) {
 	// prepare
 	lagInfoQueue := make(chan protocol.LagInfo, 1000)
-	produceQueue := make(chan string, 9000)
+	produceQueue := make(chan protocol.Metric, 9000)
 
 	countService := &module.CountService{
 		ProduceQueue: produceQueue,
 	}
+	countService.Start()
 
 	translator := &Translator{
 		LagQueue:     lagInfoQueue,
@@ -205,3 +217,368 @@ func prepareLag100Consumers() [100]protocol.LagInfo {
 
 	return res
 }
+
+func TestParseInfo(t *testing.T) {
+	lagInfoQueue, produceQueue := preparePipeline()
+	pull := prepareLag()
+
+	lagInfoQueue <- pull
+
+	metrics := make(map[string]protocol.Metric)
+	expected := 1 + 3*len(pull.Lag.Status.Partitions) + 4
+	for i := 0; i < expected; i++ {
+		select {
+		case metric := <-produceQueue:
+			metrics[metric.Name] = metric
+		case <-time.After(time.Second):
+			t.Fatalf("expected %d metrics, got %d", expected, i)
+		}
+	}
+
+	totalLag := metrics["prefix.totalLag"]
+	assert.Equal(t, float64(pull.Lag.Status.Totallag), totalLag.Value, "totalLag not correct")
+	assert.Equal(t, map[string]string{"env": "test", "consumer": "console-consumer-0"}, totalLag.Tags, "totalLag tags not correct")
+
+	partition := pull.Lag.Status.Partitions[0]
+	lag := metrics["prefix."+partition.Topic+".0.Lag"]
+	assert.Equal(t, float64(partition.CurrentLag), lag.Value, "partition lag not correct")
+	assert.Equal(t, partition.Owner, lag.Tags["owner"], "partition owner not correct")
+	assert.Equal(t, "0", lag.Tags["partition"], "partition id not correct")
+	assert.Equal(t, pull.Timestamp, lag.Timestamp, "partition timestamp not correct")
+
+	close(lagInfoQueue)
+}
+
+func TestTranslatorStopDrains(t *testing.T) {
+	lagInfoQueue, produceQueue := preparePipeline()
+	pull := prepareLag()
+
+	lagInfoQueue <- pull
+	lagInfoQueue <- pull
+	close(lagInfoQueue)
+
+	// preparePipeline doesn't return translator, wait for queue instead.
+	// ownership metrics: unassignedPartitions and ownerPartitions per owner.
+	// consumption metrics: group and partition consumptionState, and partitionsByState per state.
+	// host metrics: hostRecords and hostLag per owner, throughputSkew and lagSkew.
+	// topic metrics: totalLag, maxLag, p50Lag, p95Lag and laggingPartitions per topic.
+	owners := make(map[string]bool)
+	topics := make(map[string]bool)
+	for _, partition := range pull.Lag.Status.Partitions {
+		owners[partition.Owner] = true
+		topics[partition.Topic] = true
+	}
+	expected := 2 * (1 + 3*len(pull.Lag.Status.Partitions) + 4 + 1 + len(owners) +
+		1 + len(pull.Lag.Status.Partitions) + len(module.ConsumptionStates) + 2*len(owners) + 2 + 5*len(topics))
+	deadline := time.Now().Add(time.Second)
+	for len(produceQueue) < expected && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, expected, len(produceQueue), "all metrics should be sent before translator exits")
+}
+
+func TestParseTimeLag(t *testing.T) {
+	produceQueue := make(chan protocol.Metric, 9000)
+	countService := &module.CountService{ProduceQueue: produceQueue}
+	countService.Start()
+
+	pull := prepareLag()
+	offsetHistory := &module.OffsetHistory{}
+	offsetHistory.Init()
+	// every partition is produced 1 message per second
+	for _, partition := range pull.Lag.Status.Partitions {
+		offsetHistory.Record("test", partition.Topic, partition.Partition, partition.End.Offset-100, pull.Timestamp-100-int64(partition.CurrentLag))
+		offsetHistory.Record("test", partition.Topic, partition.Partition, partition.End.Offset+partition.CurrentLag, pull.Timestamp)
+	}
+
+	translator := &Translator{
+		ProduceQueue:  produceQueue,
+		CountService:  countService,
+		OffsetHistory: offsetHistory,
+		Logger:        zap.NewNop(),
+	}
+	translator.Init("prefix", "test")
+	defer translator.oom.Stop()
+	emission := translator.decideEmission(EmitAlways, pull.Lag, 0)
+	translator.parseTimeLag(pull.Lag.Status.Partitions, emission, map[string]string{"env": "test"}, pull.Timestamp)
+
+	metrics := make(map[string]protocol.Metric)
+	for len(produceQueue) > 0 {
+		metric := <-produceQueue
+		metrics[metric.Name] = metric
+	}
+	assert.Equal(t, len(pull.Lag.Status.Partitions)+2, len(metrics))
+
+	var maxLag, totalLag int
+	for _, partition := range pull.Lag.Status.Partitions {
+		expected := 0
+		if partition.CurrentLag > 0 {
+			// message End.Offset is produced at pull.Timestamp-CurrentLag+1
+			expected = partition.CurrentLag - 1
+		}
+		name := "prefix." + partition.Topic + "." + strconv.Itoa(partition.Partition) + ".timeLag"
+		assert.Equal(t, float64(expected), metrics[name].Value, name+" not correct")
+		if expected > maxLag {
+			maxLag = expected
+		}
+		totalLag += expected
+	}
+	assert.Equal(t, float64(maxLag), metrics["prefix.maxTimeLag"].Value)
+	assert.Equal(t, float64(totalLag), metrics["prefix.totalTimeLag"].Value)
+}
+
+func TestDecideEmission(t *testing.T) {
+	translator := &Translator{Logger: zap.NewNop()}
+	translator.Init("prefix", "test")
+	defer translator.oom.Stop()
+
+	lag := func(lags ...int) protocol.LagStatus {
+		var status protocol.LagStatus
+		for i, l := range lags {
+			status.Status.Partitions = append(status.Status.Partitions, protocol.Partition{Topic: "topic", Partition: i, CurrentLag: l})
+			status.Status.Totallag += l
+		}
+		return status
+	}
+	currents := func(emission lagEmission) []bool {
+		var res []bool
+		for _, partition := range emission.partitions {
+			res = append(res, partition.current)
+		}
+		return res
+	}
+
+	// always
+	emission := translator.decideEmission(EmitAlways, lag(0, 0), 100)
+	assert.Equal(t, []bool{true, true}, currents(emission))
+
+	// zero-bracketed: idle partition 1 is silent after first 0, partition 0 gets previous 0 when lag is back.
+	translator.tsm.Init()
+	emission = translator.decideEmission(EmitZeroBracketed, lag(0, 0), 100)
+	assert.Equal(t, []bool{true, true}, currents(emission), "first lag is always sent")
+	emission = translator.decideEmission(EmitZeroBracketed, lag(0, 0), 130)
+	assert.Equal(t, []bool{false, false}, currents(emission), "idle partitions should be silent")
+	emission = translator.decideEmission(EmitZeroBracketed, lag(5, 0), 160)
+	assert.Equal(t, []bool{true, false}, currents(emission))
+	assert.True(t, emission.partitions[0].previous, "previous 0 should be sent before lag")
+	assert.Equal(t, int64(160), emission.previousTimestamp)
+
+	// on change: unchanged lag is sent every 2 polls
+	translator.tsm.Init()
+	var sent []bool
+	for i := 0; i < 4; i++ {
+		emission = translator.decideEmission(EmitOnChange, lag(3), 100)
+		sent = append(sent, emission.totalLag)
+	}
+	assert.Equal(t, []bool{true, false, true, false}, sent)
+}
+
+func TestParseInfoPreviousZero(t *testing.T) {
+	produceQueue := make(chan protocol.Metric, 9000)
+	countService := &module.CountService{ProduceQueue: produceQueue}
+	countService.Start()
+
+	translator := &Translator{
+		ProduceQueue: produceQueue,
+		CountService: countService,
+		Emission:     EmitZeroBracketed,
+		Logger:       zap.NewNop(),
+	}
+	translator.Init("prefix", "test")
+	defer translator.oom.Stop()
+
+	pull := prepareLag()
+	partition := pull.Lag.Status.Partitions[0]
+	name := "prefix." + partition.Topic + "." + strconv.Itoa(partition.Partition) + ".Lag"
+
+	idle := pull
+	idle.Lag = zeroLag(pull.Lag)
+	translator.decideEmission(translator.Emission, idle.Lag, 0)
+	translator.decideEmission(translator.Emission, idle.Lag, 100)
+	translator.parseInfo(pull, translator.decideEmission(translator.Emission, pull.Lag, 130))
+	translator.wg.Wait()
+
+	var lags []protocol.Metric
+	for len(produceQueue) > 0 {
+		if metric := <-produceQueue; metric.Name == name {
+			lags = append(lags, metric)
+		}
+	}
+	if assert.Equal(t, 2, len(lags), "previous 0 and current lag should be sent") {
+		assert.Equal(t, float64(0), lags[0].Value)
+		assert.Equal(t, int64(130), lags[0].Timestamp)
+		assert.Equal(t, float64(partition.CurrentLag), lags[1].Value)
+	}
+}
+
+func TestParseOwnership(t *testing.T) {
+	produceQueue := make(chan protocol.Metric, 9000)
+	countService := &module.CountService{ProduceQueue: produceQueue}
+	countService.Start()
+
+	translator := &Translator{
+		ProduceQueue: produceQueue,
+		CountService: countService,
+		Logger:       zap.NewNop(),
+	}
+	translator.Init("prefix", "test")
+	defer translator.oom.Stop()
+
+	pull := prepareLag()
+	translator.decideEmission(EmitAlways, pull.Lag, 0)
+
+	// partition 0 moves to a new owner, partition 1 is unassigned in rebalance.
+	moved := pull
+	moved.Lag.Status.Partitions = append([]protocol.Partition(nil), pull.Lag.Status.Partitions...)
+	moved.Lag.Status.Partitions[0].Owner = "new-owner"
+	moved.Lag.Status.Partitions[1].Owner = ""
+	translator.parseInfo(moved, translator.decideEmission(EmitAlways, moved.Lag, pull.Timestamp))
+	translator.wg.Wait()
+
+	metrics := make(map[string]protocol.Metric)
+	owners := make(map[string]float64)
+	for len(produceQueue) > 0 {
+		metric := <-produceQueue
+		metrics[metric.Name] = metric
+		if metric.Name == "prefix.ownerPartitions" {
+			owners[metric.Tags["owner"]] = metric.Value
+		}
+	}
+
+	partition := pull.Lag.Status.Partitions[0]
+	ownerMoved := metrics["prefix."+partition.Topic+".0.ownerMoved"]
+	assert.Equal(t, partition.Owner, ownerMoved.Tags["previousOwner"])
+	assert.Equal(t, "new-owner", ownerMoved.Tags["owner"])
+	assert.Equal(t, float64(1), metrics["prefix.rebalance"].Value, "unassigned partition is not a move")
+	assert.Equal(t, float64(1), metrics["prefix.unassignedPartitions"].Value)
+	assert.Equal(t, float64(1), owners["new-owner"])
+
+	unassigned := moved.Lag.Status.Partitions[1]
+	lag, ok := metrics["prefix."+unassigned.Topic+"."+strconv.Itoa(unassigned.Partition)+".Lag"]
+	assert.True(t, ok, "unassigned partition lag is still sent")
+	_, ok = lag.Tags["owner"]
+	assert.False(t, ok)
+	last := moved.Lag.Status.Partitions[len(moved.Lag.Status.Partitions)-1]
+	_, ok = metrics["prefix."+last.Topic+"."+strconv.Itoa(last.Partition)+".Lag"]
+	assert.True(t, ok, "partitions after an unassigned one are not dropped")
+}
+
+func TestParseHosts(t *testing.T) {
+	produceQueue := make(chan protocol.Metric, 9000)
+	translator := &Translator{
+		ProduceQueue: produceQueue,
+		Logger:       zap.NewNop(),
+	}
+	translator.Init("prefix", "test")
+	defer translator.oom.Stop()
+
+	partitions := []protocol.Partition{
+		{Topic: "topic", Partition: 0, Owner: "a", CurrentLag: 30},
+		{Topic: "topic", Partition: 1, Owner: "a", CurrentLag: 30},
+		{Topic: "topic", Partition: 2, Owner: "b", CurrentLag: 0},
+		{Topic: "other", Partition: 0, Owner: "d", CurrentLag: 0},
+		{Topic: "other", Partition: 1, Owner: "a", CurrentLag: 0},
+	}
+	// a consumes 60+60 records per minute of topic and 30 of other, b consumes 0, partition 0 was held by c.
+	// d consumes partition 0 of other only, at 240 per minute.
+	for key, offsets := range map[string][]int{"a:topic:0": {0, 60}, "a:topic:1": {0, 60}, "b:topic:2": {0, 0}, "c:topic:0": {0, 600},
+		"d:other:0": {0, 240}, "a:other:1": {0, 30}} {
+		translator.oom.Update(key, offsets[0], 1000)
+		translator.oom.Update(key, offsets[1], 1060)
+	}
+	translator.parseHosts(partitions, map[string]int{"a": 3, "b": 1, "c": 0, "d": 1}, map[string]string{"env": "test"}, 1060)
+
+	metrics := make(map[string]float64)
+	for len(produceQueue) > 0 {
+		metric := <-produceQueue
+		metrics[metric.Name+"."+metric.Tags["owner"]] = metric.Value
+	}
+	assert.Equal(t, float64(150), metrics["prefix.hostRecords.a"])
+	assert.Equal(t, float64(60), metrics["prefix.hostLag.a"])
+	assert.Equal(t, float64(240), metrics["prefix.hostRecords.d"], "partition 0 of topic is not counted for d")
+	assert.Equal(t, float64(0), metrics["prefix.hostRecords.c"], "rate of moved partition is not counted for previous host")
+	assert.Equal(t, float64(240)/130, metrics["prefix.throughputSkew."], "max 240, mean 130")
+	assert.Equal(t, float64(3), metrics["prefix.lagSkew."], "max 60, mean 20")
+}
+
+func TestParseETA(t *testing.T) {
+	produceQueue := make(chan protocol.Metric, 9000)
+	offsetHistory := &module.OffsetHistory{}
+	offsetHistory.Init()
+	translator := &Translator{
+		ProduceQueue:  produceQueue,
+		OffsetHistory: offsetHistory,
+		Logger:        zap.NewNop(),
+	}
+	translator.Init("prefix", "test")
+	defer translator.oom.Stop()
+
+	// partition 0 consumes 30/s and produces 10/s, partition 1 consumes as fast as produced.
+	partitions := []protocol.Partition{
+		{Topic: "topic", Partition: 0, Owner: "a", CurrentLag: 1000},
+		{Topic: "topic", Partition: 1, Owner: "a", CurrentLag: 10},
+	}
+	for i, rate := range []int{30, 10} {
+		translator.oom.Update(module.RateKey("a", "topic", i), 0, 1000)
+		translator.oom.Update(module.RateKey("a", "topic", i), rate*60, 1060)
+		offsetHistory.Record("test", "topic", i, 0, 1000)
+		offsetHistory.Record("test", "topic", i, 600, 1060)
+	}
+	emission := translator.decideEmission(EmitAlways, protocol.LagStatus{}, 0)
+	emission.partitions = []partitionEmission{{current: true}, {current: true}}
+	translator.parseETA(partitions, emission, map[string]string{"env": "test"}, 1060)
+
+	metrics := make(map[string]float64)
+	for len(produceQueue) > 0 {
+		metric := <-produceQueue
+		metrics[metric.Name] = metric.Value
+	}
+	assert.Equal(t, float64(50), metrics["prefix.topic.0.eta"])
+	assert.Equal(t, float64(module.ETANever), metrics["prefix.topic.1.eta"])
+	assert.Equal(t, float64(module.ETANever), metrics["prefix.eta"])
+}
+
+func TestParseTopicLag(t *testing.T) {
+	produceQueue := make(chan protocol.Metric, 9000)
+	translator := &Translator{
+		ProduceQueue: produceQueue,
+		Logger:       zap.NewNop(),
+	}
+	translator.Init("prefix", "test")
+	defer translator.oom.Stop()
+
+	var lag protocol.LagStatus
+	for i := 0; i < 20; i++ {
+		lag.Status.Partitions = append(lag.Status.Partitions, protocol.Partition{Topic: "a", Partition: i, CurrentLag: i * 10})
+	}
+	lag.Status.Partitions = append(lag.Status.Partitions, protocol.Partition{Topic: "b", Partition: 0, CurrentLag: 0})
+	translator.parseTopicLag(lag.Status.Partitions, translator.decideEmission(EmitAlways, lag, 0), map[string]string{"env": "test"}, 100)
+
+	metrics := make(map[string]protocol.Metric)
+	for len(produceQueue) > 0 {
+		metric := <-produceQueue
+		metrics[metric.Name] = metric
+	}
+	assert.Equal(t, float64(1900), metrics["prefix.a.topicTotalLag"].Value)
+	assert.Equal(t, "a", metrics["prefix.a.topicTotalLag"].Tags["topic"])
+	assert.Equal(t, float64(190), metrics["prefix.a.topicMaxLag"].Value)
+	assert.Equal(t, float64(90), metrics["prefix.a.p50Lag"].Value)
+	assert.Equal(t, float64(180), metrics["prefix.a.p95Lag"].Value)
+	assert.Equal(t, float64(19), metrics["prefix.a.laggingPartitions"].Value)
+	assert.Equal(t, float64(0), metrics["prefix.b.topicMaxLag"].Value)
+	for name, metric := range metrics {
+		assert.NotContains(t, []string{"totalLag", "maxLag"}, metric.Family(), name+" should not share the family of group lag")
+	}
+
+	// on change: unchanged topic lag is sent every 2 polls.
+	translator.tsm.Init()
+	translator.decideEmission(EmitOnChange, lag, 0)
+	emission := translator.decideEmission(EmitOnChange, lag, 0)
+	assert.False(t, emission.topics["a"])
+}
+
+func TestTranslatorRecoversParsePanic(t *testing.T) {
+	translator := &Translator{Logger: zap.NewNop()}
+	translator.goParse(func() { panic("bad lag") })
+	translator.wg.Wait()
+}
@@ -34,6 +34,15 @@ type Partition struct {
 	} `json:"end"`
 	CurrentLag int     `json:"current_lag"`
 	Complete   float64 `json:"complete"`
+	// OffsetsUnknown is set if Start and End carry lags only, e.g. parsed from Slack attachments.
+	OffsetsUnknown bool `json:"-"`
+}
+
+// HasCommittedOffset tells whether End.Offset is a committed offset of the consumer group,
+// offset based metrics, consumption state and lag in seconds need it.
+// Burrow sets timestamp with every commit, so End without offset and timestamp has no commit data.
+func (p Partition) HasCommittedOffset() bool {
+	return !p.OffsetsUnknown && (p.End.Offset != 0 || p.End.Timestamp != 0)
 }
 
 // MaxLag represents max partition lag
@@ -54,6 +63,8 @@ type MaxLag struct {
 	} `json:"end"`
 	CurrentLag int     `json:"current_lag"`
 	Complete   float64 `json:"complete"`
+	// OffsetsUnknown is set if Start and End carry lags only, e.g. parsed from Slack attachments.
+	OffsetsUnknown bool `json:"-"`
 }
 
 // LagStatus is "status" from v3/kafka/{cluster}/consumer/{consumer}/lag
@@ -0,0 +1,91 @@
+package protocol
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// MetricKind tells how a sink should aggregate a metric.
+type MetricKind string
+
+const (
+	// KindGauge is a value at timestamp, e.g. lag and offsets. It's the kind of a Metric by default.
+	KindGauge MetricKind = ""
+	// KindCounter is the number of events since the last report, e.g. counters of CountService.
+	KindCounter MetricKind = "counter"
+)
+
+// Metric is a single data point produced by goRainbow.
+// It flows through ProduceQueue as it is, and is serialized only by the producer.
+type Metric struct {
+	// Name is the dotted metric name, e.g. fjord.burrow.{cluster}.{consumer}.totalLag
+	Name      string            `json:"name"`
+	Value     float64           `json:"value"`
+	Timestamp int64             `json:"timestamp"`
+	Tags      map[string]string `json:"tags"`
+	Kind      MetricKind        `json:"kind,omitempty"`
+}
+
+// NewMetric joins name parts by "." into a Metric name.
+func NewMetric(nameParts []string, value float64, timestamp int64, tags map[string]string) Metric {
+	return Metric{
+		Name:      strings.Join(nameParts, "."),
+		Value:     value,
+		Timestamp: timestamp,
+		Tags:      tags,
+	}
+}
+
+// Family returns the last non-number part of name, it tells what the metric is.
+// e.g. fjord.burrow.{cluster}.{consumer}.totalLag -> totalLag
+// e.g. fjord.burrow.{cluster}.{consumer}.hosts.{partition} -> hosts
+func (m Metric) Family() string {
+	parts := strings.Split(m.Name, ".")
+	last := parts[len(parts)-1]
+	if _, err := strconv.Atoi(last); err == nil && len(parts) > 1 {
+		return parts[len(parts)-2]
+	}
+	return last
+}
+
+// CounterName is the name in CountService of a counter, e.g.
+// fjord.burrow.{env}.exception.sinkDropped.kafka -> exception.sinkDropped.kafka
+func (m Metric) CounterName() string {
+	name := strings.TrimPrefix(m.Name, "fjord.burrow.")
+	if env := m.Tags["env"]; env != "" {
+		name = strings.TrimPrefix(name, env+".")
+	}
+	return name
+}
+
+// Validate checks the metric can be serialized into line based formats,
+// i.e. name and tags are not empty and contain no whitespace.
+func (m Metric) Validate() error {
+	if m.Name == "" {
+		return errors.New("metric name is empty")
+	}
+	if strings.IndexFunc(m.Name, unicode.IsSpace) >= 0 {
+		return errors.New("metric name contains whitespace: " + m.Name)
+	}
+	for k, v := range m.Tags {
+		if k == "" || v == "" {
+			return errors.New("metric " + m.Name + " has empty tag: " + k + "=" + v)
+		}
+		if strings.IndexFunc(k+v, unicode.IsSpace) >= 0 {
+			return errors.New("metric " + m.Name + " has whitespace in tag: " + k + "=" + v)
+		}
+	}
+	return nil
+}
+
+// WithTag returns a copy of tags with key=value added.
+func WithTag(tags map[string]string, key string, value string) map[string]string {
+	res := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		res[k] = v
+	}
+	res[key] = value
+	return res
+}
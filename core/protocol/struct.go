@@ -1,15 +1,37 @@
 package protocol
 
+import "encoding/json"
+
 // Config struct is for config file load
 type Config struct {
-	ReportIntervalSeconds int `json:"reportIntervalSeconds"`
-	Kafka                 struct {
+	ReportIntervalSeconds  int `json:"reportIntervalSeconds"`
+	ProduceQueueSize       int `json:"produceQueueSize"`
+	ShutdownTimeoutSeconds int `json:"shutdownTimeoutSeconds"`
+	Burrow                 struct {
+		URL            string `json:"url"`
+		TimeoutSeconds int    `json:"timeoutSeconds"`
+		Retries        int    `json:"retries"`
+		BackoffMs      int    `json:"backoffMs"`
+		MaxBackoffMs   int    `json:"maxBackoffMs"`
+	} `json:"burrow"`
+	Server struct {
+		Port int `json:"port"`
+	} `json:"server"`
+	Intervals struct {
+		ConsumerPollSeconds int `json:"consumerPollSeconds"`
+		TopicPollSeconds    int `json:"topicPollSeconds"`
+		DiscoverySeconds    int `json:"discoverySeconds"`
+		BurrowRetrySeconds  int `json:"burrowRetrySeconds"`
+	} `json:"intervals"`
+	Kafka struct {
 		BrokerServers string `json:"brokerServers"`
 		Topic         string `json:"topic"`
 	} `json:"kafka"`
 	Translator struct {
-		FullClassName string `json:"fullClassName"`
-		MetricFormat  string `json:"metricFormat"`
+		FullClassName      string `json:"fullClassName"`
+		MetricFormat       string `json:"metricFormat"`
+		Emission           string `json:"emission"`
+		StallWindowSeconds int    `json:"stallWindowSeconds"`
 	} `json:"translator"`
 	Service struct {
 		CustomTags string `json:"customTags"`
@@ -19,12 +41,71 @@ type Config struct {
 	Consumer struct {
 		Blacklist string `json:"blacklist"`
 	} `json:"consumer"`
+	Input struct {
+		Mode            string `json:"mode"`
+		PushPath        string `json:"pushPath"`
+		PushIdleSeconds int    `json:"pushIdleSeconds"`
+	} `json:"input"`
+	History struct {
+		RetentionHours int `json:"retentionHours"`
+		MaxPoints      int `json:"maxPoints"`
+		MaxSeries      int `json:"maxSeries"`
+	} `json:"history"`
+	Sinks  []SinkConfig `json:"sinks"`
+	Alerts AlertConfig  `json:"alerts"`
+}
+
+// AlertConfig is for config "alerts", rules are evaluated on every consumer poll.
+type AlertConfig struct {
+	RepeatIntervalSeconds int               `json:"repeatIntervalSeconds"`
+	GroupIntervalSeconds  int               `json:"groupIntervalSeconds"`
+	Webhooks              []WebhookConfig   `json:"webhooks"`
+	Rules                 []AlertRuleConfig `json:"rules"`
+}
+
+// WebhookConfig is an HTTP endpoint alerts are posted to.
+type WebhookConfig struct {
+	URL            string            `json:"url"`
+	Headers        map[string]string `json:"headers"`
+	TimeoutSeconds int               `json:"timeoutSeconds"`
 }
 
-// PartitionOffsetMove is for calculating offset moves per consumer host
-type PartitionOffsetMove struct {
-	CurtTimestamp int64 `json:"curtTimestamp"`
-	CurtOffset    int   `json:"curtOffset"`
-	LastTimestamp int64 `json:"lastTimestamp"`
-	LastOffset    int   `json:"lastOffset"`
+// AlertRuleConfig is one alert rule, Cluster, Group and Topic are regexps, empty matches all.
+// Condition is totalLag, timeLag, lagIncreasing or status.
+type AlertRuleConfig struct {
+	Name          string  `json:"name"`
+	Cluster       string  `json:"cluster"`
+	Group         string  `json:"group"`
+	Topic         string  `json:"topic"`
+	Condition     string  `json:"condition"`
+	Threshold     float64 `json:"threshold"`
+	ForSeconds    int     `json:"forSeconds"`
+	WindowSeconds int     `json:"windowSeconds"`
+}
+
+// SinkConfig is for one sink in config "sinks".
+// A plain string, e.g. "kafka", is accepted as a sink with default options.
+type SinkConfig struct {
+	Type       string          `json:"type"`
+	Name       string          `json:"name"`
+	BufferSize int             `json:"bufferSize"`
+	Options    json.RawMessage `json:"options"`
+}
+
+// UnmarshalJSON accepts both "kafka" and {"type": "kafka", ...}
+func (sc *SinkConfig) UnmarshalJSON(data []byte) error {
+	var sinkType string
+	if err := json.Unmarshal(data, &sinkType); err == nil {
+		*sc = SinkConfig{Type: sinkType}
+		return nil
+	}
+
+	// alias avoids calling UnmarshalJSON recursively
+	type sinkConfigAlias SinkConfig
+	var alias sinkConfigAlias
+	if err := json.Unmarshal(data, &alias); err != nil {
+		return err
+	}
+	*sc = SinkConfig(alias)
+	return nil
 }
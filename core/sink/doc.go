@@ -0,0 +1,3 @@
+// Package sink includes the destinations goRainbow sends metrics to.
+// Each sink registers itself by its type, and is picked by "sinks" in config.json.
+package sink
@@ -0,0 +1,62 @@
+package sink
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+
+	"go.uber.org/zap"
+
+	"github.com/harbinzhang/goRainbow/core/protocol"
+)
+
+func init() {
+	Register("file", func() Sink { return &FileSink{} })
+}
+
+// FileOptions is "options" of file sink.
+type FileOptions struct {
+	Path   string `json:"path"`
+	Format string `json:"format"`
+}
+
+// FileSink appends metrics to a local file, one metric per line.
+type FileSink struct {
+	formatter *lineFormatter
+	file      *os.File
+}
+
+// Init is a general init
+func (fs *FileSink) Init(options json.RawMessage, logger *zap.Logger) error {
+	var opts FileOptions
+	if err := decodeOptions(options, &opts); err != nil {
+		return err
+	}
+	if opts.Path == "" {
+		return errors.New("file path is required")
+	}
+
+	formatter, err := newLineFormatter(opts.Format)
+	if err != nil {
+		return err
+	}
+	fs.formatter = formatter
+
+	fs.file, err = os.OpenFile(opts.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	return err
+}
+
+// Send is a general send
+func (fs *FileSink) Send(metric protocol.Metric) error {
+	line, err := fs.formatter.formatLine(metric)
+	if err != nil {
+		return err
+	}
+	_, err = fs.file.Write(append(line, '\n'))
+	return err
+}
+
+// Stop is a general stop
+func (fs *FileSink) Stop() error {
+	return fs.file.Close()
+}
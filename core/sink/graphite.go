@@ -0,0 +1,380 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/harbinzhang/goRainbow/core/protocol"
+	"github.com/harbinzhang/goRainbow/core/util"
+)
+
+func init() {
+	Register("graphite", func() Sink { return &GraphiteSink{} })
+}
+
+// GraphiteOptions is "options" of graphite sink.
+type GraphiteOptions struct {
+	// Address is host:port of carbon, 2003 for plaintext and 2004 for pickle by default.
+	Address  string `json:"address"`
+	Protocol string `json:"protocol"`
+	// Tags appends tags as "path;k=v", needs Graphite 1.1+.
+	Tags                 bool `json:"tags"`
+	PoolSize             int  `json:"poolSize"`
+	BatchSize            int  `json:"batchSize"`
+	FlushIntervalSeconds int  `json:"flushIntervalSeconds"`
+	TimeoutSeconds       int  `json:"timeoutSeconds"`
+	BackoffMs            int  `json:"backoffMs"`
+	MaxBackoffMs         int  `json:"maxBackoffMs"`
+	// MaxBuffered bounds metrics kept while carbon is down, the oldest are dropped first.
+	MaxBuffered int `json:"maxBuffered"`
+}
+
+// GraphiteSink writes metrics to carbon over TCP, in plaintext or pickle protocol.
+// Metrics are buffered and written in batches by PoolSize connections, a broken
+// connection is redialed with exponential backoff while its batch stays in buffer.
+type GraphiteSink struct {
+	sync.Mutex
+
+	opts       GraphiteOptions
+	logger     *zap.Logger
+	commonTags map[string]string
+	pending    []graphitePoint
+	dropped    int
+
+	conns       []*graphiteConn
+	kick        chan struct{}
+	quitChannel chan struct{}
+	wg          sync.WaitGroup
+}
+
+type graphitePoint struct {
+	path      string
+	value     float64
+	timestamp int64
+}
+
+// graphiteConn is a pooled connection, it's only used by its own writer.
+type graphiteConn struct {
+	conn    net.Conn
+	backoff time.Duration
+	retryAt time.Time
+}
+
+// Init is a general init
+func (gs *GraphiteSink) Init(options json.RawMessage, logger *zap.Logger) error {
+	gs.logger = logger
+	gs.opts = GraphiteOptions{
+		Protocol:             "plaintext",
+		Tags:                 true,
+		PoolSize:             2,
+		BatchSize:            500,
+		FlushIntervalSeconds: 10,
+		TimeoutSeconds:       10,
+		BackoffMs:            500,
+		MaxBackoffMs:         30 * 1000,
+		MaxBuffered:          100000,
+	}
+	if err := decodeOptions(options, &gs.opts); err != nil {
+		return err
+	}
+	if gs.opts.Protocol != "plaintext" && gs.opts.Protocol != "pickle" {
+		return errors.New("unknown graphite protocol: " + gs.opts.Protocol)
+	}
+	if gs.opts.Address == "" {
+		gs.opts.Address = "127.0.0.1:2003"
+		if gs.opts.Protocol == "pickle" {
+			gs.opts.Address = "127.0.0.1:2004"
+		}
+	}
+	if gs.opts.PoolSize <= 0 || gs.opts.BatchSize <= 0 || gs.opts.FlushIntervalSeconds <= 0 ||
+		gs.opts.TimeoutSeconds <= 0 || gs.opts.MaxBuffered < gs.opts.BatchSize {
+		return errors.New("graphite poolSize, batchSize, flushIntervalSeconds and timeoutSeconds should be positive, " +
+			"maxBuffered should be at least batchSize")
+	}
+
+	contextProvider := util.ContextProvider{}
+	contextProvider.Init()
+	gs.commonTags = contextProvider.GetTags()
+
+	gs.kick = make(chan struct{}, gs.opts.PoolSize)
+	gs.quitChannel = make(chan struct{})
+	gs.conns = make([]*graphiteConn, gs.opts.PoolSize)
+	for i := range gs.conns {
+		gs.conns[i] = &graphiteConn{}
+		gs.wg.Add(1)
+		go gs.write(gs.conns[i])
+	}
+	return nil
+}
+
+// Send adds metric into buffer, and wakes up a writer if a batch is full.
+func (gs *GraphiteSink) Send(metric protocol.Metric) error {
+	point := graphitePoint{
+		path:      gs.path(metric),
+		value:     metric.Value,
+		timestamp: metric.Timestamp,
+	}
+
+	gs.Lock()
+	if len(gs.pending) >= gs.opts.MaxBuffered {
+		gs.pending = gs.pending[1:]
+		gs.dropped++
+	}
+	gs.pending = append(gs.pending, point)
+	isFull := len(gs.pending) >= gs.opts.BatchSize
+	gs.Unlock()
+
+	if isFull {
+		select {
+		case gs.kick <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Stop writes the metrics left in buffer, once per connection regardless of backoff.
+func (gs *GraphiteSink) Stop() error {
+	close(gs.quitChannel)
+	gs.wg.Wait()
+
+	for _, gc := range gs.conns {
+		gc.retryAt = time.Time{}
+		gs.drain(gc, true)
+		if gc.conn != nil {
+			gc.conn.Close()
+		}
+	}
+	gs.reportDropped()
+
+	gs.Lock()
+	defer gs.Unlock()
+	if len(gs.pending) > 0 {
+		return fmt.Errorf("graphite sink lost %d metrics on stop", len(gs.pending))
+	}
+	return nil
+}
+
+// write is the writer of a pooled connection, full batches are written on kick
+// and everything in buffer every FlushIntervalSeconds.
+func (gs *GraphiteSink) write(gc *graphiteConn) {
+	defer gs.wg.Done()
+
+	ticker := time.NewTicker(time.Duration(gs.opts.FlushIntervalSeconds) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-gs.kick:
+			gs.drain(gc, false)
+		case <-ticker.C:
+			gs.drain(gc, true)
+			gs.reportDropped()
+		case <-gs.quitChannel:
+			return
+		}
+	}
+}
+
+// drain writes batches until buffer is empty, or has no full batch if not all.
+// It stops at the first failure, the failed batch is put back into buffer.
+func (gs *GraphiteSink) drain(gc *graphiteConn, all bool) {
+	for gc.conn != nil || !time.Now().Before(gc.retryAt) {
+		batch := gs.take(all)
+		if len(batch) == 0 {
+			return
+		}
+		if err := gs.writeBatch(gc, batch); err != nil {
+			gs.requeue(batch)
+			gs.logger.Warn("graphite write failed",
+				zap.String("error", err.Error()),
+				zap.String("address", gs.opts.Address),
+				zap.Duration("backoff", gc.backoff),
+				zap.Int64("timestamp", time.Now().Unix()),
+			)
+			return
+		}
+	}
+}
+
+// writeBatch writes one batch, dialing if the connection is not open.
+func (gs *GraphiteSink) writeBatch(gc *graphiteConn, batch []graphitePoint) error {
+	timeout := time.Duration(gs.opts.TimeoutSeconds) * time.Second
+	if gc.conn == nil {
+		conn, err := net.DialTimeout("tcp", gs.opts.Address, timeout)
+		if err != nil {
+			gs.backoff(gc)
+			return err
+		}
+		gc.conn = conn
+	}
+
+	var payload []byte
+	if gs.opts.Protocol == "pickle" {
+		payload = encodePickle(batch)
+	} else {
+		payload = encodePlaintext(batch)
+	}
+	gc.conn.SetWriteDeadline(time.Now().Add(timeout))
+	if _, err := gc.conn.Write(payload); err != nil {
+		gc.conn.Close()
+		gc.conn = nil
+		gs.backoff(gc)
+		return err
+	}
+	gc.backoff = 0
+	return nil
+}
+
+// backoff doubles the wait before redialing, up to MaxBackoffMs.
+func (gs *GraphiteSink) backoff(gc *graphiteConn) {
+	if gc.backoff == 0 {
+		gc.backoff = time.Duration(gs.opts.BackoffMs) * time.Millisecond
+	} else {
+		gc.backoff *= 2
+	}
+	if maxBackoff := time.Duration(gs.opts.MaxBackoffMs) * time.Millisecond; gc.backoff > maxBackoff {
+		gc.backoff = maxBackoff
+	}
+	gc.retryAt = time.Now().Add(gc.backoff)
+}
+
+// take removes a batch from the front of buffer, nil if there is no full batch and not all.
+func (gs *GraphiteSink) take(all bool) []graphitePoint {
+	gs.Lock()
+	defer gs.Unlock()
+	n := len(gs.pending)
+	if n == 0 || (!all && n < gs.opts.BatchSize) {
+		return nil
+	}
+	if n > gs.opts.BatchSize {
+		n = gs.opts.BatchSize
+	}
+	batch := make([]graphitePoint, n)
+	copy(batch, gs.pending)
+	gs.pending = gs.pending[n:]
+	return batch
+}
+
+// requeue puts a failed batch back to the front of buffer, the oldest are dropped if it's full.
+func (gs *GraphiteSink) requeue(batch []graphitePoint) {
+	gs.Lock()
+	defer gs.Unlock()
+	pending := make([]graphitePoint, 0, len(batch)+len(gs.pending))
+	pending = append(append(pending, batch...), gs.pending...)
+	if excess := len(pending) - gs.opts.MaxBuffered; excess > 0 {
+		pending = pending[excess:]
+		gs.dropped += excess
+	}
+	gs.pending = pending
+}
+
+func (gs *GraphiteSink) reportDropped() {
+	gs.Lock()
+	dropped := gs.dropped
+	gs.dropped = 0
+	gs.Unlock()
+
+	if dropped > 0 {
+		gs.logger.Warn("graphite buffer full, oldest metrics dropped",
+			zap.Int("dropped", dropped),
+			zap.Int64("timestamp", time.Now().Unix()),
+		)
+	}
+}
+
+// path is metric name with tags in Graphite syntax, "name;k=v;k=v" sorted by key.
+// Tags of metric override the common ones from config.json.
+func (gs *GraphiteSink) path(metric protocol.Metric) string {
+	path := graphiteReplacer.Replace(metric.Name)
+	if !gs.opts.Tags {
+		return path
+	}
+
+	tags := make(map[string]string, len(gs.commonTags)+len(metric.Tags))
+	for k, v := range gs.commonTags {
+		tags[k] = v
+	}
+	for k, v := range metric.Tags {
+		tags[k] = v
+	}
+	var buf strings.Builder
+	buf.WriteString(path)
+	for _, k := range util.SortedTagKeys(tags) {
+		if k == "" || tags[k] == "" {
+			continue
+		}
+		buf.WriteString(";")
+		buf.WriteString(graphiteTagReplacer.Replace(k))
+		buf.WriteString("=")
+		buf.WriteString(graphiteReplacer.Replace(strings.TrimLeft(tags[k], "~")))
+	}
+	return buf.String()
+}
+
+var (
+	// graphiteReplacer keeps whitespace and ";" out of paths and tag values.
+	graphiteReplacer = strings.NewReplacer(" ", "_", "\t", "_", "\n", "_", ";", "_")
+	// graphiteTagReplacer also keeps "!", "^" and "=" out of tag names.
+	graphiteTagReplacer = strings.NewReplacer(" ", "_", "\t", "_", "\n", "_", ";", "_", "!", "_", "^", "_", "=", "_")
+)
+
+// encodePlaintext serializes batch as "path value timestamp" lines.
+func encodePlaintext(batch []graphitePoint) []byte {
+	var buf bytes.Buffer
+	for _, p := range batch {
+		buf.WriteString(p.path)
+		buf.WriteByte(' ')
+		buf.WriteString(util.FormatValue(p.value))
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.FormatInt(p.timestamp, 10))
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// encodePickle serializes batch as a pickled list of (path, (timestamp, value)),
+// in pickle protocol 2 with a 4 bytes big-endian length header, as carbon's pickle receiver expects.
+func encodePickle(batch []graphitePoint) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0, 0, 0, 0}) // length header, set at the end
+	buf.Write([]byte{0x80, 2})    // PROTO 2
+	buf.WriteByte(']')            // EMPTY_LIST
+	buf.WriteByte('(')            // MARK
+	for _, p := range batch {
+		// BINUNICODE path
+		buf.WriteByte('X')
+		binary.Write(&buf, binary.LittleEndian, uint32(len(p.path)))
+		buf.WriteString(p.path)
+		if p.timestamp >= math.MinInt32 && p.timestamp <= math.MaxInt32 {
+			// BININT timestamp
+			buf.WriteByte('J')
+			binary.Write(&buf, binary.LittleEndian, int32(p.timestamp))
+		} else {
+			// BINFLOAT timestamp
+			buf.WriteByte('G')
+			binary.Write(&buf, binary.BigEndian, float64(p.timestamp))
+		}
+		// BINFLOAT value
+		buf.WriteByte('G')
+		binary.Write(&buf, binary.BigEndian, p.value)
+		buf.WriteByte(0x86) // TUPLE2 (timestamp, value)
+		buf.WriteByte(0x86) // TUPLE2 (path, (timestamp, value))
+	}
+	buf.WriteByte('e') // APPENDS
+	buf.WriteByte('.') // STOP
+
+	payload := buf.Bytes()
+	binary.BigEndian.PutUint32(payload, uint32(len(payload)-4))
+	return payload
+}
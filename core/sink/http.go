@@ -0,0 +1,155 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/harbinzhang/goRainbow/core/protocol"
+)
+
+func init() {
+	Register("http", func() Sink { return &HTTPPostSink{} })
+}
+
+// HTTPOptions is "options" of http sink.
+type HTTPOptions struct {
+	URL                  string            `json:"url"`
+	Format               string            `json:"format"`
+	Headers              map[string]string `json:"headers"`
+	BatchSize            int               `json:"batchSize"`
+	FlushIntervalSeconds int               `json:"flushIntervalSeconds"`
+	TimeoutSeconds       int               `json:"timeoutSeconds"`
+}
+
+// HTTPPostSink posts metrics in batches to an HTTP endpoint, one metric per line in body.
+// A batch is posted when it's full or every FlushIntervalSeconds.
+type HTTPPostSink struct {
+	sync.Mutex
+
+	opts      HTTPOptions
+	logger    *zap.Logger
+	formatter *lineFormatter
+	client    *http.Client
+	batch     bytes.Buffer
+	batchSize int
+
+	ticker      *time.Ticker
+	quitChannel chan struct{}
+}
+
+// Init is a general init
+func (hs *HTTPPostSink) Init(options json.RawMessage, logger *zap.Logger) error {
+	hs.logger = logger
+	hs.opts = HTTPOptions{
+		BatchSize:            500,
+		FlushIntervalSeconds: 10,
+		TimeoutSeconds:       10,
+	}
+	if err := decodeOptions(options, &hs.opts); err != nil {
+		return err
+	}
+	if hs.opts.URL == "" {
+		return errors.New("http url is required")
+	}
+
+	formatter, err := newLineFormatter(hs.opts.Format)
+	if err != nil {
+		return err
+	}
+	hs.formatter = formatter
+	hs.client = &http.Client{Timeout: time.Duration(hs.opts.TimeoutSeconds) * time.Second}
+
+	hs.ticker = time.NewTicker(time.Duration(hs.opts.FlushIntervalSeconds) * time.Second)
+	hs.quitChannel = make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-hs.ticker.C:
+				if err := hs.flush(); err != nil {
+					hs.logger.Warn("http flush failed",
+						zap.String("error", err.Error()),
+						zap.Int64("timestamp", time.Now().Unix()),
+					)
+				}
+			case <-hs.quitChannel:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Send adds metric into batch, and posts the batch if it's full.
+func (hs *HTTPPostSink) Send(metric protocol.Metric) error {
+	line, err := hs.formatter.formatLine(metric)
+	if err != nil {
+		return err
+	}
+
+	hs.Lock()
+	hs.batch.Write(line)
+	hs.batch.WriteByte('\n')
+	hs.batchSize++
+	isFull := hs.batchSize >= hs.opts.BatchSize
+	hs.Unlock()
+
+	if isFull {
+		return hs.flush()
+	}
+	return nil
+}
+
+// Stop posts the last batch.
+func (hs *HTTPPostSink) Stop() error {
+	hs.ticker.Stop()
+	close(hs.quitChannel)
+	return hs.flush()
+}
+
+// flush posts current batch, the batch is dropped if post fails.
+func (hs *HTTPPostSink) flush() error {
+	hs.Lock()
+	if hs.batchSize == 0 {
+		hs.Unlock()
+		return nil
+	}
+	body := make([]byte, hs.batch.Len())
+	copy(body, hs.batch.Bytes())
+	hs.batch.Reset()
+	hs.batchSize = 0
+	hs.Unlock()
+
+	req, err := http.NewRequest(http.MethodPost, hs.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if hs.formatter.format == "json" {
+		req.Header.Set("Content-Type", "application/x-ndjson")
+	} else {
+		req.Header.Set("Content-Type", "text/plain")
+	}
+	for k, v := range hs.opts.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := hs.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("http sink got status %d from %s", resp.StatusCode, hs.opts.URL)
+	}
+	return nil
+}
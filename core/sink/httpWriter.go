@@ -0,0 +1,96 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// httpWriter posts batches of push based sinks, e.g. influxdb and otlp.
+// Network errors, 429 and 5xx are retried with exponential backoff, other statuses are not.
+type httpWriter struct {
+	name    string
+	url     string
+	headers map[string]string
+	gzip    bool
+	retries int
+	backoff time.Duration
+	client  *http.Client
+}
+
+// statusError is a batch rejected by the endpoint.
+type statusError struct {
+	name   string
+	status int
+	body   string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s write got status %d: %s", e.name, e.status, e.body)
+}
+
+// write posts body as contentType, gzipped if enabled.
+func (hw *httpWriter) write(body []byte, contentType string) error {
+	if hw.gzip {
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		gz.Write(body)
+		gz.Close()
+		body = compressed.Bytes()
+	}
+
+	backoff := hw.backoff
+	var err error
+	for attempt := 0; attempt <= hw.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err = hw.post(body, contentType); !isRetryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// isRetryable tells whether a write may succeed later, a rejected batch is not.
+func isRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if statusErr, ok := err.(*statusError); ok {
+		return statusErr.status == http.StatusTooManyRequests || statusErr.status >= 500
+	}
+	return true
+}
+
+func (hw *httpWriter) post(body []byte, contentType string) error {
+	req, err := http.NewRequest(http.MethodPost, hw.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if hw.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	for k, v := range hw.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := hw.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	if resp.StatusCode >= 300 {
+		return &statusError{name: hw.name, status: resp.StatusCode, body: strings.TrimSpace(string(respBody))}
+	}
+	return nil
+}
@@ -0,0 +1,342 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/harbinzhang/goRainbow/core/protocol"
+	"github.com/harbinzhang/goRainbow/core/util"
+)
+
+func init() {
+	Register("influxdb", func() Sink { return &InfluxSink{} })
+}
+
+// InfluxOptions is "options" of influxdb sink.
+type InfluxOptions struct {
+	// Transport is "http"(default) or "udp".
+	Transport string `json:"transport"`
+	// URL is the write endpoint for http, e.g. http://127.0.0.1:8086/write?db=rainbow
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Gzip    bool              `json:"gzip"`
+	Retries int               `json:"retries"`
+	// BackoffMs is the wait before the first retry, doubled for each retry.
+	BackoffMs int `json:"backoffMs"`
+	// Address is host:port for udp.
+	Address       string `json:"address"`
+	MaxPacketSize int    `json:"maxPacketSize"`
+
+	// BatchSize is lines per write, a line has all fields of a series at a timestamp.
+	BatchSize            int `json:"batchSize"`
+	FlushIntervalSeconds int `json:"flushIntervalSeconds"`
+	TimeoutSeconds       int `json:"timeoutSeconds"`
+}
+
+// InfluxSink writes metrics in InfluxDB line protocol, in batches over HTTP or UDP.
+// Measurements are fixed, the values in metric name(cluster, group, topic, partition) are tags.
+// Metrics of the same series at the same timestamp are grouped into one line, e.g.
+// fjord.burrow.{cluster}.{consumer}.{topic}.{partition}.Lag, startOffset and endOffset
+// are fields Lag, startOffset and endOffset of measurement consumer_lag
+// with tags cluster, group, topic and partition.
+type InfluxSink struct {
+	sync.Mutex
+
+	opts       InfluxOptions
+	logger     *zap.Logger
+	commonTags map[string]string
+	writer     *httpWriter
+	udpConn    net.Conn
+
+	points []*influxPoint
+	index  map[string]*influxPoint
+
+	ticker      *time.Ticker
+	quitChannel chan struct{}
+}
+
+type influxPoint struct {
+	measurement string
+	tags        map[string]string
+	fields      map[string]float64
+	timestamp   int64
+}
+
+// Init is a general init
+func (is *InfluxSink) Init(options json.RawMessage, logger *zap.Logger) error {
+	is.logger = logger
+	is.opts = InfluxOptions{
+		Transport:            "http",
+		Gzip:                 true,
+		Retries:              3,
+		BackoffMs:            500,
+		MaxPacketSize:        1400,
+		BatchSize:            5000,
+		FlushIntervalSeconds: 10,
+		TimeoutSeconds:       10,
+	}
+	if err := decodeOptions(options, &is.opts); err != nil {
+		return err
+	}
+	if is.opts.BatchSize <= 0 || is.opts.FlushIntervalSeconds <= 0 || is.opts.TimeoutSeconds <= 0 {
+		return errors.New("influxdb batchSize, flushIntervalSeconds and timeoutSeconds should be positive")
+	}
+
+	switch is.opts.Transport {
+	case "http":
+		writeURL, err := url.Parse(is.opts.URL)
+		if err != nil || writeURL.Scheme == "" || writeURL.Host == "" {
+			return errors.New("influxdb url is required for http, e.g. http://127.0.0.1:8086/write?db=rainbow")
+		}
+		// goRainbow timestamps are in seconds.
+		query := writeURL.Query()
+		if query.Get("precision") == "" {
+			query.Set("precision", "s")
+			writeURL.RawQuery = query.Encode()
+		}
+		is.writer = &httpWriter{
+			name:    "influxdb",
+			url:     writeURL.String(),
+			headers: is.opts.Headers,
+			gzip:    is.opts.Gzip,
+			retries: is.opts.Retries,
+			backoff: time.Duration(is.opts.BackoffMs) * time.Millisecond,
+			client:  &http.Client{Timeout: time.Duration(is.opts.TimeoutSeconds) * time.Second},
+		}
+	case "udp":
+		if is.opts.Address == "" {
+			return errors.New("influxdb address is required for udp")
+		}
+		conn, err := net.Dial("udp", is.opts.Address)
+		if err != nil {
+			return err
+		}
+		is.udpConn = conn
+	default:
+		return errors.New("unknown influxdb transport: " + is.opts.Transport)
+	}
+
+	contextProvider := util.ContextProvider{}
+	contextProvider.Init()
+	is.commonTags = contextProvider.GetTags()
+	is.index = make(map[string]*influxPoint)
+
+	is.ticker = time.NewTicker(time.Duration(is.opts.FlushIntervalSeconds) * time.Second)
+	is.quitChannel = make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-is.ticker.C:
+				if err := is.flush(); err != nil {
+					is.logger.Warn("influxdb flush failed",
+						zap.String("error", err.Error()),
+						zap.Int64("timestamp", time.Now().Unix()),
+					)
+				}
+			case <-is.quitChannel:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Send adds metric as a field of its line, and writes the batch if it's full.
+func (is *InfluxSink) Send(metric protocol.Metric) error {
+	if math.IsNaN(metric.Value) || math.IsInf(metric.Value, 0) {
+		return errors.New("influxdb doesn't accept value " + util.FormatValue(metric.Value))
+	}
+	measurement, field, nameTags := splitSeries(metric)
+	tags := make(map[string]string, len(is.commonTags)+len(nameTags)+len(metric.Tags))
+	for k, v := range is.commonTags {
+		tags[k] = v
+	}
+	for k, v := range nameTags {
+		tags[k] = v
+	}
+	for k, v := range metric.Tags {
+		tags[k] = v
+	}
+	key := formatSeries(measurement, tags) + " " + strconv.FormatInt(metric.Timestamp, 10)
+
+	is.Lock()
+	point, ok := is.index[key]
+	if !ok {
+		point = &influxPoint{
+			measurement: measurement,
+			tags:        tags,
+			fields:      make(map[string]float64),
+			timestamp:   metric.Timestamp,
+		}
+		is.index[key] = point
+		is.points = append(is.points, point)
+	}
+	point.fields[field] = metric.Value
+	isFull := len(is.points) >= is.opts.BatchSize
+	is.Unlock()
+
+	if isFull {
+		return is.flush()
+	}
+	return nil
+}
+
+// Stop writes the last batch.
+func (is *InfluxSink) Stop() error {
+	is.ticker.Stop()
+	close(is.quitChannel)
+	err := is.flush()
+	if is.udpConn != nil {
+		is.udpConn.Close()
+	}
+	return err
+}
+
+// flush writes current batch, the batch is dropped if it still fails after retries.
+func (is *InfluxSink) flush() error {
+	is.Lock()
+	points := is.points
+	is.points = nil
+	is.index = make(map[string]*influxPoint)
+	is.Unlock()
+	if len(points) == 0 {
+		return nil
+	}
+
+	lines := make([]string, len(points))
+	for i, point := range points {
+		lines[i] = point.line()
+	}
+	if is.udpConn != nil {
+		return is.writeUDP(lines)
+	}
+	return is.writer.write([]byte(strings.Join(lines, "\n")+"\n"), "text/plain; charset=utf-8")
+}
+
+// writeUDP sends lines in packets up to MaxPacketSize, a longer line is sent alone.
+func (is *InfluxSink) writeUDP(lines []string) error {
+	var packet bytes.Buffer
+	var lastErr error
+	send := func() {
+		if packet.Len() == 0 {
+			return
+		}
+		if _, err := is.udpConn.Write(packet.Bytes()); err != nil {
+			lastErr = err
+		}
+		packet.Reset()
+	}
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+len(line)+1 > is.opts.MaxPacketSize {
+			send()
+		}
+		packet.WriteString(line)
+		packet.WriteByte('\n')
+	}
+	send()
+	return lastErr
+}
+
+// Measurements of influxdb sink.
+const (
+	influxConsumerMeasurement = "consumer_lag"
+	influxTopicMeasurement    = "topic_offset"
+	// influxRainbowMeasurement is for counters of goRainbow itself.
+	influxRainbowMeasurement = "rainbow"
+)
+
+// splitSeries splits metric name into measurement, field and the tags which are values in name.
+// The field is metric family, e.g.
+// fjord.burrow.{cluster}.{group}.{topic}.{partition}.Lag -> consumer_lag,cluster,group,topic,partition Lag
+// fjord.burrow.{cluster}.{group}.hosts.{partition} -> consumer_lag,cluster,group,partition hosts
+// fjord.burrow.{cluster}.topic.{topic}.{partition}.offset -> topic_offset,cluster,topic,partition offset
+// Counters are fields of rainbow, named as in CountService, their env is a tag already.
+// e.g. fjord.burrow.{env}.exception.sinkDropped.kafka -> rainbow exception.sinkDropped.kafka
+func splitSeries(metric protocol.Metric) (string, string, map[string]string) {
+	tags := make(map[string]string)
+	if metric.Kind == protocol.KindCounter {
+		return influxRainbowMeasurement, metric.CounterName(), tags
+	}
+
+	field := metric.Family()
+	parts := strings.Split(metric.Name, ".")
+	if len(parts) < 3 || parts[0] != "fjord" || parts[1] != "burrow" {
+		return influxRainbowMeasurement, field, tags
+	}
+	values := parts[2:]
+	for i := len(values) - 1; i >= 0; i-- {
+		if values[i] == field {
+			values = append(values[:i:i], values[i+1:]...)
+			break
+		}
+	}
+	if len(values) > 0 {
+		tags["cluster"] = values[0]
+	}
+	if len(values) < 2 {
+		return influxRainbowMeasurement, field, tags
+	}
+
+	measurement := influxConsumerMeasurement
+	if values[1] == "topic" && len(values) > 2 {
+		measurement = influxTopicMeasurement
+		values = values[2:]
+	} else {
+		tags["group"] = values[1]
+		values = values[2:]
+	}
+	for _, value := range values {
+		if _, err := strconv.Atoi(value); err == nil {
+			tags["partition"] = value
+		} else {
+			tags["topic"] = value
+		}
+	}
+	return measurement, field, tags
+}
+
+// formatSeries is measurement and tags in line protocol, tags are sorted by key.
+func formatSeries(measurement string, tags map[string]string) string {
+	var buf strings.Builder
+	buf.WriteString(influxMeasurementEscaper.Replace(measurement))
+	for _, k := range util.SortedTagKeys(tags) {
+		if k == "" || tags[k] == "" {
+			continue
+		}
+		buf.WriteByte(',')
+		buf.WriteString(influxKeyEscaper.Replace(k))
+		buf.WriteByte('=')
+		buf.WriteString(influxKeyEscaper.Replace(tags[k]))
+	}
+	return buf.String()
+}
+
+// line is the point in line protocol: "measurement,k=v field=value,field=value timestamp".
+func (p *influxPoint) line() string {
+	fields := make([]string, 0, len(p.fields))
+	for field := range p.fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for i, field := range fields {
+		fields[i] = influxKeyEscaper.Replace(field) + "=" + util.FormatValue(p.fields[field])
+	}
+	return formatSeries(p.measurement, p.tags) + " " + strings.Join(fields, ",") + " " + strconv.FormatInt(p.timestamp, 10)
+}
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	influxKeyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+)
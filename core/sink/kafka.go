@@ -0,0 +1,127 @@
+//go:build cgo
+// +build cgo
+
+package sink
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"go.uber.org/zap"
+
+	"github.com/harbinzhang/goRainbow/core/protocol"
+	"github.com/harbinzhang/goRainbow/core/util"
+)
+
+func init() {
+	Register("kafka", func() Sink { return &KafkaSink{} })
+}
+
+// KafkaOptions is "options" of kafka sink, "kafka" in config.json is used by default.
+type KafkaOptions struct {
+	BrokerServers string `json:"brokerServers"`
+	Topic         string `json:"topic"`
+	Format        string `json:"format"`
+	// FlushTimeoutMs bounds how long Stop waits for message deliveries.
+	FlushTimeoutMs int `json:"flushTimeoutMs"`
+}
+
+// KafkaSink sends metrics to Kafka(speed-racer), which will send metrics to the Wavefront.
+type KafkaSink struct {
+	logger         *zap.Logger
+	formatter      *lineFormatter
+	topic          string
+	flushTimeoutMs int
+	kafkaProducer  *kafka.Producer
+}
+
+// Init is a general init
+func (ks *KafkaSink) Init(options json.RawMessage, logger *zap.Logger) error {
+	ks.logger = logger
+
+	contextProvider := util.ContextProvider{}
+	contextProvider.Init()
+	conf := contextProvider.GetConf()
+
+	opts := KafkaOptions{
+		BrokerServers:  conf.Kafka.BrokerServers,
+		Topic:          conf.Kafka.Topic,
+		FlushTimeoutMs: 15 * 1000,
+	}
+	if err := decodeOptions(options, &opts); err != nil {
+		return err
+	}
+	ks.flushTimeoutMs = opts.FlushTimeoutMs
+	if opts.BrokerServers == "" || opts.Topic == "" {
+		return errors.New("kafka brokerServers and topic are required")
+	}
+	ks.topic = opts.Topic
+
+	formatter, err := newLineFormatter(opts.Format)
+	if err != nil {
+		return err
+	}
+	ks.formatter = formatter
+
+	kafkaConfig := kafka.ConfigMap{
+		"batch.num.messages": 2000,
+		"linger.ms":          1,
+		"bootstrap.servers":  opts.BrokerServers,
+		// "buffer.memory=33554432"
+		"socket.send.buffer.bytes": 1024000,
+		// "reconnect.backoff.ms":     100,
+		// "block.on.buffer.full=true"
+		"retries":            6,
+		"retry.backoff.ms":   100,
+		"compression.type":   "gzip",
+		"request.timeout.ms": 900000,
+	}
+	kafkaProducer, err := kafka.NewProducer(&kafkaConfig)
+	if err != nil {
+		return errors.New("Err building kafka producer: " + err.Error())
+	}
+	ks.kafkaProducer = kafkaProducer
+
+	// Delivery report handler for produced messages
+	go func() {
+		for e := range kafkaProducer.Events() {
+			switch ev := e.(type) {
+			case *kafka.Message:
+				if ev.TopicPartition.Error != nil {
+					ks.logger.Warn("Delivery failed",
+						zap.String("topicPartition", ev.TopicPartition.String()),
+						zap.Int64("timestamp", time.Now().Unix()),
+					)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Send produces metric to topic (asynchronously)
+func (ks *KafkaSink) Send(metric protocol.Metric) error {
+	message, err := ks.formatter.formatLine(metric)
+	if err != nil {
+		return err
+	}
+	ks.logger.Debug("Produced to speed-racer: " + string(message))
+	return ks.kafkaProducer.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &ks.topic, Partition: kafka.PartitionAny},
+		Value:          message,
+	}, nil)
+}
+
+// Stop waits for message deliveries before closing the producer.
+func (ks *KafkaSink) Stop() error {
+	remaining := ks.kafkaProducer.Flush(ks.flushTimeoutMs)
+	ks.kafkaProducer.Close()
+	if remaining > 0 {
+		return fmt.Errorf("%d messages not delivered in %dms", remaining, ks.flushTimeoutMs)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/harbinzhang/goRainbow/core/kafkaclient"
+	"github.com/harbinzhang/goRainbow/core/protocol"
+	"github.com/harbinzhang/goRainbow/core/util"
+)
+
+func init() {
+	Register("kafka-native", func() Sink { return &NativeKafkaSink{} })
+}
+
+// NativeKafkaOptions is "options" of kafka-native sink, "kafka" in config.json is used by default.
+// The defaults are the same as the librdkafka config of kafka sink.
+type NativeKafkaOptions struct {
+	BrokerServers string `json:"brokerServers"`
+	Topic         string `json:"topic"`
+	Format        string `json:"format"`
+	// FlushTimeoutMs bounds how long Stop waits for message deliveries.
+	FlushTimeoutMs int `json:"flushTimeoutMs"`
+	// Acks is "all", "1" or "0".
+	Acks string `json:"acks"`
+	// Compression is "gzip" or "none".
+	Compression    string `json:"compression"`
+	BatchSize      int    `json:"batchSize"`
+	LingerMs       int    `json:"lingerMs"`
+	Retries        int    `json:"retries"`
+	RetryBackoffMs int    `json:"retryBackoffMs"`
+	TimeoutSeconds int    `json:"timeoutSeconds"`
+	QueueSize      int    `json:"queueSize"`
+}
+
+// NativeKafkaSink sends metrics to Kafka(speed-racer) with the pure Go client, no cgo is needed.
+type NativeKafkaSink struct {
+	logger         *zap.Logger
+	formatter      *lineFormatter
+	flushTimeoutMs int
+	producer       *kafkaclient.Producer
+}
+
+// Init is a general init
+func (nks *NativeKafkaSink) Init(options json.RawMessage, logger *zap.Logger) error {
+	nks.logger = logger
+
+	contextProvider := util.ContextProvider{}
+	contextProvider.Init()
+	conf := contextProvider.GetConf()
+
+	opts := NativeKafkaOptions{
+		BrokerServers:  conf.Kafka.BrokerServers,
+		Topic:          conf.Kafka.Topic,
+		FlushTimeoutMs: 15 * 1000,
+		Acks:           "all",
+		Compression:    "gzip",
+		BatchSize:      2000,
+		LingerMs:       1,
+		Retries:        6,
+		RetryBackoffMs: 100,
+		TimeoutSeconds: 30,
+		QueueSize:      100000,
+	}
+	if err := decodeOptions(options, &opts); err != nil {
+		return err
+	}
+	nks.flushTimeoutMs = opts.FlushTimeoutMs
+
+	formatter, err := newLineFormatter(opts.Format)
+	if err != nil {
+		return err
+	}
+	nks.formatter = formatter
+
+	nks.producer = &kafkaclient.Producer{
+		Brokers:      opts.BrokerServers,
+		Topic:        opts.Topic,
+		Acks:         opts.Acks,
+		Compression:  opts.Compression,
+		BatchSize:    opts.BatchSize,
+		Linger:       time.Duration(opts.LingerMs) * time.Millisecond,
+		Retries:      opts.Retries,
+		RetryBackoff: time.Duration(opts.RetryBackoffMs) * time.Millisecond,
+		Timeout:      time.Duration(opts.TimeoutSeconds) * time.Second,
+		QueueSize:    opts.QueueSize,
+		OnDelivery:   nks.onDelivery,
+	}
+	return nks.producer.Init()
+}
+
+func (nks *NativeKafkaSink) onDelivery(report kafkaclient.DeliveryReport) {
+	if report.Err != nil {
+		nks.logger.Warn("Delivery failed",
+			zap.String("topic", report.Topic),
+			zap.Int32("partition", report.Partition),
+			zap.Int("messages", report.Messages),
+			zap.String("error", report.Err.Error()),
+			zap.Int64("timestamp", time.Now().Unix()),
+		)
+	}
+}
+
+// Send produces metric to topic (asynchronously)
+func (nks *NativeKafkaSink) Send(metric protocol.Metric) error {
+	message, err := nks.formatter.formatLine(metric)
+	if err != nil {
+		return err
+	}
+	nks.logger.Debug("Produced to speed-racer: " + string(message))
+	return nks.producer.Produce(message)
+}
+
+// Stop waits for message deliveries before closing the producer.
+func (nks *NativeKafkaSink) Stop() error {
+	remaining := nks.producer.Flush(time.Duration(nks.flushTimeoutMs) * time.Millisecond)
+	nks.producer.Close()
+	if remaining > 0 {
+		return fmt.Errorf("%d messages not delivered in %dms", remaining, nks.flushTimeoutMs)
+	}
+	return nil
+}
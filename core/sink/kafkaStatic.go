@@ -0,0 +1,10 @@
+//go:build !cgo
+// +build !cgo
+
+package sink
+
+// Without cgo, e.g. CGO_ENABLED=0 for a static binary, librdkafka is not available,
+// so "kafka" is served by the pure Go client with the same options.
+func init() {
+	Register("kafka", func() Sink { return &NativeKafkaSink{} })
+}
@@ -0,0 +1,466 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/harbinzhang/goRainbow/core/protocol"
+	"github.com/harbinzhang/goRainbow/core/util"
+)
+
+func init() {
+	Register("otlp", func() Sink { return &OTLPSink{} })
+}
+
+// OTLPOptions is "options" of otlp sink.
+type OTLPOptions struct {
+	URL string `json:"url"`
+	// Encoding is "protobuf"(default) or "json".
+	Encoding             string            `json:"encoding"`
+	Headers              map[string]string `json:"headers"`
+	Gzip                 bool              `json:"gzip"`
+	Retries              int               `json:"retries"`
+	BackoffMs            int               `json:"backoffMs"`
+	BatchSize            int               `json:"batchSize"`
+	FlushIntervalSeconds int               `json:"flushIntervalSeconds"`
+	TimeoutSeconds       int               `json:"timeoutSeconds"`
+	// ExpirySeconds is how long the running total of a sum is kept without new data.
+	ExpirySeconds int `json:"expirySeconds"`
+}
+
+// otlpThroughputFamilies are throughput in records per minute, exported as cumulative sums of records.
+var otlpThroughputFamilies = map[string]bool{
+	"hosts":       true,
+	"offsetRate":  true,
+	"hostRecords": true,
+}
+
+// OTLPSink exports metrics to an OpenTelemetry collector over OTLP/HTTP.
+// Lags, offsets and the other metrics are gauges. Throughput(hosts, offsetRate and hostRecords)
+// and counters of CountService are monotonic sums with cumulative temporality, their running totals
+// are kept by the sink. Common tags from config.json are resource attributes.
+type OTLPSink struct {
+	sync.Mutex
+
+	opts     OTLPOptions
+	logger   *zap.Logger
+	writer   *httpWriter
+	resource []otlpKeyValue
+
+	points []otlpPoint
+	totals map[string]*otlpTotal
+
+	ticker      *time.Ticker
+	quitChannel chan struct{}
+}
+
+type otlpPoint struct {
+	name      string
+	sum       bool
+	tags      map[string]string
+	start     int64
+	timestamp int64
+	value     float64
+}
+
+// otlpTotal is the running total of a sum series.
+type otlpTotal struct {
+	start    int64
+	last     int64
+	total    float64
+	lastSeen time.Time
+}
+
+// Init is a general init
+func (ol *OTLPSink) Init(options json.RawMessage, logger *zap.Logger) error {
+	ol.logger = logger
+	ol.opts = OTLPOptions{
+		URL:                  "http://127.0.0.1:4318/v1/metrics",
+		Encoding:             "protobuf",
+		Gzip:                 true,
+		Retries:              3,
+		BackoffMs:            500,
+		BatchSize:            1000,
+		FlushIntervalSeconds: 10,
+		TimeoutSeconds:       10,
+		ExpirySeconds:        600,
+	}
+	if err := decodeOptions(options, &ol.opts); err != nil {
+		return err
+	}
+	if ol.opts.Encoding != "protobuf" && ol.opts.Encoding != "json" {
+		return errors.New("unknown otlp encoding: " + ol.opts.Encoding)
+	}
+	if ol.opts.BatchSize <= 0 || ol.opts.FlushIntervalSeconds <= 0 || ol.opts.TimeoutSeconds <= 0 {
+		return errors.New("otlp batchSize, flushIntervalSeconds and timeoutSeconds should be positive")
+	}
+	ol.writer = &httpWriter{
+		name:    "otlp",
+		url:     ol.opts.URL,
+		headers: ol.opts.Headers,
+		gzip:    ol.opts.Gzip,
+		retries: ol.opts.Retries,
+		backoff: time.Duration(ol.opts.BackoffMs) * time.Millisecond,
+		client:  &http.Client{Timeout: time.Duration(ol.opts.TimeoutSeconds) * time.Second},
+	}
+
+	contextProvider := util.ContextProvider{}
+	contextProvider.Init()
+	resourceTags := contextProvider.GetTags()
+	if name, ok := resourceTags["service_name"]; ok {
+		resourceTags["service.name"] = name
+	}
+	ol.resource = otlpAttributes(resourceTags)
+	ol.totals = make(map[string]*otlpTotal)
+
+	ol.ticker = time.NewTicker(time.Duration(ol.opts.FlushIntervalSeconds) * time.Second)
+	ol.quitChannel = make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ol.ticker.C:
+				if err := ol.flush(); err != nil {
+					ol.logger.Warn("otlp flush failed",
+						zap.String("error", err.Error()),
+						zap.Int64("timestamp", time.Now().Unix()),
+					)
+				}
+			case <-ol.quitChannel:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Send adds metric into batch, and exports the batch if it's full.
+func (ol *OTLPSink) Send(metric protocol.Metric) error {
+	if math.IsNaN(metric.Value) || math.IsInf(metric.Value, 0) {
+		return errors.New("otlp doesn't accept value " + util.FormatValue(metric.Value))
+	}
+	point := otlpPoint{
+		name:      metric.Name,
+		tags:      metric.Tags,
+		timestamp: metric.Timestamp,
+		value:     metric.Value,
+	}
+	isThroughput := otlpThroughputFamilies[metric.Family()]
+
+	ol.Lock()
+	if isThroughput || metric.Kind == protocol.KindCounter {
+		key := formatSeries(metric.Name, metric.Tags)
+		total, ok := ol.totals[key]
+		switch {
+		case !ok:
+			total = &otlpTotal{start: metric.Timestamp, last: metric.Timestamp}
+			ol.totals[key] = total
+			if metric.Kind == protocol.KindCounter {
+				total.total = metric.Value
+			}
+		case metric.Timestamp <= total.last:
+			// the running total can't go back in time, e.g. 0 rate of a finished consumer at the same poll.
+			ol.Unlock()
+			return nil
+		case isThroughput:
+			total.total += metric.Value / 60 * float64(metric.Timestamp-total.last)
+		default:
+			total.total += metric.Value
+		}
+		total.last = metric.Timestamp
+		total.lastSeen = time.Now()
+
+		point.sum = true
+		point.start = total.start
+		point.value = total.total
+	}
+	ol.points = append(ol.points, point)
+	isFull := len(ol.points) >= ol.opts.BatchSize
+	ol.Unlock()
+
+	if isFull {
+		return ol.flush()
+	}
+	return nil
+}
+
+// Stop exports the last batch.
+func (ol *OTLPSink) Stop() error {
+	ol.ticker.Stop()
+	close(ol.quitChannel)
+	return ol.flush()
+}
+
+// flush exports current batch, the batch is dropped if it still fails after retries.
+// Running totals not updated in ExpirySeconds are freed.
+func (ol *OTLPSink) flush() error {
+	ol.Lock()
+	points := ol.points
+	ol.points = nil
+	expiry := time.Now().Add(-time.Duration(ol.opts.ExpirySeconds) * time.Second)
+	for key, total := range ol.totals {
+		if total.lastSeen.Before(expiry) {
+			delete(ol.totals, key)
+		}
+	}
+	ol.Unlock()
+	if len(points) == 0 {
+		return nil
+	}
+
+	request := ol.newRequest(points)
+	if ol.opts.Encoding == "json" {
+		body, err := json.Marshal(request)
+		if err != nil {
+			return err
+		}
+		return ol.writer.write(body, "application/json")
+	}
+	return ol.writer.write(request.marshalProto(), "application/x-protobuf")
+}
+
+// newRequest groups points by metric name, in the order they are sent.
+func (ol *OTLPSink) newRequest(points []otlpPoint) *otlpRequest {
+	metrics := make([]otlpMetric, 0)
+	index := make(map[string]int)
+	for _, point := range points {
+		key := point.name
+		if point.sum {
+			key += ":sum"
+		}
+		i, ok := index[key]
+		if !ok {
+			i = len(metrics)
+			index[key] = i
+			metric := otlpMetric{Name: point.name}
+			if point.sum {
+				metric.Sum = &otlpSum{AggregationTemporality: otlpCumulative, IsMonotonic: true}
+			} else {
+				metric.Gauge = &otlpGauge{}
+			}
+			metrics = append(metrics, metric)
+		}
+
+		dataPoint := otlpDataPoint{
+			Attributes:   otlpAttributes(point.tags),
+			TimeUnixNano: uint64(point.timestamp) * uint64(time.Second),
+			AsDouble:     point.value,
+		}
+		if point.sum {
+			dataPoint.StartTimeUnixNano = uint64(point.start) * uint64(time.Second)
+			metrics[i].Sum.DataPoints = append(metrics[i].Sum.DataPoints, dataPoint)
+		} else {
+			metrics[i].Gauge.DataPoints = append(metrics[i].Gauge.DataPoints, dataPoint)
+		}
+	}
+
+	return &otlpRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource: otlpResource{Attributes: ol.resource},
+		ScopeMetrics: []otlpScopeMetrics{{
+			Scope:   otlpScope{Name: "goRainbow"},
+			Metrics: metrics,
+		}},
+	}}}
+}
+
+func otlpAttributes(tags map[string]string) []otlpKeyValue {
+	attributes := make([]otlpKeyValue, 0, len(tags))
+	for _, k := range util.SortedTagKeys(tags) {
+		attributes = append(attributes, otlpKeyValue{Key: k, Value: otlpAnyValue{StringValue: tags[k]}})
+	}
+	return attributes
+}
+
+// otlpCumulative is AGGREGATION_TEMPORALITY_CUMULATIVE.
+const otlpCumulative = 2
+
+// The types below are ExportMetricsServiceRequest of OTLP, the parts goRainbow uses.
+// Their json tags follow OTLP/JSON, marshalProto follows the field numbers of
+// opentelemetry/proto/collector/metrics/v1/metrics_service.proto.
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpMetric struct {
+	Name  string     `json:"name"`
+	Gauge *otlpGauge `json:"gauge,omitempty"`
+	Sum   *otlpSum   `json:"sum,omitempty"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+	AggregationTemporality int             `json:"aggregationTemporality"`
+	IsMonotonic            bool            `json:"isMonotonic"`
+}
+
+type otlpDataPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano uint64         `json:"startTimeUnixNano,string,omitempty"`
+	TimeUnixNano      uint64         `json:"timeUnixNano,string"`
+	AsDouble          float64        `json:"asDouble"`
+}
+
+// protoBuffer encodes protobuf messages field by field.
+type protoBuffer struct {
+	bytes.Buffer
+}
+
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+)
+
+func (pb *protoBuffer) tag(field int, wireType int) {
+	pb.varint(uint64(field<<3 | wireType))
+}
+
+func (pb *protoBuffer) varint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	pb.Write(buf[:binary.PutUvarint(buf[:], v)])
+}
+
+func (pb *protoBuffer) uintField(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	pb.tag(field, protoVarint)
+	pb.varint(v)
+}
+
+func (pb *protoBuffer) fixed64Field(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	pb.tag(field, protoFixed64)
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	pb.Write(buf[:])
+}
+
+func (pb *protoBuffer) stringField(field int, s string) {
+	if s == "" {
+		return
+	}
+	pb.tag(field, protoBytes)
+	pb.varint(uint64(len(s)))
+	pb.WriteString(s)
+}
+
+// messageField encodes an embedded message, which is always written even if it's empty.
+func (pb *protoBuffer) messageField(field int, encode func(*protoBuffer)) {
+	var message protoBuffer
+	encode(&message)
+	pb.tag(field, protoBytes)
+	pb.varint(uint64(message.Len()))
+	pb.Write(message.Bytes())
+}
+
+func (r *otlpRequest) marshalProto() []byte {
+	var pb protoBuffer
+	for _, rm := range r.ResourceMetrics {
+		pb.messageField(1, rm.encode)
+	}
+	return pb.Bytes()
+}
+
+func (rm otlpResourceMetrics) encode(pb *protoBuffer) {
+	pb.messageField(1, func(pb *protoBuffer) {
+		encodeAttributes(pb, 1, rm.Resource.Attributes)
+	})
+	for _, sm := range rm.ScopeMetrics {
+		pb.messageField(2, sm.encode)
+	}
+}
+
+func (sm otlpScopeMetrics) encode(pb *protoBuffer) {
+	pb.messageField(1, func(pb *protoBuffer) {
+		pb.stringField(1, sm.Scope.Name)
+	})
+	for _, m := range sm.Metrics {
+		pb.messageField(2, m.encode)
+	}
+}
+
+func (m otlpMetric) encode(pb *protoBuffer) {
+	pb.stringField(1, m.Name)
+	if m.Gauge != nil {
+		pb.messageField(5, func(pb *protoBuffer) {
+			encodeDataPoints(pb, m.Gauge.DataPoints)
+		})
+	}
+	if m.Sum != nil {
+		pb.messageField(7, func(pb *protoBuffer) {
+			encodeDataPoints(pb, m.Sum.DataPoints)
+			pb.uintField(2, uint64(m.Sum.AggregationTemporality))
+			if m.Sum.IsMonotonic {
+				pb.uintField(3, 1)
+			}
+		})
+	}
+}
+
+func encodeDataPoints(pb *protoBuffer, dataPoints []otlpDataPoint) {
+	for _, dp := range dataPoints {
+		pb.messageField(1, func(pb *protoBuffer) {
+			pb.fixed64Field(2, dp.StartTimeUnixNano)
+			pb.fixed64Field(3, dp.TimeUnixNano)
+			// as_double is in a oneof, so it's written even if it's 0.
+			pb.tag(4, protoFixed64)
+			var buf [8]byte
+			binary.LittleEndian.PutUint64(buf[:], math.Float64bits(dp.AsDouble))
+			pb.Write(buf[:])
+			encodeAttributes(pb, 7, dp.Attributes)
+		})
+	}
+}
+
+func encodeAttributes(pb *protoBuffer, field int, attributes []otlpKeyValue) {
+	for _, kv := range attributes {
+		pb.messageField(field, func(pb *protoBuffer) {
+			pb.stringField(1, kv.Key)
+			pb.messageField(2, func(pb *protoBuffer) {
+				pb.stringField(1, kv.Value.StringValue)
+			})
+		})
+	}
+}
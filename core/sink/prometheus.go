@@ -0,0 +1,67 @@
+package sink
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/harbinzhang/goRainbow/core/module"
+	"github.com/harbinzhang/goRainbow/core/protocol"
+	"github.com/harbinzhang/goRainbow/core/util"
+)
+
+func init() {
+	Register("prometheus", func() Sink { return &PrometheusSink{} })
+}
+
+// PrometheusOptions is "options" of prometheus sink.
+type PrometheusOptions struct {
+	Path          string `json:"path"`
+	ExpirySeconds int    `json:"expirySeconds"`
+}
+
+// PrometheusSink keeps metrics as gauges, they are scraped from Path on health_check server.
+type PrometheusSink struct {
+	path     string
+	exporter *module.PrometheusExporter
+}
+
+// Init is a general init
+func (ps *PrometheusSink) Init(options json.RawMessage, logger *zap.Logger) error {
+	opts := PrometheusOptions{
+		Path: util.DefaultPrometheusPath,
+	}
+	if err := decodeOptions(options, &opts); err != nil {
+		return err
+	}
+	ps.path = opts.Path
+
+	ps.exporter = &module.PrometheusExporter{
+		Expiry: time.Duration(opts.ExpirySeconds) * time.Second,
+	}
+	ps.exporter.Init()
+	return nil
+}
+
+// Send is a general send
+func (ps *PrometheusSink) Send(metric protocol.Metric) error {
+	ps.exporter.Update(metric)
+	return nil
+}
+
+// Stop is a general stop
+func (ps *PrometheusSink) Stop() error {
+	return nil
+}
+
+// Path is where the gauges are served.
+func (ps *PrometheusSink) Path() string {
+	return ps.path
+}
+
+// Handler serves gauges in Prometheus text format.
+func (ps *PrometheusSink) Handler() func(http.ResponseWriter, *http.Request) {
+	return module.PrometheusHandler(ps.exporter)
+}
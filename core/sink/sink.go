@@ -0,0 +1,120 @@
+package sink
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/harbinzhang/goRainbow/core/protocol"
+	"github.com/harbinzhang/goRainbow/core/util"
+)
+
+// Sink is a destination of metrics.
+// Send is only called by its own Worker, so a Sink doesn't need to be goroutine safe for Send.
+type Sink interface {
+	// Init prepares the sink with its own "options" from config.json.
+	Init(options json.RawMessage, logger *zap.Logger) error
+	Send(metric protocol.Metric) error
+	Stop() error
+}
+
+// HTTPSink is a sink which is pulled over HTTP instead of pushing, e.g. prometheus.
+type HTTPSink interface {
+	Sink
+	Path() string
+	Handler() func(http.ResponseWriter, *http.Request)
+}
+
+var (
+	registryLock sync.Mutex
+	registry     = make(map[string]func() Sink)
+)
+
+// Register makes a sink type available in config.json.
+// It panics if the type is registered twice.
+func Register(sinkType string, factory func() Sink) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if _, ok := registry[sinkType]; ok {
+		panic("sink type registered twice: " + sinkType)
+	}
+	registry[sinkType] = factory
+}
+
+// New creates a sink by its type.
+func New(sinkType string) (Sink, error) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	factory, ok := registry[sinkType]
+	if !ok {
+		return nil, errors.New("unknown sink type: " + sinkType)
+	}
+	return factory(), nil
+}
+
+// Types returns all registered sink types.
+func Types() []string {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	types := make([]string, 0, len(registry))
+	for t := range registry {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// decodeOptions decodes options into target, empty options keeps target as it is.
+func decodeOptions(options json.RawMessage, target interface{}) error {
+	if len(options) == 0 {
+		return nil
+	}
+	return json.Unmarshal(options, target)
+}
+
+// lineFormatter serializes a metric into one line, common tags from config.json included.
+type lineFormatter struct {
+	format     string
+	postfix    string
+	commonTags map[string]string
+}
+
+func newLineFormatter(format string) (*lineFormatter, error) {
+	if format == "" {
+		format = "wavefront"
+	}
+	if format != "wavefront" && format != "json" {
+		return nil, errors.New("unknown format: " + format)
+	}
+
+	contextProvider := util.ContextProvider{}
+	contextProvider.Init()
+
+	return &lineFormatter{
+		format:     format,
+		postfix:    contextProvider.GetPostfix(),
+		commonTags: contextProvider.GetTags(),
+	}, nil
+}
+
+func (lf *lineFormatter) formatLine(metric protocol.Metric) ([]byte, error) {
+	if lf.format == "json" {
+		tags := make(map[string]string, len(lf.commonTags)+len(metric.Tags))
+		for k, v := range lf.commonTags {
+			tags[k] = v
+		}
+		for k, v := range metric.Tags {
+			tags[k] = v
+		}
+		metric.Tags = tags
+		return json.Marshal(metric)
+	}
+	return []byte(util.FormatWavefront(metric, lf.postfix)), nil
+}
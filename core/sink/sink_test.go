@@ -0,0 +1,498 @@
+package sink
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/harbinzhang/goRainbow/core/module"
+	"github.com/harbinzhang/goRainbow/core/protocol"
+)
+
+func TestMain(m *testing.M) {
+	os.Setenv("configPath", "../../config/config.json")
+	os.Exit(m.Run())
+}
+
+// blockingSink blocks in Send until release is closed.
+type blockingSink struct {
+	release chan struct{}
+	sent    chan protocol.Metric
+}
+
+func (bs *blockingSink) Init(options json.RawMessage, logger *zap.Logger) error { return nil }
+func (bs *blockingSink) Stop() error                                            { return nil }
+func (bs *blockingSink) Send(metric protocol.Metric) error {
+	<-bs.release
+	bs.sent <- metric
+	return nil
+}
+
+// failingSink always fails, or panics if metric value is negative.
+type failingSink struct{}
+
+func (fs *failingSink) Init(options json.RawMessage, logger *zap.Logger) error { return nil }
+func (fs *failingSink) Stop() error                                            { return nil }
+func (fs *failingSink) Send(metric protocol.Metric) error {
+	if metric.Value < 0 {
+		panic("negative value")
+	}
+	return errors.New("always fails")
+}
+
+func newTestWorker(name string, s Sink, bufferSize int) *Worker {
+	produceQueue := make(chan protocol.Metric, 100)
+	countService := &module.CountService{ProduceQueue: produceQueue}
+	countService.Start()
+
+	worker := &Worker{
+		Name:         name,
+		Sink:         s,
+		CountService: countService,
+		Logger:       zap.NewNop(),
+	}
+	worker.Init(bufferSize)
+	return worker
+}
+
+func TestSinkConfigUnmarshal(t *testing.T) {
+	var confs []protocol.SinkConfig
+	err := json.Unmarshal([]byte(`["kafka", {"type": "file", "name": "local", "bufferSize": 10, "options": {"path": "/tmp/x"}}]`), &confs)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(confs))
+	assert.Equal(t, "kafka", confs[0].Type)
+	assert.Equal(t, "file", confs[1].Type)
+	assert.Equal(t, "local", confs[1].Name)
+	assert.Equal(t, 10, confs[1].BufferSize)
+	assert.Equal(t, `{"path": "/tmp/x"}`, string(confs[1].Options))
+}
+
+func TestRegistry(t *testing.T) {
+	for _, sinkType := range []string{"kafka", "file", "http", "stdout", "prometheus", "graphite", "influxdb", "statsd", "otlp", "kafka-native"} {
+		_, err := New(sinkType)
+		assert.Nil(t, err, sinkType+" should be registered")
+	}
+	_, err := New("unknown")
+	assert.NotNil(t, err, "unknown sink should fail")
+
+	_, err = NewWorkers([]protocol.SinkConfig{{Type: "stdout"}, {Type: "stdout"}}, nil, zap.NewNop())
+	assert.NotNil(t, err, "duplicated sink name should fail")
+}
+
+func TestStuckSinkDoesNotBlockOthers(t *testing.T) {
+	stuck := &blockingSink{release: make(chan struct{}), sent: make(chan protocol.Metric, 10)}
+	healthy := &blockingSink{release: make(chan struct{}), sent: make(chan protocol.Metric, 10)}
+	close(healthy.release)
+
+	stuckWorker := newTestWorker("stuck", stuck, 1)
+	healthyWorker := newTestWorker("healthy", healthy, 10)
+	go stuckWorker.Start()
+	go healthyWorker.Start()
+
+	for i := 0; i < 5; i++ {
+		metric := protocol.Metric{Name: "test", Value: float64(i)}
+		stuckWorker.Offer(metric)
+		assert.Equal(t, true, healthyWorker.Offer(metric), "healthy sink should accept metric")
+	}
+	assert.Equal(t, false, stuckWorker.Offer(protocol.Metric{Name: "test"}), "stuck sink should drop metric")
+
+	for i := 0; i < 5; i++ {
+		select {
+		case metric := <-healthy.sent:
+			assert.Equal(t, float64(i), metric.Value)
+		case <-time.After(time.Second):
+			t.Fatal("healthy sink is blocked")
+		}
+	}
+
+	close(stuck.release)
+	assert.Nil(t, stuckWorker.Stop())
+	assert.Nil(t, healthyWorker.Stop())
+}
+
+func TestWorkerCountsDrops(t *testing.T) {
+	produceQueue := make(chan protocol.Metric, 100)
+	countService := &module.CountService{ProduceQueue: produceQueue, Interval: 50 * time.Millisecond}
+	countService.Start()
+	stuck := &blockingSink{release: make(chan struct{}), sent: make(chan protocol.Metric, 10)}
+	worker := &Worker{Name: "stuck", Sink: stuck, CountService: countService, Logger: zap.NewNop()}
+	worker.Init(1)
+	go worker.Start()
+
+	// one is taken by the sink, one is buffered, the others are dropped.
+	for i := 0; i < 5; i++ {
+		worker.Offer(protocol.Metric{Name: "test"})
+	}
+	close(stuck.release)
+	assert.Nil(t, worker.Stop())
+
+	select {
+	case metric := <-produceQueue:
+		assert.True(t, strings.HasSuffix(metric.Name, ".exception.sinkDropped.stuck"), metric.Name)
+		assert.True(t, metric.Value >= 3, "drops should be counted")
+	case <-time.After(time.Second):
+		t.Fatal("drops are not reported")
+	}
+	countService.Stop()
+}
+
+func TestWorkerRecoversFromSinkFailure(t *testing.T) {
+	worker := newTestWorker("failing", &failingSink{}, 10)
+	go worker.Start()
+
+	worker.Offer(protocol.Metric{Name: "test", Value: -1})
+	worker.Offer(protocol.Metric{Name: "test", Value: 1})
+	assert.Nil(t, worker.Stop(), "worker should survive a failing sink")
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sink")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "metrics.log")
+	fs := &FileSink{}
+	assert.Nil(t, fs.Init(json.RawMessage(`{"path": "`+path+`", "format": "json"}`), zap.NewNop()))
+	assert.Nil(t, fs.Send(protocol.Metric{Name: "fjord.burrow.test.totalLag", Value: 3, Timestamp: 10, Tags: map[string]string{"env": "test"}}))
+	assert.Nil(t, fs.Stop())
+
+	content, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	var metric protocol.Metric
+	assert.Nil(t, json.Unmarshal(content, &metric))
+	assert.Equal(t, float64(3), metric.Value)
+	assert.Equal(t, "test", metric.Tags["env"])
+	assert.Equal(t, "goRainbow", metric.Tags["service_name"], "common tags should be included")
+}
+
+func TestHTTPPostSink(t *testing.T) {
+	bodies := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- string(body)
+	}))
+	defer server.Close()
+
+	hs := &HTTPPostSink{}
+	assert.Nil(t, hs.Init(json.RawMessage(`{"url": "`+server.URL+`", "batchSize": 2}`), zap.NewNop()))
+	assert.Nil(t, hs.Send(protocol.Metric{Name: "a", Value: 1, Timestamp: 10}))
+	assert.Nil(t, hs.Send(protocol.Metric{Name: "b", Value: 2, Timestamp: 10}))
+	assert.Nil(t, hs.Send(protocol.Metric{Name: "c", Value: 3, Timestamp: 10}))
+
+	lines := strings.Split(strings.TrimSpace(<-bodies), "\n")
+	assert.Equal(t, 2, len(lines), "a full batch should be posted")
+	assert.True(t, strings.HasPrefix(lines[0], "a 1 10 "))
+
+	assert.Nil(t, hs.Stop())
+	assert.True(t, strings.HasPrefix(<-bodies, "c 3 10 "), "the last batch should be posted on stop")
+}
+
+// acceptLines serves carbon plaintext on listener, lines from all connections are sent to the channel.
+func acceptLines(listener net.Listener) chan string {
+	lines := make(chan string, 100)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					lines <- scanner.Text()
+				}
+			}()
+		}
+	}()
+	return lines
+}
+
+func TestGraphiteSink(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	lines := acceptLines(listener)
+
+	gs := &GraphiteSink{}
+	assert.Nil(t, gs.Init(json.RawMessage(`{"address": "`+listener.Addr().String()+`", "batchSize": 2}`), zap.NewNop()))
+	assert.Nil(t, gs.Send(protocol.Metric{Name: "fjord.burrow.test.totalLag", Value: 3, Timestamp: 10, Tags: map[string]string{"env": "a b;c"}}))
+	assert.Nil(t, gs.Send(protocol.Metric{Name: "fjord.burrow.test.maxLag", Value: 1.5, Timestamp: 10}))
+
+	line := <-lines
+	assert.True(t, strings.HasPrefix(line, "fjord.burrow.test.totalLag;"), "tags should be appended to path")
+	assert.Contains(t, line, ";env=a_b_c;", "tag values should be sanitized")
+	assert.Contains(t, line, ";service_name=goRainbow", "common tags should be included")
+	assert.True(t, strings.HasSuffix(line, " 3 10"))
+	assert.True(t, strings.HasSuffix(<-lines, " 1.5 10"))
+	assert.Nil(t, gs.Stop())
+
+	assert.NotNil(t, (&GraphiteSink{}).Init(json.RawMessage(`{"protocol": "udp"}`), zap.NewNop()), "unknown protocol should be invalid")
+}
+
+func TestGraphiteSinkBuffersDuringOutage(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+
+	gs := &GraphiteSink{}
+	options := `{"address": "` + address + `", "tags": false, "poolSize": 1, "batchSize": 1, "maxBuffered": 2, ` +
+		`"flushIntervalSeconds": 1, "backoffMs": 10, "maxBackoffMs": 20}`
+	assert.Nil(t, gs.Init(json.RawMessage(options), zap.NewNop()))
+	for i := 1; i <= 3; i++ {
+		assert.Nil(t, gs.Send(protocol.Metric{Name: "lag", Value: float64(i), Timestamp: 10}))
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	listener, err = net.Listen("tcp", address)
+	assert.Nil(t, err)
+	defer listener.Close()
+	lines := acceptLines(listener)
+
+	assert.Equal(t, "lag 2 10", <-lines, "the oldest metric should be dropped when buffer is full")
+	assert.Equal(t, "lag 3 10", <-lines)
+	assert.Nil(t, gs.Stop())
+}
+
+func TestEncodePickle(t *testing.T) {
+	payload := encodePickle([]graphitePoint{{path: "a.b", value: 1, timestamp: 10}})
+
+	assert.Equal(t, uint32(len(payload)-4), binary.BigEndian.Uint32(payload), "header should be the length of pickle")
+	assert.Equal(t, []byte{0x80, 2, ']', '(', 'X', 3, 0, 0, 0, 'a', '.', 'b', 'J', 10, 0, 0, 0, 'G'}, payload[4:22])
+	assert.Equal(t, []byte{0x86, 0x86, 'e', '.'}, payload[len(payload)-4:])
+}
+
+func TestInfluxSinkHTTP(t *testing.T) {
+	var requests int32
+	bodies := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		assert.Equal(t, "s", r.URL.Query().Get("precision"))
+		assert.Equal(t, "rainbow", r.URL.Query().Get("db"))
+		gz, err := gzip.NewReader(r.Body)
+		assert.Nil(t, err)
+		body, _ := ioutil.ReadAll(gz)
+		bodies <- string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	is := &InfluxSink{}
+	assert.Nil(t, is.Init(json.RawMessage(`{"url": "`+server.URL+`/write?db=rainbow", "backoffMs": 1}`), zap.NewNop()))
+	tags := map[string]string{"env": "c1", "consumer": "g1", "topic": "t1", "partition": "0"}
+	assert.Nil(t, is.Send(protocol.Metric{Name: "fjord.burrow.c1.g1.t1.0.Lag", Value: 3, Timestamp: 10, Tags: tags}))
+	assert.Nil(t, is.Send(protocol.Metric{Name: "fjord.burrow.c1.g1.t1.0.startOffset", Value: 97, Timestamp: 10, Tags: tags}))
+	assert.Nil(t, is.Send(protocol.Metric{Name: "fjord.burrow.c1.g1.t1.0.endOffset", Value: 100, Timestamp: 10, Tags: tags}))
+	assert.Nil(t, is.Send(protocol.Metric{Name: "fjord.burrow.c1.g1.hosts.0", Value: 60, Timestamp: 10, Tags: map[string]string{"owner": "host 1"}}))
+	assert.Nil(t, is.Stop())
+
+	lines := strings.Split(strings.TrimSpace(<-bodies), "\n")
+	assert.Equal(t, 2, len(lines), "fields of a partition should be grouped into one line")
+	assert.True(t, strings.HasPrefix(lines[0], "consumer_lag,cluster=c1,consumer=g1,"), "measurement should not have values of name")
+	assert.Contains(t, lines[0], ",group=g1,")
+	assert.Contains(t, lines[0], ",partition=0,")
+	assert.Contains(t, lines[0], ",topic=t1")
+	assert.True(t, strings.HasSuffix(lines[0], " Lag=3,endOffset=100,startOffset=97 10"))
+	assert.True(t, strings.HasPrefix(lines[1], "consumer_lag,cluster=c1,"))
+	assert.Contains(t, lines[1], ",owner=host\\ 1,", "tag values should be escaped")
+	assert.Contains(t, lines[1], ",partition=0,")
+	assert.True(t, strings.HasSuffix(lines[1], " hosts=60 10"))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests), "5xx should be retried")
+}
+
+func TestInfluxSinkUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	is := &InfluxSink{}
+	assert.Nil(t, is.Init(json.RawMessage(`{"transport": "udp", "address": "`+conn.LocalAddr().String()+`"}`), zap.NewNop()))
+	assert.Nil(t, is.Send(protocol.Metric{Name: "fjord.burrow.c1.topic.t1.0.offset", Value: 100, Timestamp: 10}))
+	assert.Nil(t, is.Stop())
+
+	packet := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(packet)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(packet[:n]), "topic_offset,cluster=c1,"))
+	assert.Contains(t, string(packet[:n]), ",partition=0,")
+	assert.Contains(t, string(packet[:n]), ",topic=t1")
+	assert.True(t, strings.HasSuffix(string(packet[:n]), " offset=100 10\n"))
+}
+
+func TestInfluxSplitSeries(t *testing.T) {
+	measurement, field, tags := splitSeries(protocol.Metric{Name: "fjord.burrow.c1.g1.t1.topicTotalLag"})
+	assert.Equal(t, "consumer_lag", measurement)
+	assert.Equal(t, "topicTotalLag", field)
+	assert.Equal(t, map[string]string{"cluster": "c1", "group": "g1", "topic": "t1"}, tags)
+
+	measurement, field, tags = splitSeries(protocol.Metric{Name: "fjord.burrow.c1.g1.totalLag"})
+	assert.Equal(t, "consumer_lag", measurement)
+	assert.Equal(t, "totalLag", field)
+	assert.Equal(t, map[string]string{"cluster": "c1", "group": "g1"}, tags)
+
+	measurement, field, tags = splitSeries(protocol.Metric{Name: "fjord.burrow.c1.exception.sinkDropped.kafka",
+		Kind: protocol.KindCounter, Tags: map[string]string{"env": "c1"}})
+	assert.Equal(t, "rainbow", measurement)
+	assert.Equal(t, "exception.sinkDropped.kafka", field)
+	assert.Equal(t, 0, len(tags))
+}
+
+func TestStatsdSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer conn.Close()
+	readPacket := func() string {
+		packet := make([]byte, 1500)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(packet)
+		assert.Nil(t, err)
+		return string(packet[:n])
+	}
+
+	ss := &StatsdSink{}
+	assert.Nil(t, ss.Init(json.RawMessage(`{"address": "`+conn.LocalAddr().String()+`", "maxPacketSize": 400}`), zap.NewNop()))
+	assert.Nil(t, ss.Send(protocol.Metric{Name: "fjord.burrow.c1.g1.totalLag", Value: 3, Timestamp: 10, Tags: map[string]string{"consumer": "g1"}}))
+	assert.Nil(t, ss.Send(protocol.Metric{Name: "fjord.burrow.c1.totalMessage", Value: 0, Kind: protocol.KindCounter}))
+	assert.Nil(t, ss.Send(protocol.Metric{Name: "fjord.burrow.c1.totalMessage", Value: 2, Kind: protocol.KindCounter}))
+	assert.Nil(t, ss.Send(protocol.Metric{Name: "fjord.burrow.c1.totalMessage", Value: 3, Kind: protocol.KindCounter}))
+	assert.Nil(t, ss.Send(protocol.Metric{Name: "fjord.burrow.c1.g1.eta", Value: -1, Timestamp: 10}))
+
+	lines := strings.Split(readPacket(), "\n")
+	assert.Equal(t, 2, len(lines), "lines should be batched until packet is full, counters of 0 are skipped")
+	assert.True(t, strings.HasPrefix(lines[0], "fjord.burrow.c1.g1.totalLag:3|g|#"))
+	assert.Contains(t, lines[0], ",consumer:g1", "metric tags should follow common tags")
+	assert.True(t, strings.HasPrefix(lines[1], "fjord.burrow.c1.totalMessage:2|c|#"))
+	assert.Nil(t, ss.Stop())
+	lines = strings.Split(readPacket(), "\n")
+	assert.Equal(t, 2, len(lines), "the last packet should be sent on stop")
+	assert.True(t, strings.HasPrefix(lines[0], "fjord.burrow.c1.totalMessage:3|c|#"), "counters are sent as increments of each report, not running totals")
+	assert.True(t, strings.HasPrefix(lines[1], "fjord.burrow.c1.g1.eta:-1|g|#"))
+
+	ss = &StatsdSink{}
+	assert.Nil(t, ss.Init(json.RawMessage(`{"address": "`+conn.LocalAddr().String()+`", "flavor": "statsd"}`), zap.NewNop()))
+	assert.Nil(t, ss.Send(protocol.Metric{Name: "fjord.burrow.c1.g1.eta", Value: -1, Timestamp: 10, Tags: map[string]string{"consumer": "g1"}}))
+	assert.Nil(t, ss.Stop())
+	assert.Equal(t, "fjord.burrow.c1.g1.eta:0|g\nfjord.burrow.c1.g1.eta:-1|g", readPacket(), "a negative gauge should be reset to 0 first")
+
+	// the reset and the value are not split into two packets.
+	ss = &StatsdSink{}
+	assert.Nil(t, ss.Init(json.RawMessage(`{"address": "`+conn.LocalAddr().String()+`", "flavor": "statsd", "maxPacketSize": 60}`), zap.NewNop()))
+	assert.Nil(t, ss.Send(protocol.Metric{Name: "fjord.burrow.c1.g1.totalLag", Value: 3}))
+	assert.Nil(t, ss.Send(protocol.Metric{Name: "fjord.burrow.c1.g1.eta", Value: -1}))
+	assert.Nil(t, ss.Stop())
+	assert.Equal(t, "fjord.burrow.c1.g1.totalLag:3|g", readPacket())
+	assert.Equal(t, "fjord.burrow.c1.g1.eta:0|g\nfjord.burrow.c1.g1.eta:-1|g", readPacket())
+}
+
+func TestOTLPSinkJSON(t *testing.T) {
+	requests := make(chan otlpRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var request otlpRequest
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&request))
+		requests <- request
+	}))
+	defer server.Close()
+
+	ol := &OTLPSink{}
+	assert.Nil(t, ol.Init(json.RawMessage(`{"url": "`+server.URL+`/v1/metrics", "encoding": "json", "gzip": false}`), zap.NewNop()))
+	owner := map[string]string{"owner": "host1"}
+	assert.Nil(t, ol.Send(protocol.Metric{Name: "fjord.burrow.c1.g1.totalLag", Value: 3, Timestamp: 10}))
+	assert.Nil(t, ol.Send(protocol.Metric{Name: "fjord.burrow.c1.g1.hosts.0", Value: 60, Timestamp: 10, Tags: owner}))
+	assert.Nil(t, ol.Send(protocol.Metric{Name: "fjord.burrow.c1.g1.hosts.0", Value: 60, Timestamp: 40, Tags: owner}))
+	assert.Nil(t, ol.Send(protocol.Metric{Name: "fjord.burrow.c1.totalMessage", Value: 2, Timestamp: 10, Kind: protocol.KindCounter}))
+	assert.Nil(t, ol.Send(protocol.Metric{Name: "fjord.burrow.c1.totalMessage", Value: 3, Timestamp: 70, Kind: protocol.KindCounter}))
+	assert.Nil(t, ol.Stop())
+
+	request := <-requests
+	assert.Equal(t, 1, len(request.ResourceMetrics))
+	resource := make(map[string]string)
+	for _, kv := range request.ResourceMetrics[0].Resource.Attributes {
+		resource[kv.Key] = kv.Value.StringValue
+	}
+	assert.Equal(t, "goRainbow", resource["service.name"], "common tags should be resource attributes")
+
+	metrics := request.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	assert.Equal(t, 3, len(metrics), "points should be grouped by metric name")
+	assert.NotNil(t, metrics[0].Gauge, "lag should be a gauge")
+	assert.Equal(t, uint64(10*time.Second), metrics[0].Gauge.DataPoints[0].TimeUnixNano)
+
+	hosts := metrics[1].Sum
+	assert.NotNil(t, hosts, "throughput should be a sum")
+	assert.Equal(t, otlpCumulative, hosts.AggregationTemporality)
+	assert.True(t, hosts.IsMonotonic)
+	assert.Equal(t, 2, len(hosts.DataPoints))
+	assert.Equal(t, float64(30), hosts.DataPoints[1].AsDouble, "60 records per minute over 30s")
+	assert.Equal(t, uint64(10*time.Second), hosts.DataPoints[1].StartTimeUnixNano)
+	assert.Equal(t, "owner", hosts.DataPoints[1].Attributes[0].Key)
+
+	counter := metrics[2].Sum
+	assert.NotNil(t, counter, "counters should be sums")
+	assert.Equal(t, float64(5), counter.DataPoints[1].AsDouble, "counts should be accumulated")
+}
+
+func TestOTLPSinkProtobuf(t *testing.T) {
+	bodies := make(chan []byte, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+		gz, err := gzip.NewReader(r.Body)
+		assert.Nil(t, err)
+		body, _ := ioutil.ReadAll(gz)
+		bodies <- body
+	}))
+	defer server.Close()
+
+	ol := &OTLPSink{}
+	assert.Nil(t, ol.Init(json.RawMessage(`{"url": "`+server.URL+`/v1/metrics"}`), zap.NewNop()))
+	assert.Nil(t, ol.Send(protocol.Metric{Name: "lag", Value: 1, Timestamp: 1}))
+	assert.Nil(t, ol.Stop())
+	body := <-bodies
+	assert.Equal(t, ol.newRequest([]otlpPoint{{name: "lag", value: 1, timestamp: 1}}).marshalProto(), body)
+
+	// Metric{name: "lag", gauge: {data_points: [{time_unix_nano: 1s, as_double: 1}]}}
+	metric := otlpMetric{Name: "lag", Gauge: &otlpGauge{DataPoints: []otlpDataPoint{{TimeUnixNano: uint64(time.Second), AsDouble: 1}}}}
+	var pb protoBuffer
+	metric.encode(&pb)
+	assert.Equal(t, []byte{
+		0x0a, 3, 'l', 'a', 'g',
+		0x2a, 20, 0x0a, 18,
+		0x19, 0x00, 0xca, 0x9a, 0x3b, 0, 0, 0, 0,
+		0x21, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f,
+	}, pb.Bytes())
+}
+
+func TestNativeKafkaSink(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	listener.Close()
+
+	s := &NativeKafkaSink{}
+	options := `{"brokerServers": "` + listener.Addr().String() + `", "topic": "metrics", "flushTimeoutMs": 100}`
+	assert.Nil(t, s.Init(json.RawMessage(options), zap.NewNop()))
+	assert.Nil(t, s.Send(protocol.Metric{Name: "fjord.burrow.c.g.Lag", Value: 3, Timestamp: 1}))
+	assert.NotNil(t, s.Stop(), "undelivered messages should be reported on stop")
+
+	s = &NativeKafkaSink{}
+	assert.NotNil(t, s.Init(json.RawMessage(`{"compression": "snappy"}`), zap.NewNop()), "snappy is not supported")
+}
@@ -0,0 +1,201 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/harbinzhang/goRainbow/core/protocol"
+	"github.com/harbinzhang/goRainbow/core/util"
+)
+
+func init() {
+	Register("statsd", func() Sink { return &StatsdSink{} })
+}
+
+// StatsdOptions is "options" of statsd sink.
+type StatsdOptions struct {
+	Address string `json:"address"`
+	// Flavor is "dogstatsd"(default), which sends tags as "|#k:v,k:v", or "statsd" without tags.
+	Flavor string `json:"flavor"`
+	// MaxPacketSize bounds the lines batched in one UDP packet, keep it under MTU.
+	MaxPacketSize        int `json:"maxPacketSize"`
+	FlushIntervalSeconds int `json:"flushIntervalSeconds"`
+}
+
+// StatsdSink sends metrics over UDP in StatsD/DogStatsD format, gauges as "|g"
+// and counters of CountService as "|c" increments, i.e. the count of the last report interval,
+// never a running total, as StatsD sums the increments itself.
+// Lines are batched into packets up to MaxPacketSize, a packet is sent when it's full
+// or every FlushIntervalSeconds. Timestamps are dropped, StatsD uses the time received.
+type StatsdSink struct {
+	sync.Mutex
+
+	opts    StatsdOptions
+	logger  *zap.Logger
+	conn    net.Conn
+	tagPart string
+	packet  bytes.Buffer
+
+	ticker      *time.Ticker
+	quitChannel chan struct{}
+}
+
+// Init is a general init
+func (ss *StatsdSink) Init(options json.RawMessage, logger *zap.Logger) error {
+	ss.logger = logger
+	ss.opts = StatsdOptions{
+		Address:              "127.0.0.1:8125",
+		Flavor:               "dogstatsd",
+		MaxPacketSize:        1432,
+		FlushIntervalSeconds: 1,
+	}
+	if err := decodeOptions(options, &ss.opts); err != nil {
+		return err
+	}
+	if ss.opts.Flavor != "dogstatsd" && ss.opts.Flavor != "statsd" {
+		return errors.New("unknown statsd flavor: " + ss.opts.Flavor)
+	}
+	if ss.opts.MaxPacketSize <= 0 || ss.opts.FlushIntervalSeconds <= 0 {
+		return errors.New("statsd maxPacketSize and flushIntervalSeconds should be positive")
+	}
+
+	conn, err := net.Dial("udp", ss.opts.Address)
+	if err != nil {
+		return err
+	}
+	ss.conn = conn
+
+	if ss.opts.Flavor == "dogstatsd" {
+		contextProvider := util.ContextProvider{}
+		contextProvider.Init()
+		ss.tagPart = formatDogstatsdTags(contextProvider.GetTags())
+	}
+
+	ss.ticker = time.NewTicker(time.Duration(ss.opts.FlushIntervalSeconds) * time.Second)
+	ss.quitChannel = make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ss.ticker.C:
+				ss.Lock()
+				err := ss.flush()
+				ss.Unlock()
+				if err != nil {
+					ss.logger.Warn("statsd flush failed",
+						zap.String("error", err.Error()),
+						zap.Int64("timestamp", time.Now().Unix()),
+					)
+				}
+			case <-ss.quitChannel:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Send adds metric into packet, the packet is sent first if metric doesn't fit in.
+// Lines of a metric are kept in one packet.
+func (ss *StatsdSink) Send(metric protocol.Metric) error {
+	if metric.Kind == protocol.KindCounter && metric.Value == 0 {
+		return nil
+	}
+	lines := ss.formatLines(metric)
+	size := len(lines) - 1
+	for _, line := range lines {
+		size += len(line)
+	}
+
+	ss.Lock()
+	defer ss.Unlock()
+	var err error
+	if ss.packet.Len() > 0 && ss.packet.Len()+1+size > ss.opts.MaxPacketSize {
+		err = ss.flush()
+	}
+	for _, line := range lines {
+		if ss.packet.Len() > 0 {
+			ss.packet.WriteByte('\n')
+		}
+		ss.packet.WriteString(line)
+	}
+	return err
+}
+
+// Stop sends the last packet.
+func (ss *StatsdSink) Stop() error {
+	ss.ticker.Stop()
+	close(ss.quitChannel)
+
+	ss.Lock()
+	defer ss.Unlock()
+	err := ss.flush()
+	ss.conn.Close()
+	return err
+}
+
+// flush sends current packet, it should be called with lock held.
+func (ss *StatsdSink) flush() error {
+	if ss.packet.Len() == 0 {
+		return nil
+	}
+	_, err := ss.conn.Write(ss.packet.Bytes())
+	ss.packet.Reset()
+	return err
+}
+
+// formatLines serializes metric into "name:value|type|#k:v" lines.
+// StatsD(not DogStatsD) takes a negative gauge as a decrement, so the gauge is set to 0 before it,
+// both lines are in the same packet and StatsD applies them at once, at the time received.
+func (ss *StatsdSink) formatLines(metric protocol.Metric) []string {
+	name := statsdNameReplacer.Replace(metric.Name)
+	suffix := "|g"
+	if metric.Kind == protocol.KindCounter {
+		suffix = "|c"
+	}
+	if ss.opts.Flavor == "dogstatsd" {
+		tags := ss.tagPart
+		if metricTags := formatDogstatsdTags(metric.Tags); metricTags != "" {
+			if tags != "" {
+				tags += ","
+			}
+			tags += metricTags
+		}
+		if tags != "" {
+			suffix += "|#" + tags
+		}
+	}
+
+	line := name + ":" + util.FormatValue(metric.Value) + suffix
+	if ss.opts.Flavor == "statsd" && metric.Kind == protocol.KindGauge && metric.Value < 0 {
+		return []string{name + ":0" + suffix, line}
+	}
+	return []string{line}
+}
+
+// formatDogstatsdTags serializes tags as "k:v,k:v" sorted by key.
+func formatDogstatsdTags(tags map[string]string) string {
+	parts := make([]string, 0, len(tags))
+	for _, k := range util.SortedTagKeys(tags) {
+		if k == "" || tags[k] == "" {
+			continue
+		}
+		parts = append(parts, statsdTagKeyReplacer.Replace(k)+":"+statsdTagReplacer.Replace(tags[k]))
+	}
+	return strings.Join(parts, ",")
+}
+
+var (
+	// statsdNameReplacer keeps the separators of StatsD format out of names.
+	statsdNameReplacer = strings.NewReplacer(":", "_", "|", "_", "@", "_", "\n", "_", " ", "_")
+	// statsdTagReplacer keeps the separators of DogStatsD tags out of tags.
+	statsdTagReplacer = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_", " ", "_")
+	// statsdTagKeyReplacer also keeps ":" out of tag keys.
+	statsdTagKeyReplacer = strings.NewReplacer(":", "_", ",", "_", "|", "_", "#", "_", "\n", "_", " ", "_")
+)
@@ -0,0 +1,50 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/harbinzhang/goRainbow/core/protocol"
+)
+
+func init() {
+	Register("stdout", func() Sink { return &StdoutSink{} })
+}
+
+// StdoutOptions is "options" of stdout sink.
+type StdoutOptions struct {
+	Format string `json:"format"`
+}
+
+// StdoutSink prints metrics, it's useful for debugging.
+type StdoutSink struct {
+	formatter *lineFormatter
+}
+
+// Init is a general init
+func (ss *StdoutSink) Init(options json.RawMessage, logger *zap.Logger) error {
+	var opts StdoutOptions
+	if err := decodeOptions(options, &opts); err != nil {
+		return err
+	}
+	formatter, err := newLineFormatter(opts.Format)
+	ss.formatter = formatter
+	return err
+}
+
+// Send is a general send
+func (ss *StdoutSink) Send(metric protocol.Metric) error {
+	line, err := ss.formatter.formatLine(metric)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Println(string(line))
+	return err
+}
+
+// Stop is a general stop
+func (ss *StdoutSink) Stop() error {
+	return nil
+}
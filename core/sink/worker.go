@@ -0,0 +1,162 @@
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/harbinzhang/goRainbow/core/module"
+	"github.com/harbinzhang/goRainbow/core/protocol"
+)
+
+// DefaultBufferSize is the buffer size of a sink if "bufferSize" is not set.
+const DefaultBufferSize int = 9000
+
+// dropReportInterval is how often dropped metrics are counted to CountService.
+const dropReportInterval = time.Second
+
+// Worker owns one sink and its own buffer.
+// Metrics are offered without blocking, so a stuck sink only drops its own metrics
+// and does not block the others.
+type Worker struct {
+	Name         string
+	Sink         Sink
+	CountService *module.CountService
+	Logger       *zap.Logger
+
+	queue       chan protocol.Metric
+	doneChannel chan struct{}
+	quitChannel chan struct{}
+	reportDone  chan struct{}
+	env         string
+	// dropped is counted by Offer, which runs in the producer loop, and reported by reportDrops.
+	dropped int64
+}
+
+// NewWorkers creates and inits all sinks from config.
+func NewWorkers(confs []protocol.SinkConfig, countService *module.CountService, logger *zap.Logger) ([]*Worker, error) {
+	workers := make([]*Worker, 0, len(confs))
+	names := make(map[string]bool)
+	for _, conf := range confs {
+		name := conf.Name
+		if name == "" {
+			name = conf.Type
+		}
+		if names[name] {
+			return nil, errors.New("sink name is duplicated: " + name)
+		}
+		names[name] = true
+
+		s, err := New(conf.Type)
+		if err != nil {
+			return nil, err
+		}
+		sinkLogger := logger.With(zap.String("sink", name))
+		if err := s.Init(conf.Options, sinkLogger); err != nil {
+			return nil, fmt.Errorf("init sink %s: %v", name, err)
+		}
+
+		worker := &Worker{
+			Name:         name,
+			Sink:         s,
+			CountService: countService,
+			Logger:       sinkLogger,
+		}
+		worker.Init(conf.BufferSize)
+		workers = append(workers, worker)
+	}
+	return workers, nil
+}
+
+// Init is a general init
+func (w *Worker) Init(bufferSize int) {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+	w.queue = make(chan protocol.Metric, bufferSize)
+	w.doneChannel = make(chan struct{})
+	w.quitChannel = make(chan struct{})
+	w.reportDone = make(chan struct{})
+	w.env = os.Getenv("ENV")
+}
+
+// Start is a general start, it sends metrics from its buffer until Stop.
+func (w *Worker) Start() {
+	defer w.Logger.Sync()
+	defer close(w.doneChannel)
+
+	go w.reportDrops()
+	for metric := range w.queue {
+		w.send(metric)
+	}
+}
+
+// Offer puts metric into buffer, returns false if the buffer is full.
+func (w *Worker) Offer(metric protocol.Metric) bool {
+	select {
+	case w.queue <- metric:
+		return true
+	default:
+		// CountService is not used here, as its counters send to the queue the producer reads.
+		atomic.AddInt64(&w.dropped, 1)
+		return false
+	}
+}
+
+// Stop sends the metrics left in buffer and stops the sink.
+func (w *Worker) Stop() error {
+	close(w.queue)
+	<-w.doneChannel
+	close(w.quitChannel)
+	<-w.reportDone
+	return w.Sink.Stop()
+}
+
+// reportDrops counts dropped metrics as exception.sinkDropped.{name} periodically.
+func (w *Worker) reportDrops() {
+	defer close(w.reportDone)
+
+	ticker := time.NewTicker(dropReportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.countDrops()
+		case <-w.quitChannel:
+			w.countDrops()
+			return
+		}
+	}
+}
+
+func (w *Worker) countDrops() {
+	if dropped := atomic.SwapInt64(&w.dropped, 0); dropped > 0 {
+		w.CountService.Add("exception.sinkDropped."+w.Name, w.env, int(dropped))
+	}
+}
+
+// send isolates a sink's failure, including panic, from the others.
+func (w *Worker) send(metric protocol.Metric) {
+	defer func() {
+		if e := recover(); e != nil {
+			w.CountService.Increase("exception.sinkPanic."+w.Name, w.env)
+			w.Logger.Error("sink panic",
+				zap.Any("panic", e),
+				zap.Int64("timestamp", time.Now().Unix()),
+			)
+		}
+	}()
+
+	if err := w.Sink.Send(metric); err != nil {
+		w.CountService.Increase("exception.sinkFailed."+w.Name, w.env)
+		w.Logger.Warn("sink send failed",
+			zap.String("error", err.Error()),
+			zap.String("metric", metric.Name),
+			zap.Int64("timestamp", time.Now().Unix()),
+		)
+	}
+}
@@ -0,0 +1,258 @@
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/harbinzhang/goRainbow/core/protocol"
+)
+
+// DefaultPrometheusPath is where a prometheus sink is served without "path" option.
+const DefaultPrometheusPath = "/metrics"
+
+// ConfigOverride is a config field which can be overridden by env variable Env
+// or command-line flag Flag. Flags are put into env variables by main,
+// so the order is: flag > env variable > config.json > default.
+type ConfigOverride struct {
+	Env   string
+	Flag  string
+	Usage string
+	apply func(conf *protocol.Config, value string) error
+}
+
+// ConfigOverrides are all config fields which can be overridden.
+var ConfigOverrides = []ConfigOverride{
+	{"RAINBOW_BURROW_URL", "burrow-url", "Burrow v3 kafka endpoint", func(conf *protocol.Config, value string) error {
+		conf.Burrow.URL = value
+		return nil
+	}},
+	{"RAINBOW_BURROW_TIMEOUT_SECONDS", "burrow-timeout", "timeout of each Burrow request", func(conf *protocol.Config, value string) error {
+		return setInt(&conf.Burrow.TimeoutSeconds, value)
+	}},
+	{"RAINBOW_BURROW_RETRIES", "burrow-retries", "retries of a failed Burrow request", func(conf *protocol.Config, value string) error {
+		return setInt(&conf.Burrow.Retries, value)
+	}},
+	{"RAINBOW_PORT", "port", "port of health_check server", func(conf *protocol.Config, value string) error {
+		return setInt(&conf.Server.Port, value)
+	}},
+	{"RAINBOW_PRODUCE_QUEUE_SIZE", "produce-queue-size", "size of produce queue", func(conf *protocol.Config, value string) error {
+		return setInt(&conf.ProduceQueueSize, value)
+	}},
+	{"RAINBOW_SHUTDOWN_TIMEOUT_SECONDS", "shutdown-timeout", "seconds to drain metrics on SIGTERM/SIGINT", func(conf *protocol.Config, value string) error {
+		return setInt(&conf.ShutdownTimeoutSeconds, value)
+	}},
+	{"RAINBOW_REPORT_INTERVAL_SECONDS", "report-interval", "seconds between counter reports", func(conf *protocol.Config, value string) error {
+		return setInt(&conf.ReportIntervalSeconds, value)
+	}},
+	{"RAINBOW_CONSUMER_POLL_SECONDS", "consumer-poll", "seconds between consumer /lag polls", func(conf *protocol.Config, value string) error {
+		return setInt(&conf.Intervals.ConsumerPollSeconds, value)
+	}},
+	{"RAINBOW_TOPIC_POLL_SECONDS", "topic-poll", "seconds between topic offset polls", func(conf *protocol.Config, value string) error {
+		return setInt(&conf.Intervals.TopicPollSeconds, value)
+	}},
+	{"RAINBOW_DISCOVERY_SECONDS", "discovery", "seconds between consumer/topic discovery", func(conf *protocol.Config, value string) error {
+		return setInt(&conf.Intervals.DiscoverySeconds, value)
+	}},
+	{"RAINBOW_BURROW_RETRY_SECONDS", "burrow-retry", "seconds to wait when Burrow is not ready", func(conf *protocol.Config, value string) error {
+		return setInt(&conf.Intervals.BurrowRetrySeconds, value)
+	}},
+	{"RAINBOW_EMISSION", "emission", "emission policy of lag metrics: always, onChange or zeroBracketed", func(conf *protocol.Config, value string) error {
+		conf.Translator.Emission = value
+		return nil
+	}},
+	{"RAINBOW_STALL_WINDOW_SECONDS", "stall-window", "seconds a partition with lag can keep its committed offset before it's stalled", func(conf *protocol.Config, value string) error {
+		return setInt(&conf.Translator.StallWindowSeconds, value)
+	}},
+	{"RAINBOW_INPUT", "input", "how consumer lags are got from Burrow: pull, push or both", func(conf *protocol.Config, value string) error {
+		conf.Input.Mode = value
+		return nil
+	}},
+	{"RAINBOW_HISTORY_HOURS", "history-hours", "hours of lag history served by REST API", func(conf *protocol.Config, value string) error {
+		return setInt(&conf.History.RetentionHours, value)
+	}},
+	{"RAINBOW_KAFKA_BROKERS", "kafka-brokers", "Kafka bootstrap servers of kafka sink", func(conf *protocol.Config, value string) error {
+		conf.Kafka.BrokerServers = value
+		return nil
+	}},
+	{"RAINBOW_KAFKA_TOPIC", "kafka-topic", "Kafka topic of kafka sink", func(conf *protocol.Config, value string) error {
+		conf.Kafka.Topic = value
+		return nil
+	}},
+}
+
+func setInt(target *int, value string) error {
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	*target = i
+	return nil
+}
+
+// setConfigDefaults fills defaults, config.json is decoded over them,
+// so a field in config.json is kept even if it is 0, e.g. burrow.retries: 0 disables retries.
+func setConfigDefaults(conf *protocol.Config) {
+	conf.ReportIntervalSeconds = 60
+	conf.ProduceQueueSize = 9000
+	conf.ShutdownTimeoutSeconds = 30
+	conf.Burrow.URL = "http://127.0.0.1:8000/v3/kafka"
+	conf.Burrow.TimeoutSeconds = 10
+	conf.Burrow.Retries = 3
+	conf.Burrow.BackoffMs = 500
+	conf.Burrow.MaxBackoffMs = 10000
+	conf.Server.Port = 7099
+	conf.Intervals.ConsumerPollSeconds = 30
+	conf.Intervals.TopicPollSeconds = 60
+	conf.Intervals.DiscoverySeconds = 300
+	conf.Intervals.BurrowRetrySeconds = 60
+	conf.Input.Mode = "pull"
+	conf.Input.PushPath = "/burrow"
+	conf.Input.PushIdleSeconds = 600
+	conf.History.RetentionHours = 6
+	conf.History.MaxPoints = 720
+	conf.History.MaxSeries = 10000
+	conf.Alerts.RepeatIntervalSeconds = 3600
+	conf.Alerts.GroupIntervalSeconds = 10
+	conf.Translator.StallWindowSeconds = 300
+	conf.Translator.Emission = "always"
+}
+
+// applyConfigOverrides overrides config by env variables.
+func applyConfigOverrides(conf *protocol.Config) error {
+	for _, override := range ConfigOverrides {
+		value, ok := os.LookupEnv(override.Env)
+		if !ok || value == "" {
+			continue
+		}
+		if err := override.apply(conf, value); err != nil {
+			return fmt.Errorf("invalid %s=%q: %v", override.Env, value, err)
+		}
+	}
+	return nil
+}
+
+// ValidateConfig checks config, goRainbow should not start with an invalid one.
+func ValidateConfig(conf protocol.Config) error {
+	burrowURL, err := url.Parse(conf.Burrow.URL)
+	if err != nil {
+		return fmt.Errorf("burrow.url %q is invalid: %v", conf.Burrow.URL, err)
+	}
+	if (burrowURL.Scheme != "http" && burrowURL.Scheme != "https") || burrowURL.Host == "" {
+		return fmt.Errorf("burrow.url %q should be like http://host:port/v3/kafka", conf.Burrow.URL)
+	}
+	if conf.Server.Port <= 0 || conf.Server.Port > 65535 {
+		return fmt.Errorf("server.port %d is not a valid port", conf.Server.Port)
+	}
+	if conf.Burrow.Retries < 0 {
+		return errors.New("burrow.retries should not be negative")
+	}
+	if conf.ProduceQueueSize <= 0 {
+		return errors.New("produceQueueSize should be positive")
+	}
+
+	// positives is a slice, not a map, so the first invalid field is always the one reported.
+	positives := []struct {
+		name  string
+		value int
+	}{
+		{"reportIntervalSeconds", conf.ReportIntervalSeconds},
+		{"burrow.timeoutSeconds", conf.Burrow.TimeoutSeconds},
+		{"burrow.backoffMs", conf.Burrow.BackoffMs},
+		{"burrow.maxBackoffMs", conf.Burrow.MaxBackoffMs},
+		{"shutdownTimeoutSeconds", conf.ShutdownTimeoutSeconds},
+		{"intervals.consumerPollSeconds", conf.Intervals.ConsumerPollSeconds},
+		{"intervals.topicPollSeconds", conf.Intervals.TopicPollSeconds},
+		{"intervals.discoverySeconds", conf.Intervals.DiscoverySeconds},
+		{"intervals.burrowRetrySeconds", conf.Intervals.BurrowRetrySeconds},
+		{"translator.stallWindowSeconds", conf.Translator.StallWindowSeconds},
+		{"input.pushIdleSeconds", conf.Input.PushIdleSeconds},
+		{"history.retentionHours", conf.History.RetentionHours},
+		{"history.maxPoints", conf.History.MaxPoints},
+		{"history.maxSeries", conf.History.MaxSeries},
+		{"alerts.repeatIntervalSeconds", conf.Alerts.RepeatIntervalSeconds},
+		{"alerts.groupIntervalSeconds", conf.Alerts.GroupIntervalSeconds},
+	}
+	for _, positive := range positives {
+		if positive.value <= 0 {
+			return fmt.Errorf("%s should be positive, got %d", positive.name, positive.value)
+		}
+	}
+
+	switch conf.Translator.Emission {
+	case "always", "onChange", "zeroBracketed":
+	default:
+		return fmt.Errorf("translator.emission %q should be always, onChange or zeroBracketed", conf.Translator.Emission)
+	}
+	switch conf.Input.Mode {
+	case "pull", "push", "both":
+	default:
+		return fmt.Errorf("input.mode %q should be pull, push or both", conf.Input.Mode)
+	}
+	if !strings.HasPrefix(conf.Input.PushPath, "/") || conf.Input.PushPath == "/health_check" ||
+		strings.HasPrefix(conf.Input.PushPath, "/api/") {
+		return fmt.Errorf("input.pushPath %q should be a path not used by health_check server", conf.Input.PushPath)
+	}
+	if _, err := regexp.Compile(conf.Consumer.Blacklist); err != nil {
+		return fmt.Errorf("consumer.blacklist is not a valid regexp: %v", err)
+	}
+	for _, webhook := range conf.Alerts.Webhooks {
+		webhookURL, err := url.Parse(webhook.URL)
+		if err != nil || (webhookURL.Scheme != "http" && webhookURL.Scheme != "https") || webhookURL.Host == "" {
+			return fmt.Errorf("alerts.webhooks: url %q should be like http://host:port/path", webhook.URL)
+		}
+	}
+	for _, rule := range conf.Alerts.Rules {
+		for name, expr := range map[string]string{"cluster": rule.Cluster, "group": rule.Group, "topic": rule.Topic} {
+			if _, err := regexp.Compile(expr); err != nil {
+				return fmt.Errorf("alerts.rules %q: %s is not a valid regexp: %v", rule.Name, name, err)
+			}
+		}
+	}
+	// paths served on health_check server, http.HandleFunc panics on a path registered twice.
+	paths := map[string]string{"/health_check": "health_check"}
+	if conf.Input.Mode != "pull" {
+		paths[conf.Input.PushPath] = "input.pushPath"
+	}
+	for _, sink := range conf.Sinks {
+		if sink.Type == "" {
+			return errors.New("sinks: type is required")
+		}
+		path, ok, err := sinkPath(sink)
+		if err != nil {
+			return fmt.Errorf("sinks: %s options are invalid: %v", sink.Type, err)
+		}
+		if !ok {
+			continue
+		}
+		if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "/api/") {
+			return fmt.Errorf("sinks: %s path %q should be a path not used by health_check server", sink.Type, path)
+		}
+		if used, ok := paths[path]; ok {
+			return fmt.Errorf("sinks: %s path %q is already used by %s", sink.Type, path, used)
+		}
+		paths[path] = "sink " + sink.Type
+	}
+	return nil
+}
+
+// sinkPath returns the path a sink is served on health_check server, ok is false if it's not served.
+func sinkPath(sink protocol.SinkConfig) (path string, ok bool, err error) {
+	if sink.Type != "prometheus" {
+		return "", false, nil
+	}
+	opts := struct {
+		Path string `json:"path"`
+	}{Path: DefaultPrometheusPath}
+	if len(sink.Options) > 0 {
+		if err := json.Unmarshal(sink.Options, &opts); err != nil {
+			return "", false, err
+		}
+	}
+	return opts.Path, true, nil
+}
@@ -0,0 +1,123 @@
+package util
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/harbinzhang/goRainbow/core/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetConfDefaultsAndOverrides(t *testing.T) {
+	os.Setenv("configPath", "../../config/config.json")
+	defer os.Unsetenv("configPath")
+
+	contextProvider := ContextProvider{}
+	contextProvider.Init()
+
+	conf := contextProvider.GetConf()
+	assert.Equal(t, "http://127.0.0.1:8000/v3/kafka", conf.Burrow.URL, "burrow url not correct")
+	assert.Equal(t, 7099, conf.Server.Port, "port not correct")
+	assert.Equal(t, 30, conf.Intervals.ConsumerPollSeconds, "consumer poll interval not correct")
+	assert.Nil(t, ValidateConfig(conf), "config.json should be valid")
+
+	os.Setenv("RAINBOW_BURROW_URL", "http://burrow:8000/v3/kafka")
+	os.Setenv("RAINBOW_CONSUMER_POLL_SECONDS", "10")
+	defer os.Unsetenv("RAINBOW_BURROW_URL")
+	defer os.Unsetenv("RAINBOW_CONSUMER_POLL_SECONDS")
+
+	conf = contextProvider.GetConf()
+	assert.Equal(t, "http://burrow:8000/v3/kafka", conf.Burrow.URL, "env should override burrow url")
+	assert.Equal(t, 10, conf.Intervals.ConsumerPollSeconds, "env should override consumer poll interval")
+}
+
+func TestGetConfZeroValue(t *testing.T) {
+	configFile, err := ioutil.TempFile("", "config*.json")
+	assert.Nil(t, err)
+	defer os.Remove(configFile.Name())
+	configFile.WriteString(`{"burrow": {"retries": 0, "timeoutSeconds": 5}}`)
+	configFile.Close()
+	os.Setenv("configPath", configFile.Name())
+	defer os.Unsetenv("configPath")
+
+	contextProvider := ContextProvider{}
+	contextProvider.Init()
+	conf := contextProvider.GetConf()
+	assert.Equal(t, 0, conf.Burrow.Retries, "retries: 0 should not be replaced by default")
+	assert.Equal(t, 5, conf.Burrow.TimeoutSeconds)
+	assert.Equal(t, 500, conf.Burrow.BackoffMs, "fields not in config.json should be defaults")
+	assert.Equal(t, "http://127.0.0.1:8000/v3/kafka", conf.Burrow.URL)
+	assert.Nil(t, ValidateConfig(conf))
+}
+
+func TestGetConfInvalidOverride(t *testing.T) {
+	os.Setenv("configPath", "../../config/config.json")
+	os.Setenv("RAINBOW_PORT", "abc")
+	defer os.Unsetenv("configPath")
+	defer os.Unsetenv("RAINBOW_PORT")
+
+	contextProvider := ContextProvider{}
+	contextProvider.Init()
+	assert.Panics(t, func() { contextProvider.GetConf() }, "invalid env should fail fast")
+}
+
+func TestValidateConfig(t *testing.T) {
+	os.Setenv("configPath", "../../config/config.json")
+	defer os.Unsetenv("configPath")
+
+	contextProvider := ContextProvider{}
+	contextProvider.Init()
+
+	conf := contextProvider.GetConf()
+	conf.Burrow.URL = "127.0.0.1:8000"
+	assert.NotNil(t, ValidateConfig(conf), "burrow url without scheme should be invalid")
+
+	conf = contextProvider.GetConf()
+	conf.Server.Port = 70000
+	assert.NotNil(t, ValidateConfig(conf), "port out of range should be invalid")
+
+	conf = contextProvider.GetConf()
+	conf.Intervals.DiscoverySeconds = -1
+	assert.NotNil(t, ValidateConfig(conf), "negative interval should be invalid")
+	conf.Alerts.GroupIntervalSeconds = 0
+	for i := 0; i < 10; i++ {
+		assert.EqualError(t, ValidateConfig(conf), "intervals.discoverySeconds should be positive, got -1",
+			"first invalid field should always be reported")
+	}
+
+	conf = contextProvider.GetConf()
+	conf.Consumer.Blacklist = "("
+	assert.NotNil(t, ValidateConfig(conf), "invalid blacklist should be invalid")
+
+	conf = contextProvider.GetConf()
+	conf.Translator.Emission = "sometimes"
+	assert.NotNil(t, ValidateConfig(conf), "unknown emission policy should be invalid")
+
+	conf = contextProvider.GetConf()
+	conf.Input.Mode = "poll"
+	assert.NotNil(t, ValidateConfig(conf), "unknown input mode should be invalid")
+
+	conf = contextProvider.GetConf()
+	conf.Alerts.Rules = []protocol.AlertRuleConfig{{Name: "lag", Group: "("}}
+	assert.NotNil(t, ValidateConfig(conf), "invalid alert rule regexp should be invalid")
+
+	prometheus := func(path string) protocol.SinkConfig {
+		return protocol.SinkConfig{Type: "prometheus", Options: json.RawMessage(`{"path": "` + path + `"}`)}
+	}
+	conf = contextProvider.GetConf()
+	conf.Sinks = []protocol.SinkConfig{{Type: "prometheus"}, prometheus("/metrics2")}
+	assert.Nil(t, ValidateConfig(conf), "prometheus sinks on different paths should be valid")
+	conf.Sinks = []protocol.SinkConfig{{Type: "prometheus"}, prometheus("/metrics")}
+	assert.NotNil(t, ValidateConfig(conf), "duplicated sink path should be invalid")
+	conf.Sinks = []protocol.SinkConfig{prometheus("/health_check")}
+	assert.NotNil(t, ValidateConfig(conf), "sink path of health_check should be invalid")
+	conf.Sinks = []protocol.SinkConfig{prometheus("/api/v1/metrics")}
+	assert.NotNil(t, ValidateConfig(conf), "sink path under /api/ should be invalid")
+	conf.Input.Mode = "push"
+	conf.Sinks = []protocol.SinkConfig{prometheus(conf.Input.PushPath)}
+	assert.NotNil(t, ValidateConfig(conf), "sink path of push input should be invalid")
+	conf.Input.Mode = "pull"
+	assert.Nil(t, ValidateConfig(conf), "push path is not served in pull mode")
+}
@@ -18,16 +18,24 @@ func (cp *ContextProvider) Init() {
 	cp.filename = os.Getenv("configPath")
 }
 
-// GetConf is for
+// GetConf loads config.json, fills defaults and applies overrides from env variables.
 func (cp *ContextProvider) GetConf() protocol.Config {
 	// Prepare config file
 	var conf protocol.Config
-	configFile, _ := os.Open(cp.filename)
+	setConfigDefaults(&conf)
+	configFile, err := os.Open(cp.filename)
+	if err != nil {
+		panic("Err open config: " + err.Error())
+	}
 	defer configFile.Close()
 	decoder := json.NewDecoder(configFile)
 	if err := decoder.Decode(&conf); err != nil {
 		panic("Err decode config: " + err.Error())
 	}
+
+	if err := applyConfigOverrides(&conf); err != nil {
+		panic("Err override config: " + err.Error())
+	}
 	return conf
 }
 
@@ -69,3 +77,24 @@ func (cp *ContextProvider) GetBlacklist() string {
 	conf := cp.GetConf()
 	return conf.Consumer.Blacklist
 }
+
+// GetTags returns tags in postfix as a map.
+func (cp *ContextProvider) GetTags() map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range strings.Fields(cp.GetPostfix()) {
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) == 2 {
+			tags[kv[0]] = kv[1]
+		}
+	}
+	return tags
+}
+
+// GetSinks returns where metrics are sent to, "kafka" by default.
+func (cp *ContextProvider) GetSinks() []protocol.SinkConfig {
+	conf := cp.GetConf()
+	if len(conf.Sinks) == 0 {
+		return []protocol.SinkConfig{{Type: "kafka"}}
+	}
+	return conf.Sinks
+}
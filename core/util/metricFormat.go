@@ -0,0 +1,37 @@
+package util
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/harbinzhang/goRainbow/core/protocol"
+)
+
+// FormatWavefront serializes a metric into Wavefront format:
+// "name value timestamp postfix k=v k=v", tags are sorted by key.
+func FormatWavefront(metric protocol.Metric, postfix string) string {
+	parts := []string{metric.Name, FormatValue(metric.Value), strconv.FormatInt(metric.Timestamp, 10)}
+	if postfix != "" {
+		parts = append(parts, postfix)
+	}
+	for _, k := range SortedTagKeys(metric.Tags) {
+		parts = append(parts, k+"="+metric.Tags[k])
+	}
+	return strings.Join(parts, " ")
+}
+
+// FormatValue prints value without trailing zeros, i.e. 10 instead of 10.000000
+func FormatValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// SortedTagKeys returns keys of tags in order.
+func SortedTagKeys(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
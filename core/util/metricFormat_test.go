@@ -0,0 +1,36 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/harbinzhang/goRainbow/core/protocol"
+)
+
+func TestFormatWavefront(t *testing.T) {
+	metric := protocol.Metric{
+		Name:      "fjord.burrow.test.group1.totalLag",
+		Value:     10,
+		Timestamp: 1547157609,
+		Tags:      map[string]string{"env": "test", "consumer": "group1"},
+	}
+	assert.Equal(t, "fjord.burrow.test.group1.totalLag 10 1547157609 source=fjord-burrow consumer=group1 env=test",
+		FormatWavefront(metric, "source=fjord-burrow"), "wavefront format not correct")
+
+	metric.Value = 0.5
+	metric.Tags = nil
+	assert.Equal(t, "fjord.burrow.test.group1.totalLag 0.5 1547157609",
+		FormatWavefront(metric, ""), "wavefront format not correct")
+}
+
+func TestMetricValidate(t *testing.T) {
+	metric := protocol.Metric{Name: "fjord.burrow.test.totalLag", Tags: map[string]string{"env": "test"}}
+	assert.Nil(t, metric.Validate(), "metric should be valid")
+
+	metric.Tags["consumer"] = "group 1"
+	assert.NotNil(t, metric.Validate(), "whitespace in tag should be invalid")
+
+	metric = protocol.Metric{Name: ""}
+	assert.NotNil(t, metric.Validate(), "empty name should be invalid")
+}
@@ -0,0 +1,79 @@
+package util
+
+import (
+	"strconv"
+
+	"github.com/harbinzhang/goRainbow/core/protocol"
+)
+
+// OwnershipTracker tracks partition owners of a consumer group across polls, to find rebalances.
+// A partition moved from owner A to unassigned and then to B is a move from A to B.
+// It's not thread safe, it should be updated in order of polls.
+type OwnershipTracker struct {
+	// owners is the last owner of topic:partition, unassigned partitions keep their last owner.
+	owners map[string]string
+	// counts is partitions per owner of the last update.
+	counts map[string]int
+}
+
+// OwnerMove is a partition whose owner changes.
+type OwnerMove struct {
+	Topic     string
+	Partition int
+	Previous  string
+	Current   string
+}
+
+// Ownership is the result of an update.
+type Ownership struct {
+	Moves []OwnerMove
+	// Owners is partitions per owner, owners gone since the last update have 0.
+	Owners     map[string]int
+	Unassigned int
+}
+
+// Init is a general init
+func (ot *OwnershipTracker) Init() {
+	ot.owners = make(map[string]string)
+	ot.counts = make(map[string]int)
+}
+
+// Update compares owners of partitions with the last ones, the first update has no moves.
+func (ot *OwnershipTracker) Update(partitions []protocol.Partition) Ownership {
+	res := Ownership{Owners: make(map[string]int)}
+	for owner := range ot.counts {
+		res.Owners[owner] = 0
+	}
+	counts := make(map[string]int)
+	for _, partition := range partitions {
+		if partition.Owner == "" {
+			res.Unassigned++
+			continue
+		}
+		counts[partition.Owner]++
+		res.Owners[partition.Owner]++
+
+		key := partition.Topic + ":" + strconv.Itoa(partition.Partition)
+		if previous, ok := ot.owners[key]; ok && previous != partition.Owner {
+			res.Moves = append(res.Moves, OwnerMove{
+				Topic:     partition.Topic,
+				Partition: partition.Partition,
+				Previous:  previous,
+				Current:   partition.Owner,
+			})
+		}
+		ot.owners[key] = partition.Owner
+	}
+	ot.counts = counts
+	return res
+}
+
+// Finish returns 0 partitions for all owners and no unassigned partition, as the consumer group is gone.
+func (ot *OwnershipTracker) Finish() Ownership {
+	res := Ownership{Owners: make(map[string]int)}
+	for owner := range ot.counts {
+		res.Owners[owner] = 0
+	}
+	ot.Init()
+	return res
+}
@@ -0,0 +1,39 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/harbinzhang/goRainbow/core/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOwnershipTracker(t *testing.T) {
+	ot := &OwnershipTracker{}
+	ot.Init()
+
+	partitions := func(owners ...string) []protocol.Partition {
+		var res []protocol.Partition
+		for i, owner := range owners {
+			res = append(res, protocol.Partition{Topic: "topic", Partition: i, Owner: owner})
+		}
+		return res
+	}
+
+	ownership := ot.Update(partitions("a", "a", "b"))
+	assert.Equal(t, 0, len(ownership.Moves), "first update has no moves")
+	assert.Equal(t, map[string]int{"a": 2, "b": 1}, ownership.Owners)
+
+	ownership = ot.Update(partitions("a", "", "b"))
+	assert.Equal(t, 0, len(ownership.Moves))
+	assert.Equal(t, 1, ownership.Unassigned)
+
+	ownership = ot.Update(partitions("c", "c", "b"))
+	assert.Equal(t, []OwnerMove{
+		{Topic: "topic", Partition: 0, Previous: "a", Current: "c"},
+		{Topic: "topic", Partition: 1, Previous: "a", Current: "c"},
+	}, ownership.Moves, "unassigned partition moves from its last owner")
+	assert.Equal(t, map[string]int{"a": 0, "b": 1, "c": 2}, ownership.Owners, "gone owner has 0")
+
+	ownership = ot.Finish()
+	assert.Equal(t, map[string]int{"b": 0, "c": 0}, ownership.Owners)
+}
@@ -0,0 +1,167 @@
+package util
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ResetKind tells how the offset sequence of a key was broken.
+type ResetKind string
+
+const (
+	// ResetRewind means offset went backwards, e.g. consumer group offset reset to earliest.
+	ResetRewind ResetKind = "rewind"
+	// ResetRestart means offset restarted from 0, e.g. topic recreated.
+	ResetRestart ResetKind = "restart"
+)
+
+// RateCalculator keeps a sliding window of (timestamp, offset) samples per key,
+// and computes offset change rate over the window for any sample interval.
+// A reset clears the window of its key, so a rate never spans a reset.
+// Usage:
+// rc.Init()
+// rc.Update(key, offset, timestamp)
+// for _, rate := range rc.Rates() {...}
+type RateCalculator struct {
+	sync.Mutex
+
+	// Window is how long samples are kept for a rate, 2 minutes by default.
+	Window time.Duration
+
+	windows map[string]*rateWindow
+}
+
+type rateSample struct {
+	timestamp int64
+	offset    int
+}
+
+type rateWindow struct {
+	samples []rateSample
+	// reset is the latest reset not reported by Rates yet.
+	reset ResetKind
+}
+
+// Rate is the offset change rate of a key.
+type Rate struct {
+	Key string
+	// Valid is false if there is only one sample in window, e.g. right after a reset.
+	Valid     bool
+	PerSecond float64
+	PerMinute float64
+	// Timestamp is of the latest sample.
+	Timestamp int64
+	// Reset is the reset detected since last Rates, empty if none.
+	Reset ResetKind
+}
+
+// Init is a general init
+func (rc *RateCalculator) Init() {
+	rc.windows = make(map[string]*rateWindow)
+	if rc.Window == 0 {
+		rc.Window = 2 * time.Minute
+	}
+}
+
+// Update adds a sample of key, timestamp is in seconds.
+// An out-of-order sample is ignored. It returns the reset kind if offset went backwards.
+func (rc *RateCalculator) Update(key string, offset int, timestamp int64) ResetKind {
+	rc.Lock()
+	defer rc.Unlock()
+
+	window, ok := rc.windows[key]
+	if !ok {
+		window = &rateWindow{}
+		rc.windows[key] = window
+	}
+
+	var reset ResetKind
+	if n := len(window.samples); n > 0 {
+		last := window.samples[n-1]
+		if timestamp <= last.timestamp {
+			return ""
+		}
+		if offset < last.offset {
+			reset = ResetRewind
+			if offset == 0 {
+				reset = ResetRestart
+			}
+			window.samples = window.samples[:0]
+			window.reset = reset
+		}
+	}
+
+	window.samples = append(window.samples, rateSample{timestamp: timestamp, offset: offset})
+
+	// drop samples out of window, the latest one is always kept.
+	deadline := timestamp - int64(rc.Window/time.Second)
+	i := 0
+	for i < len(window.samples)-1 && window.samples[i].timestamp < deadline {
+		i++
+	}
+	if i > 0 {
+		window.samples = append(window.samples[:0], window.samples[i:]...)
+	}
+	return reset
+}
+
+// Rates returns rates of all keys sorted by key, and clears their pending resets.
+func (rc *RateCalculator) Rates() []Rate {
+	return rc.rates(true)
+}
+
+// Current returns rates of all keys sorted by key, pending resets are kept.
+func (rc *RateCalculator) Current() []Rate {
+	return rc.rates(false)
+}
+
+func (rc *RateCalculator) rates(clearReset bool) []Rate {
+	rc.Lock()
+	defer rc.Unlock()
+
+	res := make([]Rate, 0, len(rc.windows))
+	for key, window := range rc.windows {
+		n := len(window.samples)
+		if n == 0 {
+			continue
+		}
+		first, last := window.samples[0], window.samples[n-1]
+		rate := Rate{
+			Key:       key,
+			Timestamp: last.timestamp,
+			Reset:     window.reset,
+		}
+		if n > 1 {
+			rate.Valid = true
+			rate.PerSecond = float64(last.offset-first.offset) / float64(last.timestamp-first.timestamp)
+			rate.PerMinute = rate.PerSecond * 60
+		}
+		if clearReset {
+			window.reset = ""
+		}
+		res = append(res, rate)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Key < res[j].Key })
+	return res
+}
+
+// Prune removes keys not updated since before(seconds), e.g. a partition moved to another owner.
+func (rc *RateCalculator) Prune(before int64) {
+	rc.Lock()
+	defer rc.Unlock()
+
+	for key, window := range rc.windows {
+		n := len(window.samples)
+		if n == 0 || window.samples[n-1].timestamp < before {
+			delete(rc.windows, key)
+		}
+	}
+}
+
+// Len returns how many keys it has.
+func (rc *RateCalculator) Len() int {
+	rc.Lock()
+	defer rc.Unlock()
+	return len(rc.windows)
+}
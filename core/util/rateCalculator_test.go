@@ -0,0 +1,52 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateCalculator(t *testing.T) {
+	rc := &RateCalculator{Window: 60 * time.Second}
+	rc.Init()
+
+	assert.Equal(t, ResetKind(""), rc.Update("a", 0, 100))
+	rc.Update("a", 70, 107)
+	rates := rc.Rates()
+	assert.Equal(t, 1, len(rates))
+	assert.True(t, rates[0].Valid)
+	assert.Equal(t, float64(10), rates[0].PerSecond, "jitter interval 7s")
+	assert.Equal(t, float64(600), rates[0].PerMinute)
+
+	// out-of-order sample is ignored
+	rc.Update("a", 0, 90)
+	rc.Update("a", 670, 167)
+	rates = rc.Rates()
+	assert.Equal(t, float64(10), rates[0].PerSecond, "window should drop sample at 100")
+	assert.Equal(t, int64(167), rates[0].Timestamp)
+}
+
+func TestRateCalculatorReset(t *testing.T) {
+	rc := &RateCalculator{}
+	rc.Init()
+
+	rc.Update("rewind", 100, 100)
+	rc.Update("restart", 100, 100)
+	assert.Equal(t, ResetRewind, rc.Update("rewind", 40, 130))
+	assert.Equal(t, ResetRestart, rc.Update("restart", 0, 130))
+
+	rates := rc.Rates()
+	assert.Equal(t, "restart", rates[0].Key)
+	assert.Equal(t, ResetRestart, rates[0].Reset)
+	assert.False(t, rates[0].Valid, "rate should not span a reset")
+	assert.Equal(t, ResetRewind, rates[1].Reset)
+
+	rc.Update("rewind", 100, 160)
+	rates = rc.Rates()
+	assert.Equal(t, ResetKind(""), rates[1].Reset, "reset is reported once")
+	assert.Equal(t, float64(2), rates[1].PerSecond)
+
+	rc.Prune(150)
+	assert.Equal(t, 1, rc.Len(), "restart is not updated since 130")
+}
@@ -1,10 +1,10 @@
 package util
 
 import (
-	"strconv"
-	"strings"
 	"sync"
 	"time"
+
+	"github.com/harbinzhang/goRainbow/core/protocol"
 )
 
 // RequestCounter is for counting events in go-rainbow
@@ -12,14 +12,14 @@ type RequestCounter struct {
 	sync.Mutex
 
 	Interval     time.Duration
-	ProducerChan chan<- string
+	ProducerChan chan<- protocol.Metric
 	Name         string
-	Postfix      string
 
 	envCount         map[string]int
 	unavailableCount int
 	ticker           *time.Ticker
 	quitChannel      chan struct{}
+	doneChannel      chan struct{}
 }
 
 // Init is to initial a RequestCounter
@@ -28,8 +28,10 @@ func (rc *RequestCounter) Init() {
 	rc.unavailableCount = 0
 
 	rc.quitChannel = make(chan struct{})
+	rc.doneChannel = make(chan struct{})
 	rc.ticker = time.NewTicker(rc.Interval)
 	go func() {
+		defer close(rc.doneChannel)
 		for {
 			select {
 			case <-rc.ticker.C:
@@ -41,39 +43,48 @@ func (rc *RequestCounter) Init() {
 	}()
 }
 
-// Stop is for stopping itself
+// Stop is for stopping itself, it waits until the metrics being generated are sent.
 func (rc *RequestCounter) Stop() error {
 	rc.ticker.Stop()
 	close(rc.quitChannel)
+	<-rc.doneChannel
 
 	return nil
 }
 
 // Increase is for increase message count increase per env
 func (rc *RequestCounter) Increase(env string) {
+	rc.Add(env, 1)
+}
+
+// Add increases message count per env by n
+func (rc *RequestCounter) Add(env string, n int) {
 	rc.Lock()
 	defer rc.Unlock()
-	if value, ok := rc.envCount[env]; ok {
-		rc.envCount[env] = value + 1
-	} else {
-		rc.envCount[env] = 1
-	}
+	rc.envCount[env] += n
 }
 
-// translate all count to metrics and push it to chan
+// translate all count to metrics and push it to chan.
+// Metrics are sent after unlocking, as the producer reading the chan may be increasing a counter too.
+// An empty env, e.g. ENV is not set, is neither in name nor tags, as empty tags are invalid.
 func (rc *RequestCounter) generateMetric() {
 	rc.Lock()
-	defer rc.Unlock()
-	timestamp := getCurrentEpochTime()
+	timestamp := time.Now().Unix()
 	isAllUnavailable := true
+	metrics := make([]protocol.Metric, 0, len(rc.envCount))
 	for env, count := range rc.envCount {
 		if count != 0 {
 			isAllUnavailable = false
 		}
-		prefix := "fjord.burrow." + env + "." + rc.Name
-		envTag := "env=" + env
-		message := strings.Join([]string{prefix, strconv.Itoa(count), timestamp, rc.Postfix, envTag}, " ")
-		rc.ProducerChan <- message
+		nameParts := []string{"fjord.burrow", env, rc.Name}
+		tags := map[string]string{"env": env}
+		if env == "" {
+			nameParts = []string{"fjord.burrow", rc.Name}
+			tags = map[string]string{}
+		}
+		metric := protocol.NewMetric(nameParts, float64(count), timestamp, tags)
+		metric.Kind = protocol.KindCounter
+		metrics = append(metrics, metric)
 	}
 	if isAllUnavailable {
 		rc.unavailableCount++
@@ -81,10 +92,11 @@ func (rc *RequestCounter) generateMetric() {
 		rc.unavailableCount = 0
 	}
 	rc.envCount = make(map[string]int)
-}
+	rc.Unlock()
 
-func getCurrentEpochTime() string {
-	return strconv.FormatInt(time.Now().Unix(), 10)
+	for _, metric := range metrics {
+		rc.ProducerChan <- metric
+	}
 }
 
 // IsMetricAvailable is for test if Burrow is sending Lag information to Rainbow
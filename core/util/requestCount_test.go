@@ -1,15 +1,16 @@
 package util
 
 import (
-	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/harbinzhang/goRainbow/core/protocol"
 )
 
 func TestRequestCountServiceSingle(t *testing.T) {
-	producerChan := make(chan string, 10)
+	producerChan := make(chan protocol.Metric, 10)
 	rc := &RequestCounter{
 		Name:         "totalMessage",
 		Interval:     1 * time.Second,
@@ -20,19 +21,20 @@ func TestRequestCountServiceSingle(t *testing.T) {
 
 	time.Sleep(1 * time.Second)
 	res := <-producerChan
-	assert.Equal(t, "1", strings.Split(res, " ")[1], "message not correct")
+	assert.Equal(t, float64(1), res.Value, "message not correct")
+	assert.Equal(t, protocol.KindCounter, res.Kind, "counts should be counters")
 
 	rc.Increase("test")
 	rc.Increase("test")
 
 	time.Sleep(1 * time.Second)
 	res = <-producerChan
-	assert.Equal(t, "2", strings.Split(res, " ")[1], "message not correct")
+	assert.Equal(t, float64(2), res.Value, "message not correct")
 
 }
 
 func TestRequestCountServiceMulti(t *testing.T) {
-	producerChan := make(chan string, 10)
+	producerChan := make(chan protocol.Metric, 10)
 	rc := &RequestCounter{
 		Name:         "totalMessage",
 		Interval:     100 * time.Millisecond,
@@ -45,13 +47,13 @@ func TestRequestCountServiceMulti(t *testing.T) {
 	rc.Increase("staging2")
 	time.Sleep(120 * time.Millisecond)
 	res := <-producerChan
-	assert.Equal(t, "2", strings.Split(res, " ")[1], "message not correct")
+	assert.Equal(t, float64(2), res.Value, "message not correct")
 	res = <-producerChan
-	assert.Equal(t, "2", strings.Split(res, " ")[1], "message not correct")
+	assert.Equal(t, float64(2), res.Value, "message not correct")
 }
 
 func TestMetricsIsAvailable(t *testing.T) {
-	producerChan := make(chan string, 10)
+	producerChan := make(chan protocol.Metric, 10)
 	rc := &RequestCounter{
 		Name:         "totalMessage",
 		Interval:     10 * time.Millisecond,
@@ -68,3 +70,32 @@ func TestMetricsIsAvailable(t *testing.T) {
 	time.Sleep(20 * time.Millisecond)
 	assert.Equal(t, true, rc.IsMetricAvailable(), "IsMetricAvailable should return true")
 }
+
+func TestRequestCountIncreaseWhileChanFull(t *testing.T) {
+	producerChan := make(chan protocol.Metric)
+	rc := &RequestCounter{
+		Name:         "metricsSent",
+		Interval:     10 * time.Millisecond,
+		ProducerChan: producerChan,
+	}
+	rc.Init()
+	rc.Increase("test")
+	// the counter is waiting for the chan now, Increase should not wait for it.
+	time.Sleep(30 * time.Millisecond)
+	increased := make(chan struct{})
+	go func() {
+		rc.Increase("test")
+		close(increased)
+	}()
+	select {
+	case <-increased:
+	case <-time.After(time.Second):
+		t.Fatal("Increase is blocked by a full chan")
+	}
+	<-producerChan
+	go func() {
+		for range producerChan {
+		}
+	}()
+	rc.Stop()
+}
@@ -1,12 +1,21 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
+	"github.com/harbinzhang/goRainbow/core/alert"
+	"github.com/harbinzhang/goRainbow/core/burrow"
 	"github.com/harbinzhang/goRainbow/core/module"
+	"github.com/harbinzhang/goRainbow/core/protocol"
+	"github.com/harbinzhang/goRainbow/core/sink"
 	"github.com/harbinzhang/goRainbow/core/util"
 	"go.uber.org/zap"
 
@@ -16,65 +25,258 @@ import (
 func main() {
 	defer handleExit()
 
-	const link string = "http://127.0.0.1:8000/v3/kafka"
-	const ProduceQueueSize int = 9000
+	parseFlags()
 
-	os.Setenv("configPath", "config/config.json")
+	// Load config, flags and env variables override config.json
+	contextProvider := util.ContextProvider{}
+	contextProvider.Init()
+	conf := contextProvider.GetConf()
+	if err := util.ValidateConfig(conf); err != nil {
+		panic("Invalid config: " + err.Error())
+	}
 
 	// Queue init
-	produceQueue := make(chan string, ProduceQueueSize)
+	produceQueue := make(chan protocol.Metric, conf.ProduceQueueSize)
 
 	// Prepare count service
-	countService := &module.CountService{ProduceQueue: produceQueue}
+	countService := &module.CountService{
+		Interval:     time.Duration(conf.ReportIntervalSeconds) * time.Second,
+		ProduceQueue: produceQueue,
+	}
 	countService.Start()
 
 	//prepare logger
 	logger := util.GetLogger()
-	pipeline.PrepareLogger()
+
+	// Prepare Burrow client, shared by all handlers
+	burrowClient := &burrow.Client{
+		BaseURL:    conf.Burrow.URL,
+		Timeout:    time.Duration(conf.Burrow.TimeoutSeconds) * time.Second,
+		Retries:    conf.Burrow.Retries,
+		Backoff:    time.Duration(conf.Burrow.BackoffMs) * time.Millisecond,
+		MaxBackoff: time.Duration(conf.Burrow.MaxBackoffMs) * time.Millisecond,
+	}
+	burrowClient.Init()
+
+	// Head offsets from topic handlers, for consumer lag in seconds
+	offsetHistory := &module.OffsetHistory{}
+	offsetHistory.Init()
+
+	// Latest lags and offsets, served by REST API
+	stateStore := &module.StateStore{OffsetHistory: offsetHistory}
+	stateStore.Init()
+
+	// Lag trends of the last hours, served by REST API
+	lagHistory := &module.LagHistory{
+		Retention: time.Duration(conf.History.RetentionHours) * time.Hour,
+		MaxPoints: conf.History.MaxPoints,
+		MaxSeries: conf.History.MaxSeries,
+	}
+	lagHistory.Init()
+
+	// Alerting engine, rules are evaluated on every consumer poll
+	alertRules, err := alert.NewRules(conf.Alerts.Rules)
+	if err != nil {
+		panic("Invalid config: " + err.Error())
+	}
+	alertEngine := &alert.Engine{
+		Rules:          alertRules,
+		Webhooks:       alert.NewWebhooks(conf.Alerts.Webhooks),
+		RepeatInterval: time.Duration(conf.Alerts.RepeatIntervalSeconds) * time.Second,
+		GroupInterval:  time.Duration(conf.Alerts.GroupIntervalSeconds) * time.Second,
+		OffsetHistory:  offsetHistory,
+		CountService:   countService,
+		Logger: logger.With(
+			zap.String("module", "alertEngine"),
+		),
+	}
 
 	// Prepare pipeline routines
 	aliveConsumersMaintainer := &pipeline.AliveConsumersMaintainer{
-		BurrowURL:    link,
-		ProduceQueue: produceQueue,
-		CountService: countService,
+		Burrow:            burrowClient,
+		PollInterval:      time.Duration(conf.Intervals.ConsumerPollSeconds) * time.Second,
+		DiscoveryInterval: time.Duration(conf.Intervals.DiscoverySeconds) * time.Second,
+		RetryInterval:     time.Duration(conf.Intervals.BurrowRetrySeconds) * time.Second,
+		ProduceQueue:      produceQueue,
+		CountService:      countService,
+		OffsetHistory:     offsetHistory,
+		Emission:          pipeline.EmissionPolicy(conf.Translator.Emission),
+		StallWindow:       time.Duration(conf.Translator.StallWindowSeconds) * time.Second,
+		State:             stateStore,
+		History:           lagHistory,
+		Alerts:            alertEngine,
 		Logger: logger.With(
 			zap.String("module", "aliveConsumersMaintainer"),
 		),
 	}
 
 	aliveTopicsMaintainer := &pipeline.AliveTopicsMaintainer{
-		BurrowURL:    link,
-		ProduceQueue: produceQueue,
-		CountService: countService,
+		Burrow:            burrowClient,
+		PollInterval:      time.Duration(conf.Intervals.TopicPollSeconds) * time.Second,
+		DiscoveryInterval: time.Duration(conf.Intervals.DiscoverySeconds) * time.Second,
+		RetryInterval:     time.Duration(conf.Intervals.BurrowRetrySeconds) * time.Second,
+		ProduceQueue:      produceQueue,
+		CountService:      countService,
+		OffsetHistory:     offsetHistory,
+		State:             stateStore,
+		History:           lagHistory,
 		Logger: logger.With(
 			zap.String("module", "aliveTopicsMaintainer"),
 		),
 	}
 
+	// Burrow notifier posts, for push mode
+	pushReceiver := &pipeline.PushReceiver{
+		IdleTimeout:   time.Duration(conf.Input.PushIdleSeconds) * time.Second,
+		ProduceQueue:  produceQueue,
+		CountService:  countService,
+		OffsetHistory: offsetHistory,
+		Emission:      pipeline.EmissionPolicy(conf.Translator.Emission),
+		StallWindow:   time.Duration(conf.Translator.StallWindowSeconds) * time.Second,
+		Logger: logger.With(
+			zap.String("module", "pushReceiver"),
+		),
+	}
+	if conf.Input.Mode == "both" {
+		// push metrics are tagged to compare with pull ones, state, history and alerts are fed by pull.
+		pushReceiver.Tags = map[string]string{"input": "push"}
+	} else {
+		pushReceiver.State = stateStore
+		pushReceiver.History = lagHistory
+		pushReceiver.Alerts = alertEngine
+	}
+
+	// Prepare sinks from config, sinks pulled over HTTP are served on health_check server
+	workers, err := sink.NewWorkers(contextProvider.GetSinks(), countService, logger.With(
+		zap.String("module", "sink"),
+	))
+	if err != nil {
+		panic("Err preparing sinks: " + err.Error())
+	}
+	for _, worker := range workers {
+		if httpSink, ok := worker.Sink.(sink.HTTPSink); ok {
+			http.HandleFunc(httpSink.Path(), httpSink.Handler())
+		}
+	}
+
 	producer := &pipeline.Producer{
 		ProduceQueue: produceQueue,
 		CountService: countService,
+		Workers:      workers,
 		Logger: logger.With(
 			zap.String("module", "producer"),
 		),
 	}
 
+	// Root context, cancelled on SIGTERM/SIGINT
+	ctx, cancel := context.WithCancel(context.Background())
+	signalChannel := make(chan os.Signal, 1)
+	signal.Notify(signalChannel, syscall.SIGTERM, syscall.SIGINT)
+
+	producer.Init()
+	alertEngine.Init()
 	go producer.Start()
-	go aliveConsumersMaintainer.Start()
-	go aliveTopicsMaintainer.Start()
+	go alertEngine.Start()
+
+	// inputs are stopped in order before alert engine and lag history, topic offsets are always pulled.
+	var inputs []stopper
+	if conf.Input.Mode != "push" {
+		aliveConsumersMaintainer.Init()
+		go aliveConsumersMaintainer.Start(ctx)
+		inputs = append(inputs, aliveConsumersMaintainer)
+	}
+	if conf.Input.Mode != "pull" {
+		pushReceiver.Init()
+		go pushReceiver.Start(ctx)
+		http.HandleFunc(conf.Input.PushPath, pushReceiver.Handler())
+		inputs = append(inputs, pushReceiver)
+	}
+	aliveTopicsMaintainer.Init()
+	go aliveTopicsMaintainer.Start(ctx)
+	inputs = append(inputs, aliveTopicsMaintainer, alertEngine, lagHistory)
 
 	// health_check server
 	healthCheckHandler := module.HealthChecker(countService)
 	http.HandleFunc("/health_check", healthCheckHandler)
-	http.ListenAndServe(":7099", nil)
+	http.HandleFunc("/api/v1/alerts", alertEngine.Handler())
+	http.HandleFunc(module.StateAPIPrefix, module.StateAPI(stateStore, lagHistory))
+	server := &http.Server{Addr: ":" + strconv.Itoa(conf.Server.Port)}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case sig := <-signalChannel:
+		logger.Warn("signal received, shutting down", zap.String("signal", sig.String()))
+	case err := <-serverErr:
+		logger.Error("health_check server exited, shutting down", zap.Error(err))
+	}
+	cancel()
+
+	timeout := time.Duration(conf.ShutdownTimeoutSeconds) * time.Second
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), timeout)
+	defer shutdownCancel()
+	server.Shutdown(shutdownCtx)
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- stopPipeline(inputs, countService, produceQueue, producer)
+	}()
+
+	exitCode := 0
+	select {
+	case err := <-stopped:
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "goRainbow exited, but some metrics may be lost:", err)
+			exitCode = 1
+		}
+	case <-shutdownCtx.Done():
+		fmt.Fprintln(os.Stderr, "goRainbow exited, ProduceQueue not drained in", timeout)
+		exitCode = 1
+	}
+	logger.Sync()
 
 	fmt.Println("goRainbow exited")
+	os.Exit(exitCode)
+}
 
+// stopper is a routine which exits after its context is cancelled.
+type stopper interface {
+	Stop() error
+}
+
+// stopPipeline stops routines from upstream to downstream, so no metric is sent to a closed queue,
+// and everything left in ProduceQueue is sent to sinks.
+func stopPipeline(inputs []stopper, countService *module.CountService, produceQueue chan protocol.Metric,
+	producer *pipeline.Producer) error {
+	for _, input := range inputs {
+		input.Stop()
+	}
 	countService.Stop()
 	close(produceQueue)
+	return producer.Stop()
+}
+
+// parseFlags puts command-line flags into env variables, which override config.json.
+func parseFlags() {
+	configPath := flag.String("config", "", "path of config.json, env configPath, default config/config.json")
+	overrides := make(map[string]*string)
+	for _, override := range util.ConfigOverrides {
+		overrides[override.Env] = flag.String(override.Flag, "", override.Usage+", env "+override.Env)
+	}
+	flag.Parse()
 
-	// exit cleanly
-	os.Exit(0)
+	if *configPath != "" {
+		os.Setenv("configPath", *configPath)
+	} else if os.Getenv("configPath") == "" {
+		os.Setenv("configPath", "config/config.json")
+	}
+	for env, value := range overrides {
+		if *value != "" {
+			os.Setenv(env, *value)
+		}
+	}
 }
 
 func handleExit() {